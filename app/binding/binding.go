@@ -0,0 +1,222 @@
+// Package binding decodes request bodies into structs and validates them.
+//
+// JSON bodies are decoded with encoding/json and its `json` struct tags.
+// URL-encoded and multipart forms fill the fields named by `form` tags, or
+// by the field name when there is none; multipart file fields have type
+// *multipart.FileHeader or []*multipart.FileHeader. Decoded values are then
+// checked against their `validate` tags, as described for Validate.
+//
+// Failures are *types.HTTPError values: 415 for an unsupported content
+// type, 413 for an oversized body, 400 for a body that cannot be decoded and
+// 422 for one that fails validation, with the offending fields listed.
+package binding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+const (
+	// DefaultMaxBodySize is the body size limit of a zero Binder.
+	DefaultMaxBodySize = 1 << 20
+	// DefaultMaxMemory is how much of a multipart body a zero Binder keeps
+	// in memory before spooling file parts to disk.
+	DefaultMaxMemory = 1 << 20
+)
+
+// Binder decodes and validates request bodies. The zero value is ready to
+// use and accepts unknown fields.
+type Binder struct {
+	// MaxBodySize limits the body, in bytes, whatever its content type.
+	// Zero means DefaultMaxBodySize.
+	MaxBodySize int64
+	// MaxMemory is how much of a multipart body is kept in memory; file
+	// parts beyond it are written to temporary files, which are removed
+	// when the request context ends. Zero means DefaultMaxMemory.
+	MaxMemory int64
+	// DisallowUnknownFields rejects bodies with fields dst has no place for.
+	DisallowUnknownFields bool
+}
+
+var defaultBinder Binder
+
+// Bind decodes req's body into dst, a pointer to a struct, according to its
+// Content-Type, and validates the result, using a zero Binder.
+func Bind(ctx context.Context, req types.Request, dst any) error {
+	return defaultBinder.Bind(ctx, req, dst)
+}
+
+// Bind decodes req's body into dst, a pointer to a struct, according to its
+// Content-Type, and validates the result. Passing anything else as dst is a
+// programming error and panics.
+func (b Binder) Bind(ctx context.Context, req types.Request, dst any) error {
+	if v := reflect.ValueOf(dst); v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("binding: destination must be a non-nil pointer to a struct, not %T", dst))
+	}
+	mediaType, params, err := mime.ParseMediaType(req.Header("Content-Type"))
+	if err != nil {
+		return types.WrapHTTPError(err, types.StatusUnsupportedMediaType, "missing or malformed Content-Type")
+	}
+	switch mediaType {
+	case "application/json":
+		err = b.decodeJSON(req, dst)
+	case "application/x-www-form-urlencoded":
+		err = b.decodeURLEncoded(req, dst)
+	case "multipart/form-data":
+		err = b.decodeMultipart(ctx, req, params["boundary"], dst)
+	default:
+		return types.NewHTTPError(types.StatusUnsupportedMediaType, fmt.Sprintf("unsupported content type %q", mediaType))
+	}
+	if err != nil {
+		return err
+	}
+	return Validate(dst)
+}
+
+func (b Binder) maxBodySize() int64 {
+	if b.MaxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+	return b.MaxBodySize
+}
+
+// errTooLarge is reported by bodyReader past the size limit.
+var errTooLarge = errors.New("request body too large")
+
+func tooLarge(limit int64) *types.HTTPError {
+	return types.WrapHTTPError(errTooLarge, types.StatusPayloadTooLarge, fmt.Sprintf("body exceeds %d bytes", limit))
+}
+
+// bodyReader returns req's body, failing with errTooLarge once more than
+// the size limit has been read.
+func (b Binder) bodyReader(req types.Request) io.Reader {
+	var r io.Reader = strings.NewReader("")
+	switch {
+	case req.Body != nil:
+		r = strings.NewReader(*req.Body)
+	case req.BodyReader != nil:
+		r = req.BodyReader
+	}
+	return types.LimitReader(r, b.maxBodySize(), errTooLarge)
+}
+
+func (b Binder) readBody(req types.Request) ([]byte, error) {
+	data, err := io.ReadAll(b.bodyReader(req))
+	if err != nil {
+		return nil, b.bodyError(err, "failed to read body")
+	}
+	return data, nil
+}
+
+// bodyError turns a failure to read or parse the body into an HTTP error.
+// Errors the server reports reading the body, such as its own size limit,
+// already are one and are kept.
+func (b Binder) bodyError(err error, message string) error {
+	if errors.Is(err, errTooLarge) {
+		return tooLarge(b.maxBodySize())
+	}
+	var he *types.HTTPError
+	if errors.As(err, &he) {
+		return err
+	}
+	return types.WrapHTTPError(err, types.StatusBadRequest, message)
+}
+
+func (b Binder) decodeJSON(req types.Request, dst any) error {
+	data, err := b.readBody(req)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if b.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(dst); err != nil {
+		return jsonError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return types.NewHTTPError(types.StatusBadRequest, "unexpected data after JSON value")
+	}
+	return nil
+}
+
+// jsonError describes a decoding error without Go type names.
+func jsonError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return types.WrapHTTPError(err, types.StatusBadRequest, fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset))
+	case errors.Is(err, io.EOF):
+		return types.WrapHTTPError(err, types.StatusBadRequest, "empty body")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return types.WrapHTTPError(err, types.StatusBadRequest, "malformed JSON")
+	case errors.As(err, &typeErr):
+		he := types.WrapHTTPError(err, types.StatusBadRequest, "invalid JSON body")
+		he.Fields = []types.FieldError{{Field: typeErr.Field, Message: "must be " + kindName(typeErr.Type)}}
+		return he
+	}
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		he := types.WrapHTTPError(err, types.StatusBadRequest, "invalid JSON body")
+		he.Fields = []types.FieldError{{Field: strings.Trim(name, `"`), Message: "unknown field"}}
+		return he
+	}
+	return types.WrapHTTPError(err, types.StatusBadRequest, "invalid JSON body")
+}
+
+func (b Binder) decodeURLEncoded(req types.Request, dst any) error {
+	data, err := b.readBody(req)
+	if err != nil {
+		return err
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return types.WrapHTTPError(err, types.StatusBadRequest, "malformed form body")
+	}
+	return decodeForm(values, nil, dst, b.DisallowUnknownFields)
+}
+
+func (b Binder) decodeMultipart(ctx context.Context, req types.Request, boundary string, dst any) error {
+	if boundary == "" {
+		return types.NewHTTPError(types.StatusBadRequest, "multipart body without boundary")
+	}
+	maxMemory := b.MaxMemory
+	if maxMemory <= 0 {
+		maxMemory = DefaultMaxMemory
+	}
+	form, err := multipart.NewReader(b.bodyReader(req), boundary).ReadForm(maxMemory)
+	if err != nil {
+		return b.bodyError(err, "malformed multipart body")
+	}
+	context.AfterFunc(ctx, func() { form.RemoveAll() })
+	return decodeForm(form.Value, form.File, dst, b.DisallowUnknownFields)
+}
+
+// kindName names the JSON type expected for t.
+func kindName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
@@ -0,0 +1,220 @@
+package binding
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type signup struct {
+	Name  string   `json:"name" form:"name" validate:"required,min=2,max=10"`
+	Email string   `json:"email" form:"email" validate:"required,regex=^[^@,]+@[^@,]+$"`
+	Age   *int     `json:"age" form:"age" validate:"min=18"`
+	Tags  []string `json:"tags" form:"tag" validate:"max=2"`
+	Note  *string  `json:"note" form:"note"`
+}
+
+func request(contentType, body string) types.Request {
+	return types.Request{
+		Method:     types.Post,
+		Headers:    map[string]string{"Content-Type": contentType},
+		BodyReader: strings.NewReader(body),
+	}
+}
+
+func httpError(t *testing.T, err error) *types.HTTPError {
+	t.Helper()
+	var he *types.HTTPError
+	require.True(t, errors.As(err, &he), "want *types.HTTPError, got %v", err)
+	return he
+}
+
+func TestBind_JSON(t *testing.T) {
+	var got signup
+	err := Bind(context.Background(), request("application/json; charset=utf-8",
+		`{"name":"Ann","email":"ann@example.com","age":30,"tags":["a"],"extra":1}`), &got)
+	require.NoError(t, err)
+	age := 30
+	assert.Equal(t, signup{Name: "Ann", Email: "ann@example.com", Age: &age, Tags: []string{"a"}}, got)
+}
+
+func TestBind_JSONErrors(t *testing.T) {
+	strict := Binder{DisallowUnknownFields: true, MaxBodySize: 64}
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus types.Status
+		wantFields []types.FieldError
+	}{
+		{"syntax", `{"name":`, types.StatusBadRequest, nil},
+		{"empty", ``, types.StatusBadRequest, nil},
+		{"trailing data", `{"name":"Ann","email":"a@b"} {}`, types.StatusBadRequest, nil},
+		{"wrong type", `{"age":"old"}`, types.StatusBadRequest, []types.FieldError{{Field: "age", Message: "must be an integer"}}},
+		{"unknown field", `{"name":"Ann","email":"a@b","extra":1}`, types.StatusBadRequest, []types.FieldError{{Field: "extra", Message: "unknown field"}}},
+		{"too large", `{"name":"` + strings.Repeat("a", 100) + `"}`, types.StatusPayloadTooLarge, nil},
+		{"invalid", `{"name":"A","email":"nope","age":12,"tags":["a","b","c"]}`, types.StatusUnprocessableEntity, []types.FieldError{
+			{Field: "name", Message: "must be at least 2 characters long"},
+			{Field: "email", Message: "must match ^[^@,]+@[^@,]+$"},
+			{Field: "age", Message: "must be at least 18"},
+			{Field: "tags", Message: "must be at most 2 items long"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got signup
+			he := httpError(t, strict.Bind(context.Background(), request("application/json", tt.body), &got))
+			assert.Equal(t, tt.wantStatus, he.Status)
+			assert.Equal(t, tt.wantFields, he.Fields)
+			assert.NotContains(t, he.Message, "signup", "messages do not leak Go type names")
+		})
+	}
+}
+
+func TestBind_KeepsBodyReadErrors(t *testing.T) {
+	serverLimit := types.NewHTTPError(types.StatusPayloadTooLarge, "request body exceeds 8 bytes")
+	for _, ct := range []string{"application/json", "multipart/form-data; boundary=x"} {
+		req := request(ct, "")
+		req.BodyReader = types.LimitReader(strings.NewReader(strings.Repeat("a", 100)), 8, serverLimit)
+		var got signup
+		err := Bind(context.Background(), req, &got)
+		assert.Same(t, serverLimit, httpError(t, err), ct)
+	}
+}
+
+func TestBind_UnsupportedContentType(t *testing.T) {
+	var got signup
+	for _, ct := range []string{"", "text/plain"} {
+		he := httpError(t, Bind(context.Background(), request(ct, "hi"), &got))
+		assert.Equal(t, types.StatusUnsupportedMediaType, he.Status)
+	}
+}
+
+func TestBind_URLEncodedForm(t *testing.T) {
+	var got signup
+	err := Bind(context.Background(), request("application/x-www-form-urlencoded",
+		"name=Bob&email=bob%40example.com&age=40&tag=x&tag=y&note=hi"), &got)
+	require.NoError(t, err)
+	note, age := "hi", 40
+	assert.Equal(t, signup{Name: "Bob", Email: "bob@example.com", Age: &age, Tags: []string{"x", "y"}, Note: &note}, got)
+
+	he := httpError(t, Binder{DisallowUnknownFields: true}.Bind(context.Background(),
+		request("application/x-www-form-urlencoded", "name=Bob&email=b%40c&age=x&color=red"), &got))
+	assert.Equal(t, types.StatusBadRequest, he.Status)
+	assert.Equal(t, []types.FieldError{
+		{Field: "age", Message: "must be an integer"},
+		{Field: "color", Message: "unknown field"},
+	}, he.Fields)
+
+	var missing signup
+	he = httpError(t, Bind(context.Background(), request("application/x-www-form-urlencoded", "age=20"), &missing))
+	assert.Equal(t, types.StatusUnprocessableEntity, he.Status)
+}
+
+func TestBind_Multipart(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	require.NoError(t, mw.WriteField("name", "Cy"))
+	require.NoError(t, mw.WriteField("email", "cy@example.com"))
+	fw, err := mw.CreateFormFile("avatar", "me.png")
+	require.NoError(t, err)
+	fw.Write(bytes.Repeat([]byte{1}, 4096))
+	require.NoError(t, mw.Close())
+
+	var got struct {
+		signup
+		Avatar *multipart.FileHeader `form:"avatar" validate:"required"`
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	err = Binder{MaxMemory: 1024}.Bind(ctx, request(mw.FormDataContentType(), body.String()), &got)
+	require.NoError(t, err)
+	require.NotNil(t, got.Avatar)
+	assert.Equal(t, "Cy", got.Name)
+	assert.Equal(t, "me.png", got.Avatar.Filename)
+	f, err := got.Avatar.Open()
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Len(t, data, 4096)
+	spooled, isFile := f.(*os.File)
+	f.Close()
+	require.True(t, isFile, "parts above MaxMemory are spooled to disk")
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(spooled.Name())
+		return errors.Is(err, os.ErrNotExist)
+	}, time.Second, 10*time.Millisecond, "spooled files are removed when the request ends")
+
+	he := httpError(t, Bind(context.Background(), request(mw.FormDataContentType(), body.String()[:body.Len()/2]), &got))
+	assert.Equal(t, types.StatusBadRequest, he.Status)
+
+	var anonymous struct {
+		signup
+		Avatar *multipart.FileHeader `form:"avatar" validate:"required"`
+	}
+	var empty bytes.Buffer
+	mw = multipart.NewWriter(&empty)
+	require.NoError(t, mw.Close())
+	he = httpError(t, Bind(context.Background(), request(mw.FormDataContentType(), empty.String()), &anonymous))
+	assert.Equal(t, types.StatusUnprocessableEntity, he.Status)
+	assert.Equal(t, []types.FieldError{
+		{Field: "name", Message: "is required"},
+		{Field: "email", Message: "is required"},
+		{Field: "avatar", Message: "is required"},
+	}, he.Fields, "embedded struct fields are validated too")
+}
+
+func TestValidate_Nested(t *testing.T) {
+	type item struct {
+		SKU string `json:"sku" validate:"required"`
+	}
+	type order struct {
+		Items []item `json:"items" validate:"required"`
+		Gift  *item  `json:"gift"`
+		Qty   uint   `validate:"max=5"`
+	}
+
+	he := httpError(t, Validate(&order{Items: []item{{"a"}, {}}, Gift: &item{}, Qty: 9}))
+	assert.Equal(t, []types.FieldError{
+		{Field: "items[1].sku", Message: "is required"},
+		{Field: "gift.sku", Message: "is required"},
+		{Field: "Qty", Message: "must be at most 5"},
+	}, he.Fields)
+	assert.NoError(t, Validate(&order{Items: []item{{"a"}}}))
+}
+
+func TestValidate_ZeroValues(t *testing.T) {
+	type filter struct {
+		Limit int     `json:"limit" validate:"min=1"`
+		Score *int    `json:"score" validate:"min=1"`
+		Query string  `json:"q" validate:"min=3"`
+		Ratio float64 `json:"ratio" validate:"max=1"`
+	}
+
+	he := httpError(t, Validate(&filter{}))
+	assert.Equal(t, []types.FieldError{{Field: "limit", Message: "must be at least 1"}}, he.Fields,
+		"zero numbers are checked; nil pointers and empty strings are not")
+
+	zero := 0
+	he = httpError(t, Validate(&filter{Limit: 1, Score: &zero}))
+	assert.Equal(t, []types.FieldError{{Field: "score", Message: "must be at least 1"}}, he.Fields)
+	assert.NoError(t, Validate(&filter{Limit: 1}))
+}
+
+func TestValidate_MalformedTagPanics(t *testing.T) {
+	assert.Panics(t, func() {
+		Validate(&struct {
+			X string `validate:"sometimes"`
+		}{X: "x"})
+	})
+}
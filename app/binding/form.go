@@ -0,0 +1,172 @@
+package binding
+
+import (
+	"fmt"
+	"mime/multipart"
+	"reflect"
+	"slices"
+	"strconv"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+var (
+	fileHeaderType  = reflect.TypeFor[*multipart.FileHeader]()
+	fileHeadersType = reflect.TypeFor[[]*multipart.FileHeader]()
+)
+
+// decodeForm fills the struct dst points to from form values and files.
+// Fields of unsupported types are a programming error and panic.
+func decodeForm(values map[string][]string, files map[string][]*multipart.FileHeader, dst any, disallowUnknown bool) error {
+	var fieldErrs []types.FieldError
+	known := make(map[string]bool)
+	fillForm(reflect.ValueOf(dst).Elem(), values, files, known, &fieldErrs)
+
+	if disallowUnknown {
+		for _, name := range unknownKeys(values, files, known) {
+			fieldErrs = append(fieldErrs, types.FieldError{Field: name, Message: "unknown field"})
+		}
+	}
+	if len(fieldErrs) > 0 {
+		he := types.NewHTTPError(types.StatusBadRequest, "invalid form data")
+		he.Fields = fieldErrs
+		return he
+	}
+	return nil
+}
+
+// fillForm sets the fields of struct v, including those of embedded
+// structs, and records the keys it used in known.
+func fillForm(v reflect.Value, values map[string][]string, files map[string][]*multipart.FileHeader, known map[string]bool, errs *[]types.FieldError) {
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		field := v.Field(i)
+		if isEmbeddedStruct(sf) {
+			fillForm(field, values, files, known, errs)
+			continue
+		}
+		name := formName(sf)
+		if name == "" {
+			continue
+		}
+		known[name] = true
+
+		switch sf.Type {
+		case fileHeaderType:
+			if fhs := files[name]; len(fhs) > 0 {
+				field.Set(reflect.ValueOf(fhs[0]))
+			}
+			continue
+		case fileHeadersType:
+			if fhs := files[name]; len(fhs) > 0 {
+				field.Set(reflect.ValueOf(fhs))
+			}
+			continue
+		}
+
+		vals, ok := values[name]
+		if !ok || len(vals) == 0 {
+			continue
+		}
+		if err := setField(field, vals); err != nil {
+			*errs = append(*errs, types.FieldError{Field: name, Message: err.Error()})
+		}
+	}
+}
+
+// isEmbeddedStruct reports whether sf is an untagged embedded struct, whose
+// fields are treated as fields of the outer struct.
+func isEmbeddedStruct(sf reflect.StructField) bool {
+	_, tagged := sf.Tag.Lookup("form")
+	return sf.Anonymous && !tagged && sf.Type.Kind() == reflect.Struct
+}
+
+// formName returns the form key of a struct field, or "" if it has none.
+func formName(sf reflect.StructField) string {
+	if !sf.IsExported() {
+		return ""
+	}
+	name, ok := sf.Tag.Lookup("form")
+	if name == "-" {
+		return ""
+	}
+	if !ok || name == "" {
+		return sf.Name
+	}
+	return name
+}
+
+func unknownKeys(values map[string][]string, files map[string][]*multipart.FileHeader, known map[string]bool) []string {
+	var unknown []string
+	for k := range values {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	for k := range files {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	slices.Sort(unknown)
+	return unknown
+}
+
+// setField stores vals in field, which holds a scalar, a pointer to one or
+// a slice of them.
+func setField(field reflect.Value, vals []string) error {
+	switch field.Kind() {
+	case reflect.Slice:
+		s := reflect.MakeSlice(field.Type(), len(vals), len(vals))
+		for i, val := range vals {
+			if err := setScalar(s.Index(i), val); err != nil {
+				return err
+			}
+		}
+		field.Set(s)
+		return nil
+	case reflect.Pointer:
+		p := reflect.New(field.Type().Elem())
+		if err := setScalar(p.Elem(), vals[0]); err != nil {
+			return err
+		}
+		field.Set(p)
+		return nil
+	}
+	return setScalar(field, vals[0])
+}
+
+func setScalar(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("must be a boolean")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a non-negative integer")
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		v.SetFloat(f)
+	default:
+		panic(fmt.Sprintf("binding: unsupported form field type %s", v.Type()))
+	}
+	return nil
+}
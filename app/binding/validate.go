@@ -0,0 +1,198 @@
+package binding
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// Validate checks the struct v points to against the rules in its fields'
+// `validate` tags, descending into nested and embedded structs and slices of
+// them. Rules are separated by commas:
+//
+//	required   the value must not be the zero value
+//	min=N      strings need N characters, slices and maps N elements and
+//	           numbers a value of at least N
+//	max=N      the same, at most N
+//	regex=RE   strings must match RE; it takes the rest of the tag, so it
+//	           comes last and may contain commas
+//
+// Rules other than required are skipped for empty strings and collections
+// and nil pointers, so such fields are only checked when present. Numbers
+// are always checked, as zero is a value like any other; make a numeric
+// field a pointer to let it be left out. Fields are named in errors by their
+// json, then form tag, falling back to the Go name. A malformed tag is a
+// programming error and panics. Failures are a 422 *types.HTTPError listing
+// every offending field.
+func Validate(v any) error {
+	var fieldErrs []types.FieldError
+	validateValue(reflect.ValueOf(v), "", &fieldErrs)
+	if len(fieldErrs) > 0 {
+		he := types.NewHTTPError(types.StatusUnprocessableEntity, "validation failed")
+		he.Fields = fieldErrs
+		return he
+	}
+	return nil
+}
+
+func validateValue(v reflect.Value, path string, errs *[]types.FieldError) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			sf := t.Field(i)
+			if sf.Anonymous && sf.Type.Kind() == reflect.Struct && sf.Tag.Get("validate") == "" {
+				validateValue(v.Field(i), path, errs)
+				continue
+			}
+			if !sf.IsExported() {
+				continue
+			}
+			name := fieldName(sf)
+			if path != "" {
+				name = path + "." + name
+			}
+			field := v.Field(i)
+			if tag := sf.Tag.Get("validate"); tag != "" {
+				if msg := checkRules(field, parseRules(tag)); msg != "" {
+					*errs = append(*errs, types.FieldError{Field: name, Message: msg})
+					continue
+				}
+			}
+			validateValue(field, name, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+// fieldName names a struct field the way clients know it.
+func fieldName(sf reflect.StructField) string {
+	if name, _, _ := strings.Cut(sf.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	if name := sf.Tag.Get("form"); name != "" && name != "-" {
+		return name
+	}
+	return sf.Name
+}
+
+type rule struct {
+	name string
+	arg  string
+	re   *regexp.Regexp
+}
+
+var ruleCache sync.Map // tag string -> []rule
+
+func parseRules(tag string) []rule {
+	if rules, ok := ruleCache.Load(tag); ok {
+		return rules.([]rule)
+	}
+	var rules []rule
+	rest := tag
+	for rest != "" {
+		var item string
+		if strings.HasPrefix(rest, "regex=") {
+			item, rest = rest, ""
+		} else {
+			item, rest, _ = strings.Cut(rest, ",")
+		}
+		name, arg, _ := strings.Cut(strings.TrimSpace(item), "=")
+		r := rule{name: name, arg: arg}
+		switch name {
+		case "required":
+		case "min", "max":
+			if _, err := strconv.ParseFloat(arg, 64); err != nil {
+				panic(fmt.Sprintf("binding: invalid %s rule in validate tag %q", name, tag))
+			}
+		case "regex":
+			r.re = regexp.MustCompile(arg)
+		default:
+			panic(fmt.Sprintf("binding: unknown rule %q in validate tag %q", name, tag))
+		}
+		rules = append(rules, r)
+	}
+	ruleCache.Store(tag, rules)
+	return rules
+}
+
+// checkRules returns why v breaks rules, or "" if it does not.
+func checkRules(v reflect.Value, rules []rule) string {
+	if v.IsZero() {
+		for _, r := range rules {
+			if r.name == "required" {
+				return "is required"
+			}
+		}
+		if _, _, isNumber := measureNumber(v); !isNumber {
+			return ""
+		}
+	}
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	for _, r := range rules {
+		switch r.name {
+		case "min", "max":
+			limit, _ := strconv.ParseFloat(r.arg, 64)
+			size, unit, ok := measure(v)
+			if !ok {
+				panic(fmt.Sprintf("binding: %s rule on unsupported type %s", r.name, v.Type()))
+			}
+			if r.name == "min" && size < limit {
+				return fmt.Sprintf("must be at least %s%s", r.arg, unit)
+			}
+			if r.name == "max" && size > limit {
+				return fmt.Sprintf("must be at most %s%s", r.arg, unit)
+			}
+		case "regex":
+			if v.Kind() != reflect.String {
+				panic(fmt.Sprintf("binding: regex rule on non-string type %s", v.Type()))
+			}
+			if !r.re.MatchString(v.String()) {
+				return "must match " + r.re.String()
+			}
+		}
+	}
+	return ""
+}
+
+// measure returns what min and max compare for v, and the unit to name in
+// messages.
+func measure(v reflect.Value) (float64, string, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), " characters long", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), " items long", true
+	}
+	return measureNumber(v)
+}
+
+// measureNumber is measure for numbers; ok is false for other kinds.
+func measureNumber(v reflect.Value) (float64, string, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), "", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), "", true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), "", true
+	}
+	return 0, "", false
+}
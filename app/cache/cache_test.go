@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countingHandler(calls *int, headers map[string]string) types.Handler {
+	return func(ctx context.Context, req types.Request, res *types.Response) {
+		*calls++
+		res.Status = types.StatusOK
+		res.Body = []byte("body for " + req.Target + " " + req.Header("Accept-Language"))
+		for k, v := range headers {
+			res.Headers[k] = v
+		}
+	}
+}
+
+func serve(h types.Handler, req types.Request) types.Response {
+	res := types.Response{Status: types.StatusOK, Headers: make(map[string]string)}
+	if req.Method == "" {
+		req.Method = types.Get
+	}
+	if req.Headers == nil {
+		req.Headers = make(map[string]string)
+	}
+	h(context.Background(), req, &res)
+	return res
+}
+
+func TestPolicyCacheControl(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   string
+	}{
+		{"public max-age", Policy{Public: true, MaxAge: time.Hour}, "public, max-age=3600"},
+		{"private wins over public", Policy{Public: true, Private: true, MaxAge: time.Minute}, "private, max-age=60"},
+		{"no-store drops max-age", Policy{NoStore: true, MaxAge: time.Hour}, "no-store"},
+		{"shared and immutable", Policy{Public: true, MaxAge: time.Minute, SharedMaxAge: time.Hour, Immutable: true}, "public, max-age=60, s-maxage=3600, immutable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.CacheControl())
+		})
+	}
+}
+
+func TestControl(t *testing.T) {
+	var calls int
+	h := Control(Policy{Public: true, MaxAge: time.Minute, Vary: []string{"Accept-Language"}})(
+		countingHandler(&calls, map[string]string{"Vary": "Accept-Encoding"}))
+
+	res := serve(h, types.Request{Target: "/"})
+	assert.Equal(t, "public, max-age=60", res.Headers["Cache-Control"])
+	assert.Equal(t, "Accept-Encoding, Accept-Language", res.Headers["Vary"])
+	expires, err := time.Parse(httpDate, res.Headers["Expires"])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expires, 2*time.Second)
+
+	h = Control(Policy{MaxAge: time.Minute})(countingHandler(&calls, map[string]string{"Cache-Control": "no-store"}))
+	res = serve(h, types.Request{Target: "/"})
+	assert.Equal(t, "no-store", res.Headers["Cache-Control"], "handler header should take precedence")
+	assert.NotContains(t, res.Headers, "Expires")
+}
+
+func TestStoreHitAndQueryKey(t *testing.T) {
+	var calls int
+	s := NewStore(10, 0)
+	h := s.Middleware(time.Minute)(countingHandler(&calls, nil))
+
+	first := serve(h, types.Request{Target: "/a?x=1"})
+	second := serve(h, types.Request{Target: "/a?x=1"})
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, first.Body, second.Body)
+	assert.Equal(t, "0", second.Headers["Age"])
+
+	serve(h, types.Request{Target: "/a?x=2"})
+	assert.Equal(t, 2, calls, "different query should miss")
+
+	serve(h, types.Request{Method: types.Post, Target: "/a?x=1"})
+	assert.Equal(t, 3, calls, "POST should never be served from cache")
+}
+
+func TestStoreVary(t *testing.T) {
+	var calls int
+	s := NewStore(10, 0)
+	h := s.Middleware(time.Minute)(countingHandler(&calls, map[string]string{"Vary": "Accept-Language"}))
+
+	en := types.Request{Target: "/v", Headers: map[string]string{"Accept-Language": "en"}}
+	fr := types.Request{Target: "/v", Headers: map[string]string{"accept-language": "fr"}}
+
+	serve(h, en)
+	serve(h, fr)
+	resEn := serve(h, en)
+	resFr := serve(h, fr)
+	assert.Equal(t, 2, calls)
+	assert.True(t, strings.HasSuffix(string(resEn.Body), "en"))
+	assert.True(t, strings.HasSuffix(string(resFr.Body), "fr"))
+}
+
+func TestStoreRespectsNoStoreAndPrivate(t *testing.T) {
+	for _, cc := range []string{"no-store", "private, max-age=60", "no-cache"} {
+		t.Run(cc, func(t *testing.T) {
+			var calls int
+			s := NewStore(10, 0)
+			h := s.Middleware(time.Minute)(countingHandler(&calls, map[string]string{"Cache-Control": cc}))
+			serve(h, types.Request{Target: "/p"})
+			serve(h, types.Request{Target: "/p"})
+			assert.Equal(t, 2, calls)
+			assert.Equal(t, 0, s.Len())
+		})
+	}
+
+	var calls int
+	s := NewStore(10, 0)
+	h := s.Middleware(time.Minute)(countingHandler(&calls, nil))
+	serve(h, types.Request{Target: "/r", Headers: map[string]string{"Cache-Control": "no-store"}})
+	assert.Equal(t, 0, s.Len(), "request no-store should not populate the cache")
+}
+
+func TestStoreTTLAndMaxAge(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var calls int
+	s := NewStore(10, 0)
+	s.now = func() time.Time { return now }
+	h := s.Middleware(time.Minute)(countingHandler(&calls, nil))
+
+	serve(h, types.Request{Target: "/t"})
+	now = now.Add(30 * time.Second)
+	res := serve(h, types.Request{Target: "/t"})
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "30", res.Headers["Age"])
+
+	now = now.Add(31 * time.Second)
+	serve(h, types.Request{Target: "/t"})
+	assert.Equal(t, 2, calls, "expired entry should be refetched")
+
+	h = s.Middleware(time.Minute)(countingHandler(&calls, map[string]string{"Cache-Control": "max-age=5"}))
+	serve(h, types.Request{Target: "/m"})
+	now = now.Add(6 * time.Second)
+	serve(h, types.Request{Target: "/m"})
+	assert.Equal(t, 4, calls, "response max-age should override the default ttl")
+}
+
+func TestStoreLRUEviction(t *testing.T) {
+	var calls int
+	s := NewStore(2, 0)
+	h := s.Middleware(time.Minute)(countingHandler(&calls, nil))
+
+	serve(h, types.Request{Target: "/1"})
+	serve(h, types.Request{Target: "/2"})
+	serve(h, types.Request{Target: "/1"}) // touch /1 so /2 is least recently used
+	serve(h, types.Request{Target: "/3"})
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, 3, calls)
+
+	serve(h, types.Request{Target: "/1"})
+	assert.Equal(t, 3, calls, "/1 should still be cached")
+	serve(h, types.Request{Target: "/2"})
+	assert.Equal(t, 4, calls, "/2 should have been evicted")
+}
+
+func TestStoreMaxBytes(t *testing.T) {
+	var calls int
+	s := NewStore(0, 20)
+	h := s.Middleware(time.Minute)(countingHandler(&calls, nil))
+
+	serve(h, types.Request{Target: "/a"})
+	serve(h, types.Request{Target: "/b"})
+	assert.Equal(t, 1, s.Len(), "size limit should evict the older entry")
+	assert.LessOrEqual(t, s.size, int64(20))
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// httpDate is the IMF-fixdate layout required for HTTP date headers.
+const httpDate = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Policy describes the caching headers sent with every response of a route
+// group.
+type Policy struct {
+	MaxAge         time.Duration
+	SharedMaxAge   time.Duration
+	Public         bool
+	Private        bool
+	NoCache        bool
+	NoStore        bool
+	MustRevalidate bool
+	Immutable      bool
+	// Vary lists the request headers that select between representations.
+	Vary []string
+}
+
+// CacheControl renders the policy as a Cache-Control header value.
+func (p Policy) CacheControl() string {
+	var directives []string
+	if p.NoStore {
+		directives = append(directives, "no-store")
+	}
+	if p.NoCache {
+		directives = append(directives, "no-cache")
+	}
+	if p.Private {
+		directives = append(directives, "private")
+	} else if p.Public {
+		directives = append(directives, "public")
+	}
+	if !p.NoStore {
+		directives = append(directives, "max-age="+strconv.Itoa(int(p.MaxAge/time.Second)))
+		if p.SharedMaxAge > 0 {
+			directives = append(directives, "s-maxage="+strconv.Itoa(int(p.SharedMaxAge/time.Second)))
+		}
+	}
+	if p.MustRevalidate {
+		directives = append(directives, "must-revalidate")
+	}
+	if p.Immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
+
+// Control returns a middleware that sets Cache-Control, Expires and Vary on
+// every response according to p. A Cache-Control header set by the handler
+// itself takes precedence over the policy.
+func Control(p Policy) types.Middleware {
+	return func(next types.Handler) types.Handler {
+		return func(ctx context.Context, req types.Request, res *types.Response) {
+			next(ctx, req, res)
+			if res.Headers == nil {
+				res.Headers = make(map[string]string)
+			}
+			if len(p.Vary) > 0 {
+				res.Headers["Vary"] = types.AddVary(res.Headers["Vary"], p.Vary...)
+			}
+			if _, ok := res.Headers["Cache-Control"]; ok {
+				return
+			}
+			res.Headers["Cache-Control"] = p.CacheControl()
+			if p.NoStore || p.NoCache || p.MaxAge <= 0 {
+				res.Headers["Expires"] = "0"
+			} else {
+				res.Headers["Expires"] = time.Now().Add(p.MaxAge).UTC().Format(httpDate)
+			}
+		}
+	}
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// directives parses a Cache-Control header into lower-cased directive names
+// mapped to their (possibly empty) values.
+func directives(v string) map[string]string {
+	out := make(map[string]string)
+	for _, part := range splitList(v) {
+		name, value, _ := strings.Cut(part, "=")
+		out[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return out
+}
@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+type entry struct {
+	key     string
+	base    string
+	status  types.Status
+	headers map[string]string
+	body    []byte
+	stored  time.Time
+	expires time.Time
+	size    int64
+}
+
+type varyInfo struct {
+	names []string
+	refs  int
+}
+
+// Store is an in-memory response cache with per-entry TTLs and LRU eviction
+// bounded by entry count and total size.
+type Store struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	size       int64
+	ll         *list.List
+	entries    map[string]*list.Element
+	vary       map[string]*varyInfo
+	now        func() time.Time
+}
+
+// NewStore creates a Store holding at most maxEntries responses and maxBytes
+// of headers and bodies. A zero limit disables that bound.
+func NewStore(maxEntries int, maxBytes int64) *Store {
+	return &Store{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+		vary:       make(map[string]*varyInfo),
+		now:        time.Now,
+	}
+}
+
+// Middleware returns a middleware that serves GET requests from the store and
+// stores cacheable 200 responses for ttl, unless the response carries its own
+// max-age or s-maxage. Responses marked no-store, no-cache or private, and
+// streamed responses, are never stored.
+func (s *Store) Middleware(ttl time.Duration) types.Middleware {
+	return func(next types.Handler) types.Handler {
+		return func(ctx context.Context, req types.Request, res *types.Response) {
+			if req.Method != types.Get {
+				next(ctx, req, res)
+				return
+			}
+			reqDirectives := directives(req.Header("Cache-Control"))
+			if _, ok := reqDirectives["no-store"]; ok {
+				next(ctx, req, res)
+				return
+			}
+
+			base := baseKey(req)
+			if _, ok := reqDirectives["no-cache"]; !ok {
+				if e, ok := s.get(base, req); ok {
+					s.fill(res, e)
+					return
+				}
+			}
+
+			next(ctx, req, res)
+			s.put(base, req, *res, ttl)
+		}
+	}
+}
+
+// Len returns the number of cached responses.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+func baseKey(req types.Request) string {
+	path, query, _ := strings.Cut(req.Target, "?")
+	return string(req.Method) + " " + path + "?" + query
+}
+
+func fullKey(base string, names []string, req types.Request) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, n := range names {
+		b.WriteString("\n")
+		b.WriteString(n)
+		b.WriteString(":")
+		b.WriteString(req.Header(n))
+	}
+	return b.String()
+}
+
+func (s *Store) get(base string, req types.Request) (*entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.vary[base]
+	if !ok {
+		return nil, false
+	}
+	el, ok := s.entries[fullKey(base, v.names, req)]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if !s.now().Before(e.expires) {
+		s.remove(el)
+		return nil, false
+	}
+	s.ll.MoveToFront(el)
+	return e, true
+}
+
+func (s *Store) fill(res *types.Response, e *entry) {
+	res.Status = e.status
+	res.Body = e.body
+	res.BodyReader = nil
+	res.Headers = make(map[string]string, len(e.headers)+1)
+	for k, v := range e.headers {
+		res.Headers[k] = v
+	}
+	res.Headers["Age"] = strconv.Itoa(int(s.now().Sub(e.stored) / time.Second))
+}
+
+func (s *Store) put(base string, req types.Request, res types.Response, ttl time.Duration) {
+	if res.Status != types.StatusOK || res.BodyReader != nil {
+		return
+	}
+	resDirectives := directives(res.Headers["Cache-Control"])
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := resDirectives[d]; ok {
+			return
+		}
+	}
+	lifetime := ttl
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := resDirectives[d]; ok {
+			if secs, err := strconv.Atoi(v); err == nil {
+				lifetime = time.Duration(secs) * time.Second
+				break
+			}
+		}
+	}
+	if lifetime <= 0 {
+		return
+	}
+
+	names := splitList(res.Headers["Vary"])
+	for i, n := range names {
+		if n == "*" {
+			return
+		}
+		names[i] = strings.ToLower(n)
+	}
+	sort.Strings(names)
+
+	e := &entry{
+		base:    base,
+		status:  res.Status,
+		headers: make(map[string]string, len(res.Headers)),
+		body:    append([]byte(nil), res.Body...),
+	}
+	e.size = int64(len(e.body))
+	for k, v := range res.Headers {
+		e.headers[k] = v
+		e.size += int64(len(k) + len(v))
+	}
+	if s.maxBytes > 0 && e.size > s.maxBytes {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A new Vary list for this resource invalidates the old variants.
+	if v, ok := s.vary[base]; ok && !equalNames(v.names, names) {
+		for _, el := range s.entries {
+			if el.Value.(*entry).base == base {
+				s.remove(el)
+			}
+		}
+	}
+
+	e.key = fullKey(base, names, req)
+	e.stored = s.now()
+	e.expires = e.stored.Add(lifetime)
+	if old, ok := s.entries[e.key]; ok {
+		s.remove(old)
+	}
+	v, ok := s.vary[base]
+	if !ok {
+		v = &varyInfo{names: names}
+		s.vary[base] = v
+	}
+	v.refs++
+	s.entries[e.key] = s.ll.PushFront(e)
+	s.size += e.size
+
+	for (s.maxEntries > 0 && s.ll.Len() > s.maxEntries) || (s.maxBytes > 0 && s.size > s.maxBytes) {
+		s.remove(s.ll.Back())
+	}
+}
+
+func (s *Store) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	s.ll.Remove(el)
+	delete(s.entries, e.key)
+	s.size -= e.size
+	if v, ok := s.vary[e.base]; ok {
+		v.refs--
+		if v.refs <= 0 {
+			delete(s.vary, e.base)
+		}
+	}
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,184 @@
+// Package errorpage renders the default bodies of error responses as plain
+// text, HTML or RFC 7807 problem details, whichever the client prefers.
+package errorpage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// Media types an error body can be rendered as.
+const (
+	TextType    = "text/plain; charset=utf-8"
+	HTMLType    = "text/html; charset=utf-8"
+	ProblemType = "application/problem+json"
+)
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Errors is an extension member listing problems with individual
+	// fields of the request.
+	Errors []types.FieldError `json:"errors,omitempty"`
+}
+
+// Write sets res to status with a body describing it, in the format the
+// request's Accept header prefers. detail and fields are shown to the client,
+// so they must not reveal internals; both may be empty. Plain text is used
+// when the client expresses no preference. The body res had is discarded.
+func Write(req types.Request, res *types.Response, status types.Status, detail string, fields ...types.FieldError) {
+	if res.Headers == nil {
+		res.Headers = make(map[string]string)
+	}
+	res.DiscardBody()
+	res.Status = status
+	res.Headers["Vary"] = types.AddVary(res.Headers["Vary"], "Accept")
+	delete(res.Headers, "Content-Length")
+	delete(res.Headers, "Content-Encoding")
+
+	title := status.Reason()
+	switch Negotiate(req.Header("Accept")) {
+	case ProblemType:
+		p := Problem{
+			Type:     "about:blank",
+			Title:    title,
+			Status:   status.Code(),
+			Detail:   detail,
+			Instance: req.Target,
+			Errors:   fields,
+		}
+		body, _ := json.Marshal(p)
+		res.Headers["Content-Type"] = ProblemType
+		res.Body = body
+	case HTMLType:
+		heading := html.EscapeString(fmt.Sprintf("%d %s", status.Code(), title))
+		var b strings.Builder
+		fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head><title>%s</title></head>\n<body>\n<h1>%s</h1>\n", heading, heading)
+		if detail != "" {
+			fmt.Fprintf(&b, "<p>%s</p>\n", html.EscapeString(detail))
+		}
+		if len(fields) > 0 {
+			b.WriteString("<ul>\n")
+			for _, f := range fields {
+				fmt.Fprintf(&b, "<li><code>%s</code>: %s</li>\n", html.EscapeString(f.Field), html.EscapeString(f.Message))
+			}
+			b.WriteString("</ul>\n")
+		}
+		b.WriteString("</body>\n</html>\n")
+		res.Headers["Content-Type"] = HTMLType
+		res.Body = []byte(b.String())
+	default:
+		text := fmt.Sprintf("%d %s", status.Code(), title)
+		if detail != "" {
+			text += ": " + detail
+		}
+		for _, f := range fields {
+			text += "\n" + f.Field + ": " + f.Message
+		}
+		res.Headers["Content-Type"] = TextType
+		res.Body = []byte(text)
+	}
+}
+
+// Handler returns a handler answering every request with the default page
+// for status.
+func Handler(status types.Status) types.Handler {
+	return func(ctx context.Context, req types.Request, res *types.Response) {
+		Write(req, res, status, "")
+	}
+}
+
+// offers are the formats Write can produce, most preferred first for
+// clients that rank several of them equally.
+var offers = []struct {
+	contentType string
+	ranges      []string
+}{
+	{TextType, []string{"text/plain"}},
+	{HTMLType, []string{"text/html"}},
+	{ProblemType, []string{"application/problem+json", "application/json"}},
+}
+
+// Negotiate returns the content type Write uses for the given Accept header
+// value: the offer with the highest quality, ties going to plain text, then
+// HTML. Accepting nothing it offers also gets plain text.
+func Negotiate(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return TextType
+	}
+	ranges := parseAccept(accept)
+	best, bestQ := TextType, 0.0
+	for _, o := range offers {
+		q := quality(ranges, o.ranges)
+		if q > bestQ {
+			best, bestQ = o.contentType, q
+		}
+	}
+	return best
+}
+
+type mediaRange struct {
+	name string
+	q    float64
+}
+
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && parsed >= 0 && parsed <= 1 {
+					q = parsed
+				}
+			}
+		}
+		ranges = append(ranges, mediaRange{name: name, q: q})
+	}
+	return ranges
+}
+
+// quality returns how acceptable an offer known by the given media range
+// names is. An exact match beats a subtype wildcard, which beats */*.
+func quality(ranges []mediaRange, names []string) float64 {
+	best, bestSpecificity := 0.0, -1
+	for _, r := range ranges {
+		specificity := -1
+		switch {
+		case r.name == "*/*":
+			specificity = 0
+		case strings.HasSuffix(r.name, "/*"):
+			for _, n := range names {
+				if strings.HasPrefix(n, strings.TrimSuffix(r.name, "*")) {
+					specificity = 1
+				}
+			}
+		default:
+			for _, n := range names {
+				if n == r.name {
+					specificity = 2
+				}
+			}
+		}
+		if specificity > bestSpecificity || (specificity == bestSpecificity && specificity >= 0 && r.q > best) {
+			best, bestSpecificity = r.q, specificity
+		}
+	}
+	return best
+}
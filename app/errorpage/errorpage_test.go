@@ -0,0 +1,95 @@
+package errorpage
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", TextType},
+		{"*/*", TextType},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", HTMLType},
+		{"application/json", ProblemType},
+		{"application/problem+json", ProblemType},
+		{"application/*", ProblemType},
+		{"text/*", TextType},
+		{"text/html;q=0.5, application/json;q=0.9", ProblemType},
+		{"text/plain;q=0, */*", HTMLType},
+		{"image/png", TextType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			assert.Equal(t, tt.want, Negotiate(tt.accept))
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	req := func(accept string) types.Request {
+		return types.Request{Target: "/files/x", Headers: map[string]string{"Accept": accept}}
+	}
+
+	var res types.Response
+	Write(req("text/plain"), &res, types.StatusNotFound, "")
+	assert.Equal(t, types.StatusNotFound, res.Status)
+	assert.Equal(t, TextType, res.Headers["Content-Type"])
+	assert.Equal(t, "Accept", res.Headers["Vary"])
+	assert.Equal(t, "404 Not Found", string(res.Body))
+
+	res = types.Response{Headers: map[string]string{"Vary": "Accept-Encoding"}}
+	Write(req("text/html"), &res, types.StatusBadRequest, "bad <header>")
+	assert.Equal(t, HTMLType, res.Headers["Content-Type"])
+	assert.Equal(t, "Accept-Encoding, Accept", res.Headers["Vary"])
+	assert.Contains(t, string(res.Body), "<h1>400 Bad Request</h1>")
+	assert.Contains(t, string(res.Body), "<p>bad &lt;header&gt;</p>")
+
+	res = types.Response{}
+	Write(req("application/json"), &res, types.StatusMethodNotAllowed, "try GET")
+	assert.Equal(t, ProblemType, res.Headers["Content-Type"])
+	var p Problem
+	require.NoError(t, json.Unmarshal(res.Body, &p))
+	assert.Equal(t, Problem{
+		Type:     "about:blank",
+		Title:    "Method Not Allowed",
+		Status:   405,
+		Detail:   "try GET",
+		Instance: "/files/x",
+	}, p)
+}
+
+func TestWrite_FieldErrors(t *testing.T) {
+	fields := []types.FieldError{{Field: "name", Message: "is required"}, {Field: "<b>", Message: "bad"}}
+	req := func(accept string) types.Request {
+		return types.Request{Target: "/signup", Headers: map[string]string{"Accept": accept}}
+	}
+
+	var res types.Response
+	Write(req(""), &res, types.StatusUnprocessableEntity, "validation failed", fields...)
+	assert.Equal(t, "422 Unprocessable Entity: validation failed\nname: is required\n<b>: bad", string(res.Body))
+
+	Write(req("text/html"), &res, types.StatusUnprocessableEntity, "validation failed", fields...)
+	assert.Contains(t, string(res.Body), "<li><code>&lt;b&gt;</code>: bad</li>")
+
+	Write(req("application/problem+json"), &res, types.StatusUnprocessableEntity, "validation failed", fields...)
+	var p Problem
+	require.NoError(t, json.Unmarshal(res.Body, &p))
+	assert.Equal(t, fields, p.Errors)
+}
+
+func TestWrite_ClosesPreviousBody(t *testing.T) {
+	pr, pw := io.Pipe()
+	res := types.Response{BodyReader: pr}
+	Write(types.Request{}, &res, types.StatusInternalServerError, "")
+	assert.Nil(t, res.BodyReader)
+	_, err := pw.Write([]byte("late"))
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+}
@@ -2,18 +2,35 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/codecrafters-io/http-server-starter-go/app/router"
 	"github.com/codecrafters-io/http-server-starter-go/app/server"
+	"github.com/codecrafters-io/http-server-starter-go/app/tracing"
 	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/codecrafters-io/http-server-starter-go/app/upload"
+	"github.com/codecrafters-io/http-server-starter-go/app/websocket"
 )
 
-var directory string
+var (
+	directory    string
+	certFile     string
+	keyFile      string
+	otlpEndpoint string
+)
 
 func main() {
 	flag.StringVar(&directory, "directory", "/tmp", "directory to serve files from")
+	flag.StringVar(&certFile, "cert", "", "TLS certificate file; enables HTTPS together with -key")
+	flag.StringVar(&keyFile, "key", "", "TLS private key file")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP traces URL to export spans to, e.g. "+tracing.DefaultOTLPEndpoint)
 	flag.Parse()
 
 	fmt.Println("Logs from your program will appear here!")
@@ -21,40 +38,109 @@ func main() {
 	r := router.New()
 
 	r.Register(types.Get, "/", func(ctx context.Context, req types.Request, res *types.Response) {
-		res.Status = types.StatusOK
-		res.Body = []byte("Hello, World!")
-		res.Headers["Content-Type"] = "text/plain"
-		res.Headers["Content-Length"] = fmt.Sprintf("%d", len(res.Body))
+		res.Text(types.StatusOK, "Hello, World!")
 	})
 
 	r.Register(types.Get, "/echo/:path", func(ctx context.Context, req types.Request, res *types.Response) {
-		res.Status = types.StatusOK
-		res.Body = []byte(req.Params["path"])
-		res.Headers["Content-Type"] = "text/plain"
-		res.Headers["Content-Length"] = fmt.Sprintf("%d", len(res.Body))
+		res.Text(types.StatusOK, req.Params["path"])
 	})
 
 	r.Register(types.Get, "/user-agent", func(ctx context.Context, req types.Request, res *types.Response) {
-		res.Status = types.StatusOK
-		res.Body = []byte(req.Headers["User-Agent"])
-		res.Headers["Content-Type"] = "text/plain"
-		res.Headers["Content-Length"] = fmt.Sprintf("%d", len(res.Body))
+		res.Text(types.StatusOK, req.Header("User-Agent"))
 	})
 
 	r.Register(types.Get, "/files/:path", func(ctx context.Context, req types.Request, res *types.Response) {
-		res.Status = types.StatusOK
-		res.Body = []byte(req.Params["path"])
-		res.Headers["Content-Type"] = "text/plain"
-		res.Headers["Content-Length"] = fmt.Sprintf("%d", len(res.Body))
+		res.Text(types.StatusOK, req.Params["path"])
 	})
 
-	r.Register(types.Post, "/files/:path", func(ctx context.Context, req types.Request, res *types.Response) {
-		res.Status = types.StatusCreated
-		res.Body = []byte(req.Params["path"])
-		res.Headers["Content-Type"] = "text/plain"
-		res.Headers["Content-Length"] = fmt.Sprintf("%d", len(res.Body))
+	// Uploads store the posted file, or the raw body, under -directory.
+	r.Register(types.Post, "/files/:path", types.FallibleHandler(func(ctx context.Context, req types.Request, res *types.Response) error {
+		body, err := uploadedFile(ctx, req)
+		if err != nil {
+			return err
+		}
+		// Write next to the destination and rename, so a failed upload never
+		// leaves a truncated file behind.
+		tmp, err := os.CreateTemp(directory, ".upload-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		_, err = io.Copy(tmp, body)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), filepath.Join(directory, filepath.Clean("/"+req.Params["path"]))); err != nil {
+			return err
+		}
+		res.Text(types.StatusCreated, req.Params["path"])
+		return nil
+	}).Handler())
+
+	r.WebSocket("/ws", websocket.Upgrader{EnableCompression: true}, func(ctx context.Context, req types.Request, conn *websocket.Conn) {
+		for {
+			t, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(t, data); err != nil {
+				return
+			}
+		}
 	})
 
-	s := server.NewServer("0.0.0.0:4221").WithHandler(r.HandleRequest)
+	s := server.NewServer("0.0.0.0:4221").
+		WithHandler(r.HandleRequest).
+		WithAccessLog(slog.New(server.NewAccessLogHandler(os.Stdout, server.CombinedLogFormat))).
+		WithMetrics(server.DefaultMetricsPath, nil).
+		WithRequestID(nil).
+		WithRequestBodySizeLimit(func(req types.Request) int64 {
+			// Uploads to /files may be several gigabytes; every other route
+			// keeps the default limit.
+			if req.Method == types.Post && strings.HasPrefix(req.Target, "/files/") {
+				return 8 << 30
+			}
+			return 0
+		}).
+		WithRequestDecoders(32<<20, server.GzipDecoder(), server.DeflateDecoder())
+	if otlpEndpoint != "" {
+		exporter := tracing.NewOTLPExporter(tracing.OTLPConfig{Endpoint: otlpEndpoint})
+		defer exporter.Shutdown(context.Background())
+		s.WithTracer(tracing.NewTracer(exporter))
+	}
+	if certFile != "" {
+		s.ListenTLS(certFile, keyFile)
+		return
+	}
 	s.Listen()
 }
+
+// uploadedFile returns the content to store for an upload: the first file
+// part of a multipart/form-data body, or the raw body otherwise.
+func uploadedFile(ctx context.Context, req types.Request) (io.Reader, error) {
+	if req.BodyReader == nil {
+		return strings.NewReader(""), nil
+	}
+	parts, err := upload.NewReader(ctx, req, upload.Config{MaxParts: 32})
+	if errors.Is(err, upload.ErrNotMultipart) {
+		return req.BodyReader, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			return nil, types.NewHTTPError(types.StatusBadRequest, "form has no file part")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName != "" {
+			return part, nil
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeText(t *testing.T, r *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteText(&buf))
+	return buf.String()
+}
+
+func TestRegistry_CountersAndGauges(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests served.", "method", "code")
+	requests.With("GET", "200").Inc()
+	requests.With("GET", "200").Add(2)
+	requests.With("DELETE", "404").Inc()
+	inflight := r.NewGauge("inflight", "")
+	inflight.Inc()
+	inflight.Inc()
+	inflight.Dec()
+
+	assert.Equal(t, `# TYPE inflight gauge
+inflight 1
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{method="DELETE",code="404"} 1
+requests_total{method="GET",code="200"} 3
+`, writeText(t, r))
+}
+
+func TestRegistry_Histogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	h.With("/a").Observe(0.05)
+	h.With("/a").Observe(0.1)
+	h.With("/a").Observe(0.5)
+	h.With("/a").Observe(3)
+
+	assert.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 2
+latency_seconds_bucket{route="/a",le="1"} 3
+latency_seconds_bucket{route="/a",le="+Inf"} 4
+latency_seconds_sum{route="/a"} 3.65
+latency_seconds_count{route="/a"} 4
+`, writeText(t, r))
+}
+
+func TestRegistry_EscapesHelpAndLabelValues(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("odd_total", "Line one\nback\\slash", "v").With("say \"hi\"\n").Inc()
+
+	assert.Equal(t, `# HELP odd_total Line one\nback\\slash
+# TYPE odd_total counter
+odd_total{v="say \"hi\"\n"} 1
+`, writeText(t, r))
+}
+
+func TestRegistry_RejectsInvalidDefinitions(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("dup_total", "")
+	assert.Panics(t, func() { r.NewCounter("dup_total", "") })
+	assert.Panics(t, func() { r.NewCounter("bad-name", "") })
+	assert.Panics(t, func() { r.NewCounterVec("labels_total", "", "bad-label") })
+	assert.Panics(t, func() { r.NewHistogramVec("h", "", []float64{1}, "le") })
+	assert.Panics(t, func() { r.NewHistogram("unsorted", "", []float64{2, 1}) })
+	assert.Panics(t, func() { r.NewCounterVec("arity_total", "", "a").With("x", "y") })
+	assert.Panics(t, func() { r.NewCounter("negative_total", "").Add(-1) })
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("hits_total", "").Inc()
+
+	res := types.Response{Headers: map[string]string{}}
+	r.Handler()(context.Background(), types.Request{Method: types.Get, Target: "/metrics"}, &res)
+
+	assert.Equal(t, types.StatusOK, res.Status)
+	assert.Equal(t, ContentType, res.Headers["Content-Type"])
+	assert.Equal(t, "# TYPE hits_total counter\nhits_total 1\n", string(res.Body))
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	assert.Equal(t, []float64{1, 4, 16}, ExponentialBuckets(1, 4, 3))
+	assert.Panics(t, func() { ExponentialBuckets(0, 2, 3) })
+}
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry holds metric families and renders them for scraping.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]collector
+}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]collector)}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metrics: %s is already registered", name))
+	}
+	r.families[name] = c
+}
+
+// NewCounterVec registers a counter family with the given labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{f: newFamily(name, help, labels, func() *Counter { return &Counter{} })}
+	r.register(name, v)
+	return v
+}
+
+// NewCounter registers a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// NewGaugeVec registers a gauge family with the given labels.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{f: newFamily(name, help, labels, func() *Gauge { return &Gauge{} })}
+	r.register(name, v)
+	return v
+}
+
+// NewGauge registers a gauge without labels.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+// NewHistogramVec registers a histogram family with the given bucket upper
+// bounds, which must be sorted, and labels. The +Inf bucket is implicit.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !slices.IsSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of %s are not sorted", name))
+	}
+	if slices.Contains(labels, "le") {
+		panic(fmt.Sprintf("metrics: %s uses the reserved label le", name))
+	}
+	buckets = slices.Clone(buckets)
+	if n := len(buckets); n > 0 && math.IsInf(buckets[n-1], 1) {
+		buckets = buckets[:n-1]
+	}
+	v := &HistogramVec{f: newFamily(name, help, labels, func() *Histogram { return newHistogram(buckets) })}
+	r.register(name, v)
+	return v
+}
+
+// NewHistogram registers a histogram without labels.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+// WriteText writes every metric in the text exposition format, with families
+// ordered by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	families := make([]collector, len(names))
+	slices.Sort(names)
+	for i, name := range names {
+		families[i] = r.families[name]
+	}
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry's metrics.
+func (r *Registry) Handler() types.Handler {
+	return func(ctx context.Context, req types.Request, res *types.Response) {
+		var buf bytes.Buffer
+		if err := r.WriteText(&buf); err != nil {
+			res.Status = types.StatusInternalServerError
+			return
+		}
+		if res.Headers == nil {
+			res.Headers = make(map[string]string)
+		}
+		res.Status = types.StatusOK
+		res.Headers["Content-Type"] = ContentType
+		res.Body = buf.Bytes()
+	}
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, v.f.name, v.f.help, "counter")
+	for _, c := range v.f.sorted() {
+		writeSample(w, v.f.name, v.f.labels, c.values, "", "", c.metric.Value())
+	}
+}
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	writeHeader(w, v.f.name, v.f.help, "gauge")
+	for _, c := range v.f.sorted() {
+		writeSample(w, v.f.name, v.f.labels, c.values, "", "", c.metric.Value())
+	}
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, v.f.name, v.f.help, "histogram")
+	for _, c := range v.f.sorted() {
+		counts, sum, count := c.metric.snapshot()
+		for i, upper := range c.metric.upper {
+			writeSample(w, v.f.name+"_bucket", v.f.labels, c.values, "le", formatFloat(upper), float64(counts[i]))
+		}
+		writeSample(w, v.f.name+"_bucket", v.f.labels, c.values, "le", "+Inf", float64(count))
+		writeSample(w, v.f.name+"_sum", v.f.labels, c.values, "", "", sum)
+		writeSample(w, v.f.name+"_count", v.f.labels, c.values, "", "", float64(count))
+	}
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	if help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, helpEscaper.Replace(help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// writeSample writes one sample line. extraName, when set, is appended as a
+// label after the family's own, as le is for histogram buckets.
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, l, labelEscaper.Replace(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
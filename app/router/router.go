@@ -4,11 +4,29 @@ import (
 	"context"
 
 	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/codecrafters-io/http-server-starter-go/app/websocket"
 )
 
 type Router interface {
 	Register(method types.Method, path string, handler types.Handler) Router
 
+	// Group returns a Router that registers routes under prefix, wrapping
+	// each of them with the given middleware.
+	Group(prefix string, middleware ...types.Middleware) Router
+
+	// WebSocket registers a GET route that upgrades to the WebSocket
+	// protocol and hands the connection to handler.
+	WebSocket(path string, upgrader websocket.Upgrader, handler websocket.Handler) Router
+
+	// NotFound sets the handler for requests no route matches. It applies
+	// to the whole router, whichever group it is called on.
+	NotFound(handler types.Handler) Router
+
+	// MethodNotAllowed sets the handler for requests whose path only has
+	// routes for other methods. The response's Allow header is already set
+	// when it runs.
+	MethodNotAllowed(handler types.Handler) Router
+
 	HandleRequest(ctx context.Context, req types.Request) types.Response
 }
 
@@ -2,44 +2,101 @@ package router
 
 import (
 	"context"
+	"strings"
 
+	"github.com/codecrafters-io/http-server-starter-go/app/errorpage"
 	"github.com/codecrafters-io/http-server-starter-go/app/segmenttree"
 	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/codecrafters-io/http-server-starter-go/app/websocket"
 )
 
 type treeRouter struct {
-	tree *segmenttree.SegmentTree
+	tree       *segmenttree.SegmentTree
+	prefix     string
+	middleware []types.Middleware
+	// fallbacks is shared with the router's groups.
+	fallbacks *fallbacks
+}
+
+// fallbacks answer requests that match no route.
+type fallbacks struct {
+	notFound         types.Handler
+	methodNotAllowed types.Handler
 }
 
 func newTreeRouter() *treeRouter {
 	return &treeRouter{
 		tree: segmenttree.NewSegmentTree(),
+		fallbacks: &fallbacks{
+			notFound:         errorpage.Handler(types.StatusNotFound),
+			methodNotAllowed: errorpage.Handler(types.StatusMethodNotAllowed),
+		},
 	}
 }
 
 func (r *treeRouter) Register(method types.Method, path string, handler types.Handler) Router {
-	r.tree.Insert(method, path, handler)
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+	r.tree.Insert(method, r.prefix+path, handler)
 	return r
 }
 
-func (r *treeRouter) HandleRequest(ctx context.Context, req types.Request) types.Response {
-	handler, params, ok := r.tree.Search(req.Method, req.Target)
-	if !ok {
-		return types.Response{
-			Status: types.StatusNotFound,
-			Headers: map[string]string{
-				"Content-Type": "text/plain",
-			},
-			Body: []byte("404 Not Found"),
-		}
+func (r *treeRouter) Group(prefix string, middleware ...types.Middleware) Router {
+	mw := make([]types.Middleware, 0, len(r.middleware)+len(middleware))
+	mw = append(mw, r.middleware...)
+	mw = append(mw, middleware...)
+	return &treeRouter{
+		tree:       r.tree,
+		prefix:     r.prefix + prefix,
+		middleware: mw,
+		fallbacks:  r.fallbacks,
 	}
+}
 
-	req.Params = params
+func (r *treeRouter) WebSocket(path string, upgrader websocket.Upgrader, handler websocket.Handler) Router {
+	return r.Register(types.Get, path, upgrader.Handler(handler))
+}
+
+func (r *treeRouter) NotFound(handler types.Handler) Router {
+	r.fallbacks.notFound = handler
+	return r
+}
+
+func (r *treeRouter) MethodNotAllowed(handler types.Handler) Router {
+	r.fallbacks.methodNotAllowed = handler
+	return r
+}
+
+func (r *treeRouter) HandleRequest(ctx context.Context, req types.Request) types.Response {
 	response := types.Response{
 		Status:  types.StatusOK,
 		Headers: make(map[string]string),
 	}
 
+	handler, params, pattern, ok := r.tree.Match(req.Method, req.Target)
+	if !ok {
+		handler = r.fallbacks.notFound
+		response.Status = types.StatusNotFound
+		if methods := r.tree.Methods(req.Target); methods != nil {
+			handler = r.fallbacks.methodNotAllowed
+			response.Status = types.StatusMethodNotAllowed
+			response.Headers["Allow"] = joinMethods(methods)
+		}
+		handler(ctx, req, &response)
+		return response
+	}
+
+	req.Params = params
+	types.SetRoute(ctx, pattern)
 	handler(ctx, req, &response)
 	return response
 }
+
+func joinMethods(methods []types.Method) string {
+	names := make([]string, len(methods))
+	for i, m := range methods {
+		names[i] = string(m)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,60 @@
+package router
+
+import (
+	"context"
+	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func noop(ctx context.Context, req types.Request, res *types.Response) {}
+
+func TestTreeRouter_NotFound(t *testing.T) {
+	r := New()
+	r.Register(types.Get, "/files/:path", noop)
+
+	res := r.HandleRequest(context.Background(), types.Request{Method: types.Get, Target: "/missing"})
+	assert.Equal(t, types.StatusNotFound, res.Status)
+	assert.Equal(t, "404 Not Found", string(res.Body))
+
+	res = r.HandleRequest(context.Background(), types.Request{
+		Method:  types.Get,
+		Target:  "/missing",
+		Headers: map[string]string{"Accept": "application/json"},
+	})
+	assert.Equal(t, "application/problem+json", res.Headers["Content-Type"])
+	assert.JSONEq(t, `{"type":"about:blank","title":"Not Found","status":404,"instance":"/missing"}`, string(res.Body))
+}
+
+func TestTreeRouter_MethodNotAllowed(t *testing.T) {
+	r := New()
+	r.Register(types.Get, "/files/:path", noop)
+	r.Register(types.Post, "/files/:path", noop)
+	r.Register(types.Delete, "/files/special", noop)
+
+	res := r.HandleRequest(context.Background(), types.Request{Method: types.Put, Target: "/files/special"})
+	assert.Equal(t, types.StatusMethodNotAllowed, res.Status)
+	assert.Equal(t, "DELETE, GET, POST", res.Headers["Allow"])
+	assert.Equal(t, "405 Method Not Allowed", string(res.Body))
+}
+
+func TestTreeRouter_CustomFallbacksApplyToGroups(t *testing.T) {
+	r := New()
+	api := r.Group("/api")
+	api.Register(types.Get, "/users", noop)
+	api.NotFound(func(ctx context.Context, req types.Request, res *types.Response) {
+		res.Body = []byte("nothing at " + req.Target)
+	})
+	r.MethodNotAllowed(func(ctx context.Context, req types.Request, res *types.Response) {
+		res.Body = []byte("use " + res.Headers["Allow"])
+	})
+
+	res := r.HandleRequest(context.Background(), types.Request{Method: types.Get, Target: "/nope"})
+	assert.Equal(t, types.StatusNotFound, res.Status)
+	assert.Equal(t, "nothing at /nope", string(res.Body))
+
+	res = r.HandleRequest(context.Background(), types.Request{Method: types.Post, Target: "/api/users"})
+	assert.Equal(t, types.StatusMethodNotAllowed, res.Status)
+	assert.Equal(t, "use GET", string(res.Body))
+}
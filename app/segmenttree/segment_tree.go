@@ -1,6 +1,7 @@
 package segmenttree
 
 import (
+	"slices"
 	"strings"
 
 	"github.com/codecrafters-io/http-server-starter-go/app/types"
@@ -12,6 +13,9 @@ type SegmentNode struct {
 	paramName         string
 	handlers          map[types.Method]types.Handler
 	isEndOfPath       bool
+	// pattern is the path the node was registered under, with its
+	// parameter names, such as "/files/:path".
+	pattern string
 }
 
 type SegmentTree struct {
@@ -59,43 +63,90 @@ func (t *SegmentTree) Insert(method types.Method, path string, handler types.Han
 		}
 	}
 	node.isEndOfPath = true
+	node.pattern = path
 	node.handlers[method] = handler
 }
 
 func (t *SegmentTree) Search(method types.Method, path string) (types.Handler, map[string]string, bool) {
+	h, params, _, ok := t.Match(method, path)
+	return h, params, ok
+}
+
+// Match is like Search but also returns the pattern the handler was
+// registered under, which unlike path has a bounded set of values.
+func (t *SegmentTree) Match(method types.Method, path string) (types.Handler, map[string]string, string, bool) {
 	segments := strings.Split(path, "/")
 	params := make(map[string]string)
-	h, ok := t.searchNode(t.root, segments, method, params)
+	node, ok := t.searchNode(t.root, segments, method, params)
 	if !ok {
-		return nil, nil, false
+		return nil, nil, "", false
 	}
-	return h, params, true
+	return node.handlers[method], params, node.pattern, true
 }
 
-func (t *SegmentTree) searchNode(node *SegmentNode, segments []string, method types.Method, params map[string]string) (types.Handler, bool) {
+func (t *SegmentTree) searchNode(node *SegmentNode, segments []string, method types.Method, params map[string]string) (*SegmentNode, bool) {
 	if len(segments) == 0 {
 		if !node.isEndOfPath {
 			return nil, false
 		}
-		h, ok := node.handlers[method]
-		return h, ok
+		_, ok := node.handlers[method]
+		return node, ok
 	}
 	seg := segments[0]
 	rest := segments[1:]
 
 	if child, exists := node.children[seg]; exists {
-		if h, ok := t.searchNode(child, rest, method, params); ok {
-			return h, true
+		if n, ok := t.searchNode(child, rest, method, params); ok {
+			return n, true
 		}
 	}
 	if seg != "" {
 		for name, child := range node.parameterChildren {
 			params[name] = seg
-			if h, ok := t.searchNode(child, rest, method, params); ok {
-				return h, true
+			if n, ok := t.searchNode(child, rest, method, params); ok {
+				return n, true
 			}
 			delete(params, name)
 		}
 	}
 	return nil, false
 }
+
+// Methods returns the methods registered for any pattern matching path,
+// sorted, or nil when no pattern matches it. A path that matches but has no
+// handler for the request method calls for a 405 rather than a 404.
+func (t *SegmentTree) Methods(path string) []types.Method {
+	seen := make(map[types.Method]bool)
+	collectMethods(t.root, strings.Split(path, "/"), seen)
+	if len(seen) == 0 {
+		return nil
+	}
+	methods := make([]types.Method, 0, len(seen))
+	for m := range seen {
+		methods = append(methods, m)
+	}
+	slices.Sort(methods)
+	return methods
+}
+
+func collectMethods(node *SegmentNode, segments []string, seen map[types.Method]bool) {
+	if len(segments) == 0 {
+		if node.isEndOfPath {
+			for m := range node.handlers {
+				seen[m] = true
+			}
+		}
+		return
+	}
+	seg := segments[0]
+	rest := segments[1:]
+
+	if child, exists := node.children[seg]; exists {
+		collectMethods(child, rest, seen)
+	}
+	if seg != "" {
+		for _, child := range node.parameterChildren {
+			collectMethods(child, rest, seen)
+		}
+	}
+}
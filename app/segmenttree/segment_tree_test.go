@@ -262,3 +262,51 @@ func TestSegmentTreeRouting(t *testing.T) {
 		})
 	}
 }
+
+func TestSegmentTreeMatchReturnsPattern(t *testing.T) {
+	noop := func(ctx context.Context, req types.Request, res *types.Response) {}
+	tr := NewSegmentTree()
+	tr.Insert(types.Get, "/files/:path", noop)
+	tr.Insert(types.Get, "/files/static", noop)
+
+	tests := []struct {
+		path        string
+		wantPattern string
+	}{
+		{"/files/a.txt", "/files/:path"},
+		{"/files/b.txt", "/files/:path"},
+		{"/files/static", "/files/static"},
+	}
+	for _, tt := range tests {
+		_, _, pattern, ok := tr.Match(types.Get, tt.path)
+		if !ok {
+			t.Errorf("Match(%q) found no route", tt.path)
+			continue
+		}
+		if pattern != tt.wantPattern {
+			t.Errorf("Match(%q) pattern = %q, want %q", tt.path, pattern, tt.wantPattern)
+		}
+	}
+
+	if _, _, pattern, ok := tr.Match(types.Post, "/files/a.txt"); ok || pattern != "" {
+		t.Errorf("Match(POST) = %q, %v, want no match", pattern, ok)
+	}
+}
+
+func TestSegmentTreeMethods(t *testing.T) {
+	noop := func(ctx context.Context, req types.Request, res *types.Response) {}
+	tr := NewSegmentTree()
+	tr.Insert(types.Get, "/files/:path", noop)
+	tr.Insert(types.Post, "/files/:path", noop)
+	tr.Insert(types.Delete, "/files/static", noop)
+
+	if got, want := tr.Methods("/files/static"), []types.Method{types.Delete, types.Get, types.Post}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Methods(/files/static) = %v, want %v", got, want)
+	}
+	if got, want := tr.Methods("/files/a.txt"), []types.Method{types.Get, types.Post}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Methods(/files/a.txt) = %v, want %v", got, want)
+	}
+	if got := tr.Methods("/files"); got != nil {
+		t.Errorf("Methods(/files) = %v, want nil", got)
+	}
+}
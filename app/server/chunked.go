@@ -0,0 +1,355 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// chunkedWriter frames everything written to it as HTTP/1.1 chunks
+// (RFC 7230 §4.1).
+type chunkedWriter struct {
+	w io.Writer
+	// trailers is called after the last chunk for the fields to send in
+	// the trailer section.
+	trailers func() []trailerField
+}
+
+func (cw *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := io.WriteString(cw.w, strconv.FormatInt(int64(len(p)), 16)+"\r\n"); err != nil {
+		return 0, fmt.Errorf("error writing chunk size: %w", err)
+	}
+	if _, err := cw.w.Write(p); err != nil {
+		return 0, fmt.Errorf("error writing chunk data: %w", err)
+	}
+	if _, err := io.WriteString(cw.w, "\r\n"); err != nil {
+		return 0, fmt.Errorf("error writing CRLF after chunk data: %w", err)
+	}
+	return len(p), nil
+}
+
+// Close writes the last-chunk and the trailer section.
+func (cw *chunkedWriter) Close() error {
+	var end strings.Builder
+	end.WriteString("0\r\n")
+	if cw.trailers != nil {
+		for _, f := range cw.trailers() {
+			end.WriteString(f.name + ": " + f.value + "\r\n")
+		}
+	}
+	end.WriteString("\r\n")
+	if _, err := io.WriteString(cw.w, end.String()); err != nil {
+		return fmt.Errorf("error writing last chunk: %w", err)
+	}
+	return nil
+}
+
+type flusher interface {
+	Flush() error
+}
+
+// streamChunked copies body to w using chunked transfer coding, compressing
+// it on the fly when enc is set, and ends with the fields from trailers.
+func streamChunked(w io.Writer, body io.Reader, enc *Encoder, trailers func() []trailerField) error {
+	cw := &chunkedWriter{w: w, trailers: trailers}
+	if err := streamBody(cw, body, enc); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// streamBody copies body to dst. When enc is set the stream is compressed,
+// and the encoder is flushed whenever body has nothing more to hand over yet,
+// so that data reaches the client as soon as the handler produces it without
+// every read ending a compressed block. body is closed afterwards if it is an
+// io.Closer, which unblocks producers writing into a pipe once the client
+// has gone away.
+func streamBody(dst io.Writer, body io.Reader, enc *Encoder) error {
+	if c, ok := body.(io.Closer); ok {
+		defer c.Close()
+	}
+	if enc == nil {
+		if _, err := io.Copy(dst, body); err != nil {
+			return fmt.Errorf("error streaming body: %w", err)
+		}
+		return nil
+	}
+
+	encoder, err := enc.NewWriter(dst, enc.Level)
+	if err != nil {
+		return fmt.Errorf("error creating %s encoder: %w", enc.Name, err)
+	}
+	f, canFlush := encoder.(flusher)
+	if _, seekable := body.(io.Seeker); seekable || !canFlush {
+		// Files and in-memory readers never keep the client waiting.
+		if _, err := io.Copy(encoder, body); err != nil {
+			return fmt.Errorf("error streaming body: %w", err)
+		}
+	} else {
+		pf := newPrefetcher(body)
+		defer pf.stop()
+		pending := false
+		for {
+			r, ready := pf.ready()
+			if !ready {
+				if pending {
+					if err := f.Flush(); err != nil {
+						return fmt.Errorf("error flushing %s encoder: %w", enc.Name, err)
+					}
+					pending = false
+				}
+				r = <-pf.results
+			}
+			if len(r.p) > 0 {
+				if _, err := encoder.Write(r.p); err != nil {
+					return err
+				}
+				pending = true
+			}
+			pf.free <- r.p[:cap(r.p)]
+			if r.err == io.EOF {
+				break
+			}
+			if r.err != nil {
+				return fmt.Errorf("error reading from body reader: %w", r.err)
+			}
+		}
+	}
+
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("error closing %s encoder: %w", enc.Name, err)
+	}
+	return nil
+}
+
+// prefetcher reads a body on its own goroutine, one buffer ahead of the
+// writer, so that streamBody can tell whether more data is ready before it
+// waits for it.
+type prefetcher struct {
+	results chan prefetched
+	free    chan []byte
+	done    chan struct{}
+}
+
+type prefetched struct {
+	p   []byte
+	err error
+}
+
+func newPrefetcher(body io.Reader) *prefetcher {
+	pf := &prefetcher{
+		results: make(chan prefetched, 1),
+		free:    make(chan []byte, 2),
+		done:    make(chan struct{}),
+	}
+	pf.free <- make([]byte, 4*1024)
+	pf.free <- make([]byte, 4*1024)
+	go func() {
+		for {
+			var buf []byte
+			select {
+			case buf = <-pf.free:
+			case <-pf.done:
+				return
+			}
+			n, err := body.Read(buf)
+			select {
+			case pf.results <- prefetched{p: buf[:n], err: err}:
+			case <-pf.done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return pf
+}
+
+// ready returns the next read if it is available without waiting on body.
+// The reading goroutine is given a chance to run first, since it is usually
+// just being woken up to read the next piece rather than blocked in Read.
+func (pf *prefetcher) ready() (prefetched, bool) {
+	for range 2 {
+		select {
+		case r := <-pf.results:
+			return r, true
+		default:
+		}
+		runtime.Gosched()
+	}
+	return prefetched{}, false
+}
+
+// stop releases the reading goroutine once it returns from its current Read.
+func (pf *prefetcher) stop() {
+	close(pf.done)
+}
+
+// Limits on the framing of a chunked request body, which the body size limit
+// does not cover.
+const (
+	// maxChunkLineSize bounds a chunk-size line, extensions included, and
+	// each trailer field line.
+	maxChunkLineSize = 4096
+	// maxTrailerSize and maxTrailerFields bound the trailer section.
+	maxTrailerSize   = 32 << 10
+	maxTrailerFields = 100
+)
+
+var (
+	errChunkLineTooLong = types.NewHTTPError(types.StatusBadRequest, "chunk-size or trailer line too long")
+	errTrailersTooLarge = types.NewHTTPError(types.StatusRequestHeaderFieldsTooLarge, "trailer section too large")
+)
+
+// chunkedReader decodes a chunked request body (RFC 7230 §4.1), storing
+// the trailer fields that follow the last chunk in trailers.
+type chunkedReader struct {
+	r        *bufio.Reader
+	trailers map[string]string
+	// remaining is the unread size of the current chunk.
+	remaining int64
+	err       error
+	// trailerSize and trailerFields count the trailer lines read so far.
+	trailerSize   int
+	trailerFields int
+}
+
+func newChunkedReader(r *bufio.Reader) *chunkedReader {
+	return &chunkedReader{r: r, trailers: make(map[string]string)}
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.remaining == 0 {
+		if cr.err = cr.nextChunk(); cr.err != nil {
+			return 0, cr.err
+		}
+	}
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.r.Read(p)
+	cr.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && cr.remaining == 0 {
+		err = cr.chunkEnd()
+	}
+	cr.err = err
+	return n, err
+}
+
+// nextChunk reads a chunk-size line, and the trailer section after the last
+// chunk, in which case it returns io.EOF.
+func (cr *chunkedReader) nextChunk() error {
+	line, err := readLimitedLine(cr.r)
+	if err != nil {
+		return fmt.Errorf("error reading chunk size: %w", err)
+	}
+	sizeField, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid chunk size: %q", sizeField)
+	}
+	if size > 0 {
+		cr.remaining = size
+		return nil
+	}
+
+	for {
+		line, err := readLimitedLine(cr.r)
+		if err != nil {
+			return fmt.Errorf("error reading trailer: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return io.EOF
+		}
+		cr.trailerSize += len(line)
+		cr.trailerFields++
+		if cr.trailerSize > maxTrailerSize || cr.trailerFields > maxTrailerFields {
+			return errTrailersTooLarge
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("malformed trailer: %q", line)
+		}
+		cr.trailers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+}
+
+func (cr *chunkedReader) chunkEnd() error {
+	crlf, err := readLimitedLine(cr.r)
+	if err != nil {
+		return fmt.Errorf("error reading CRLF after chunk data: %w", err)
+	}
+	if strings.TrimRight(crlf, "\r\n") != "" {
+		return errors.New("missing CRLF after chunk data")
+	}
+	return nil
+}
+
+// readLimitedLine reads a line of chunked framing, failing with
+// errChunkLineTooLong rather than buffering one longer than maxChunkLineSize.
+func readLimitedLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		frag, err := r.ReadSlice('\n')
+		if len(line)+len(frag) > maxChunkLineSize {
+			return "", errChunkLineTooLong
+		}
+		line = append(line, frag...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", unexpectedEOF(err)
+		}
+		return string(line), nil
+	}
+}
+
+// lengthReader reads a body of a known length, reporting a connection that
+// ends early as io.ErrUnexpectedEOF.
+type lengthReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (lr *lengthReader) Read(p []byte) (int, error) {
+	if lr.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > lr.remaining {
+		p = p[:lr.remaining]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	if err == io.EOF {
+		if lr.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
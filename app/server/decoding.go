@@ -0,0 +1,144 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// Decoder decompresses request bodies sent with a single content coding.
+type Decoder struct {
+	// Name is the content-coding token matched against Content-Encoding.
+	Name string
+	// NewReader returns a reader yielding the decoded form of r.
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipDecoder returns a Decoder for the gzip coding.
+func GzipDecoder() Decoder {
+	return Decoder{
+		Name: "gzip",
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	}
+}
+
+// DeflateDecoder returns a Decoder for the deflate (zlib) coding.
+func DeflateDecoder() Decoder {
+	return Decoder{
+		Name: "deflate",
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		},
+	}
+}
+
+var errBodyTooLarge = types.NewHTTPError(types.StatusPayloadTooLarge, "decoded request body exceeds limit")
+
+// decodeRequestBody replaces a compressed request body with its decoded form,
+// undoing each coding listed in Content-Encoding in reverse order as the
+// handler reads it. It returns the status to answer with when the body cannot
+// be accepted.
+func (s Server) decodeRequestBody(req *types.Request) (types.Status, error) {
+	contentEncoding := req.Header("Content-Encoding")
+	if s.decoders == nil || contentEncoding == "" || req.BodyReader == nil {
+		return types.StatusOK, nil
+	}
+
+	var codings []string
+	for _, c := range strings.Split(contentEncoding, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c != "" && c != identityEncoding {
+			codings = append(codings, c)
+		}
+	}
+
+	var decoders []Decoder
+	for i := len(codings) - 1; i >= 0; i-- {
+		dec, ok := s.findDecoder(codings[i])
+		if !ok {
+			return types.StatusUnsupportedMediaType, fmt.Errorf("unsupported request content coding %q", codings[i])
+		}
+		decoders = append(decoders, dec)
+	}
+
+	req.BodyReader = &decodingReader{src: req.BodyReader, decoders: decoders, limit: s.maxDecodedBodySize}
+	deleteHeader(req.Headers, "Content-Encoding")
+	deleteHeader(req.Headers, "Content-Length")
+	return types.StatusOK, nil
+}
+
+func (s Server) findDecoder(name string) (Decoder, bool) {
+	for _, d := range s.decoders {
+		if d.Name == name || (name == "x-gzip" && d.Name == "gzip") {
+			return d, true
+		}
+	}
+	return Decoder{}, false
+}
+
+// decodingReader decodes a request body as it is read, failing with
+// errBodyTooLarge once the output grows beyond limit bytes. A body that does
+// not decode fails with a 400 *types.HTTPError.
+type decodingReader struct {
+	src      io.Reader
+	decoders []Decoder
+	limit    int64
+
+	r    io.Reader
+	read int64
+	err  error
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.r == nil {
+		r := d.src
+		for _, dec := range d.decoders {
+			dr, err := dec.NewReader(r)
+			if err != nil {
+				d.err = decodingError(err)
+				return 0, d.err
+			}
+			r = dr
+		}
+		d.r = r
+	}
+	n, err := d.r.Read(p)
+	d.read += int64(n)
+	if d.limit > 0 && d.read > d.limit {
+		d.err = errBodyTooLarge
+		return 0, d.err
+	}
+	if err != nil && err != io.EOF {
+		d.err = decodingError(err)
+		return n, d.err
+	}
+	return n, err
+}
+
+// decodingError reports a body that could not be decoded, keeping errors
+// from reading the body itself, such as its size limit, as they are.
+func decodingError(err error) error {
+	var he *types.HTTPError
+	if errors.As(err, &he) {
+		return err
+	}
+	return types.WrapHTTPError(err, types.StatusBadRequest, "malformed compressed request body")
+}
+
+func decoderNames(decoders []Decoder) string {
+	names := make([]string, len(decoders))
+	for i, d := range decoders {
+		names[i] = d.Name
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+const identityEncoding = "identity"
+
+// acceptedCoding is a single entry of an Accept-Encoding header.
+type acceptedCoding struct {
+	name string
+	q    float64
+}
+
+// parseAcceptEncoding splits an Accept-Encoding header into its codings and
+// q-values. Entries with an unparsable q-value are ignored.
+func parseAcceptEncoding(header string) []acceptedCoding {
+	var codings []acceptedCoding
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		params := strings.Split(part, ";")
+		coding := acceptedCoding{name: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		valid := true
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			coding.q = q
+		}
+		if valid {
+			codings = append(codings, coding)
+		}
+	}
+	return codings
+}
+
+// negotiateEncoding picks the content coding for a response following
+// RFC 7231 §5.3.4. offered lists the codings the server can produce, in order
+// of preference; identity is always available and loses ties. If the client
+// sent no Accept-Encoding header, identity is chosen. ok is false when no
+// coding, not even identity, is acceptable.
+func negotiateEncoding(header string, present bool, offered []string) (coding string, ok bool) {
+	if !present {
+		return identityEncoding, true
+	}
+
+	explicit := make(map[string]float64)
+	wildcard := -1.0
+	for _, c := range parseAcceptEncoding(header) {
+		if c.name == "*" {
+			wildcard = c.q
+			continue
+		}
+		if q, seen := explicit[c.name]; !seen || c.q > q {
+			explicit[c.name] = c.q
+		}
+	}
+
+	qualityOf := func(name string) float64 {
+		if q, ok := explicit[name]; ok {
+			return q
+		}
+		if wildcard >= 0 {
+			return wildcard
+		}
+		if name == identityEncoding {
+			// identity is acceptable unless explicitly excluded.
+			return 0.001
+		}
+		return 0
+	}
+
+	best, bestQ := "", 0.0
+	for _, name := range offered {
+		if q := qualityOf(name); q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	if q := qualityOf(identityEncoding); q > bestQ {
+		best, bestQ = identityEncoding, q
+	}
+	if bestQ <= 0 {
+		return "", false
+	}
+	return best, true
+}
+
+// Encoder compresses response bodies with a single content coding.
+type Encoder struct {
+	// Name is the content-coding token advertised in Content-Encoding.
+	Name string
+	// Level is passed to NewWriter; its meaning depends on the coding.
+	Level int
+	// MinSize is the smallest body, in bytes, worth compressing.
+	MinSize int
+	// ContentTypes restricts compression to these media types. Entries may
+	// end in "/*" to match a whole type. When empty, every type except those
+	// known to be compressed already is eligible.
+	ContentTypes []string
+	// NewWriter returns a writer that compresses into w.
+	NewWriter func(w io.Writer, level int) (io.WriteCloser, error)
+}
+
+// GzipEncoder returns an Encoder for the gzip coding.
+func GzipEncoder(level int) Encoder {
+	return Encoder{
+		Name:  "gzip",
+		Level: level,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+	}
+}
+
+// DeflateEncoder returns an Encoder for the deflate coding, which HTTP
+// defines as a zlib stream (RFC 7230 §4.2.2).
+func DeflateEncoder(level int) Encoder {
+	return Encoder{
+		Name:  "deflate",
+		Level: level,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return zlib.NewWriterLevel(w, level)
+		},
+	}
+}
+
+// BrotliEncoder returns an Encoder for the br coding. Levels range from 0
+// to 11.
+func BrotliEncoder(level int) Encoder {
+	return Encoder{
+		Name:  "br",
+		Level: level,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return brotli.NewWriterLevel(w, level), nil
+		},
+	}
+}
+
+// ZstdEncoder returns an Encoder for the zstd coding. Levels follow the zstd
+// command line scale of 1 to 22. zstd encoders are costly to set up, so they
+// are pooled and reused once a response closes its writer.
+func ZstdEncoder(level int) Encoder {
+	return Encoder{
+		Name:  "zstd",
+		Level: level,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			pool := zstdPool(level)
+			enc, ok := pool.Get().(*zstd.Encoder)
+			if ok {
+				enc.Reset(w)
+			} else {
+				var err error
+				enc, err = zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+				if err != nil {
+					return nil, err
+				}
+			}
+			return &pooledZstdWriter{Encoder: enc, pool: pool}, nil
+		},
+	}
+}
+
+var zstdPools sync.Map // level -> *sync.Pool of *zstd.Encoder
+
+func zstdPool(level int) *sync.Pool {
+	pool, _ := zstdPools.LoadOrStore(level, new(sync.Pool))
+	return pool.(*sync.Pool)
+}
+
+// pooledZstdWriter returns its encoder to the pool when closed. Writers that
+// are never closed, as when a response fails midway, are left to the
+// garbage collector.
+type pooledZstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (w *pooledZstdWriter) Close() error {
+	enc := w.Encoder
+	if enc == nil {
+		return nil
+	}
+	w.Encoder = nil
+	err := enc.Close()
+	if err == nil {
+		enc.Reset(nil)
+		w.pool.Put(enc)
+	}
+	return err
+}
+
+// DefaultMinCompressSize is the MinSize of the default encoders. Smaller
+// bodies gain little from compression and can even grow.
+const DefaultMinCompressSize = 1024
+
+// DefaultEncoders returns the encoders a Server uses unless configured
+// otherwise, in order of preference.
+func DefaultEncoders() []Encoder {
+	encoders := []Encoder{
+		BrotliEncoder(brotli.DefaultCompression),
+		ZstdEncoder(3),
+		GzipEncoder(gzip.DefaultCompression),
+		DeflateEncoder(zlib.DefaultCompression),
+	}
+	for i := range encoders {
+		encoders[i].MinSize = DefaultMinCompressSize
+	}
+	return encoders
+}
+
+// incompressibleTypes are media types whose payloads are compressed already.
+var incompressibleTypes = []string{
+	"image/*",
+	"video/*",
+	"audio/*",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/x-bzip2",
+	"application/pdf",
+}
+
+// accepts reports whether e should compress a body of size bytes with the
+// given Content-Type. A negative size means the length is not known up front,
+// as for most streamed bodies, and skips the MinSize check.
+func (e Encoder) accepts(contentType string, size int) bool {
+	if size >= 0 && size < e.MinSize {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if len(e.ContentTypes) > 0 {
+		return mediaType == "" || matchMediaType(e.ContentTypes, mediaType)
+	}
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+	return !matchMediaType(incompressibleTypes, mediaType)
+}
+
+func matchMediaType(patterns []string, mediaType string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if prefix, ok := strings.CutSuffix(p, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if p == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func encoderNames(encoders []Encoder) []string {
+	names := make([]string, len(encoders))
+	for i, e := range encoders {
+		names[i] = e.Name
+	}
+	return names
+}
+
+// eligibleEncoders returns the encoders that may compress a body of size
+// bytes with the given Content-Type, as accepts decides.
+func eligibleEncoders(encoders []Encoder, contentType string, size int) []Encoder {
+	var eligible []Encoder
+	for _, e := range encoders {
+		if e.accepts(contentType, size) {
+			eligible = append(eligible, e)
+		}
+	}
+	return eligible
+}
+
+// codingAcceptable reports whether res can be sent in a content coding req
+// accepts, counting only the encoders eligible for its body. Responses
+// without a body, or whose body is encoded already, always can.
+func codingAcceptable(encoders []Encoder, req types.Request, res types.Response) bool {
+	if res.Status == types.StatusNoContent || res.Headers["Content-Encoding"] != "" || (res.BodyReader == nil && len(res.Body) == 0) {
+		return true
+	}
+	size := len(res.Body)
+	if res.BodyReader != nil {
+		size = streamSize(res)
+	}
+	acceptEncoding, hasAcceptEncoding := req.LookupHeader("Accept-Encoding")
+	eligible := eligibleEncoders(encoders, res.Headers["Content-Type"], size)
+	_, ok := negotiateEncoding(acceptEncoding, hasAcceptEncoding, encoderNames(eligible))
+	return ok
+}
+
+// anyCodingAcceptable reports whether req accepts identity or any of
+// encoders, so that a response to it could be sent in some coding.
+func anyCodingAcceptable(encoders []Encoder, req types.Request) bool {
+	acceptEncoding, hasAcceptEncoding := req.LookupHeader("Accept-Encoding")
+	_, ok := negotiateEncoding(acceptEncoding, hasAcceptEncoding, encoderNames(encoders))
+	return ok
+}
+
+// selectEncoder negotiates between the encoders eligible for a response. It
+// returns false when identity should be used.
+func selectEncoder(encoders []Encoder, req types.Request, contentType string, size int) (Encoder, bool) {
+	eligible := eligibleEncoders(encoders, contentType, size)
+	acceptEncoding, hasAcceptEncoding := req.LookupHeader("Accept-Encoding")
+	coding, _ := negotiateEncoding(acceptEncoding, hasAcceptEncoding, encoderNames(eligible))
+	for _, e := range eligible {
+		if e.Name == coding {
+			return e, true
+		}
+	}
+	return Encoder{}, false
+}
+
+// compress encodes body with e.
+func compress(e Encoder, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := e.NewWriter(&buf, e.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/errorpage"
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// WithBadRequestHandler sets the handler answering requests the server
+// rejects as malformed, such as unparsable requests and undecodable bodies,
+// and handler errors calling for a 400. It runs with the response status set
+// to 400 and the reason available through types.ErrorCause. By default the
+// reason is shown in an error page.
+func (s *Server) WithBadRequestHandler(h types.Handler) *Server {
+	s.badRequest = h
+	return s
+}
+
+// WithInternalErrorHandler sets the handler answering requests whose handler
+// panicked or failed with a 500. It runs with the response status set to 500
+// and the panic, as a *types.PanicError, or the returned error available
+// through types.ErrorCause. By default an error page without details is
+// shown.
+func (s *Server) WithInternalErrorHandler(h types.Handler) *Server {
+	s.internalError = h
+	return s
+}
+
+// mapError is the types.ErrorMapper for errors returned by handlers. An
+// *types.HTTPError in err's chain gives the status and the message shown to
+// the client; any other error is a 500 whose details are only logged.
+func (s Server) mapError(ctx context.Context, req types.Request, res *types.Response, err error) {
+	status := types.StatusInternalServerError
+	var he *types.HTTPError
+	if errors.As(err, &he) {
+		status = he.Status
+	}
+	log := s.requestLog(req).With(
+		"method", string(req.Method),
+		"target", req.Target,
+		"status", status.Code(),
+		"err", err)
+	if status.Code() >= 500 {
+		log.Error("handler failed")
+	} else {
+		log.Info("handler rejected request")
+	}
+
+	var h types.Handler
+	switch status {
+	case types.StatusBadRequest:
+		h = s.badRequest
+	case types.StatusInternalServerError:
+		h = s.internalError
+	}
+	*res = s.errorResponse(ctx, req, status, err, h)
+}
+
+// rejectedRequest returns the status and handler answering a request that
+// could not be read because of err: the status of an *types.HTTPError in its
+// chain, or 400 through the bad request handler.
+func (s Server) rejectedRequest(err error) (types.Status, types.Handler) {
+	var he *types.HTTPError
+	if errors.As(err, &he) && he.Status != types.StatusBadRequest {
+		return he.Status, nil
+	}
+	return types.StatusBadRequest, s.badRequest
+}
+
+// errorResponse answers req with status through h, or with the default
+// error page when h is nil. The default page shows the message and fields of
+// an *types.HTTPError in cause's chain, or else for client errors the cause
+// itself. If h panics the default page is used instead.
+func (s Server) errorResponse(ctx context.Context, req types.Request, status types.Status, cause error, h types.Handler) (res types.Response) {
+	res = prepareResponse(req)
+	res.Status = status
+	if h != nil {
+		defer func() {
+			if p := recover(); p != nil {
+				s.reportPanic(req, p, debug.Stack())
+				res = prepareResponse(req)
+				errorpage.Write(req, &res, status, "")
+			}
+		}()
+		h(types.WithErrorCause(ctx, cause), req, &res)
+		return res
+	}
+	detail, fields := publicDetail(status, cause)
+	errorpage.Write(req, &res, status, detail, fields...)
+	return res
+}
+
+// publicDetail returns what the client may be told about cause.
+func publicDetail(status types.Status, cause error) (string, []types.FieldError) {
+	var he *types.HTTPError
+	switch {
+	case errors.As(cause, &he):
+		return he.Message, he.Fields
+	case cause != nil && status.Code() < 500:
+		return cause.Error(), nil
+	}
+	return "", nil
+}
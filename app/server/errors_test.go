@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/errorpage"
+	"github.com/codecrafters-io/http-server-starter-go/app/router"
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleConnection_ParseErrorPage(t *testing.T) {
+	s := &Server{}
+
+	status, headers, body, err := runServerTest(t, s, "GET / HTTP/1.1\r\nAccept: application/json\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+	assert.Equal(t, errorpage.ProblemType, headers["Content-Type"])
+	assert.Equal(t, "close", headers["Connection"])
+	var p errorpage.Problem
+	require.NoError(t, json.Unmarshal(body, &p))
+	assert.Equal(t, 400, p.Status)
+	assert.Equal(t, "missing Host header", p.Detail)
+}
+
+func TestHandleConnection_CustomBadRequestHandler(t *testing.T) {
+	var cause error
+	s := (&Server{}).WithBadRequestHandler(func(ctx context.Context, req types.Request, res *types.Response) {
+		cause = types.ErrorCause(ctx)
+		res.Headers["Content-Type"] = "text/plain"
+		res.Body = []byte("try again")
+	})
+
+	status, headers, body, err := runServerTest(t, s, "NOT HTTP\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+	assert.Equal(t, "close", headers["Connection"])
+	assert.Equal(t, "try again", string(body))
+	assert.ErrorContains(t, cause, "malformed request line")
+}
+
+func TestHandleConnection_InternalErrorPage(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		panic("secret state")
+	}
+
+	status, headers, body, err := runServerTest(t, &Server{handler: h}, "GET / HTTP/1.1\r\nHost: test.com\r\nAccept: text/html\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error", status)
+	assert.Equal(t, errorpage.HTMLType, headers["Content-Type"])
+	assert.Contains(t, string(body), "500 Internal Server Error")
+	assert.NotContains(t, string(body), "secret state")
+}
+
+func TestHandleConnection_CustomInternalErrorHandler(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		panic("boom")
+	}
+	var cause error
+	s := (&Server{handler: h}).WithInternalErrorHandler(func(ctx context.Context, req types.Request, res *types.Response) {
+		cause = types.ErrorCause(ctx)
+		res.Status = types.StatusOK
+		res.Body = []byte("degraded")
+	})
+
+	status, _, body, err := runServerTest(t, s, "GET / HTTP/1.1\r\nHost: test.com\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "degraded", string(body))
+	var pe *types.PanicError
+	require.ErrorAs(t, cause, &pe)
+	assert.Equal(t, "boom", pe.Value)
+}
+
+func TestHandleConnection_PanickingErrorHandlerFallsBack(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		panic("boom")
+	}
+	s := (&Server{handler: h}).WithInternalErrorHandler(func(ctx context.Context, req types.Request, res *types.Response) {
+		panic("error handler broke too")
+	})
+
+	status, _, body, err := runServerTest(t, s, "GET / HTTP/1.1\r\nHost: test.com\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error", status)
+	assert.Equal(t, "500 Internal Server Error", string(body))
+}
+
+func TestHandleConnection_HandlerErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus string
+		wantDetail string
+		wantLevel  string
+	}{
+		{"HTTP error shows its message", types.WrapHTTPError(errors.New("row 7 missing"), types.StatusNotFound, "no such user"), "HTTP/1.1 404 Not Found", "no such user", "INFO"},
+		{"other errors are hidden", errors.New("db password rejected"), "HTTP/1.1 500 Internal Server Error", "", "ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := router.New()
+			r.Register(types.Get, "/users/:id", types.FallibleHandler(func(ctx context.Context, req types.Request, res *types.Response) error {
+				return tt.err
+			}).Handler())
+			var logs bytes.Buffer
+			s := (&Server{}).WithHandler(r.HandleRequest).WithLogger(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+			status, headers, body, err := runServerTest(t, s, "GET /users/7 HTTP/1.1\r\nHost: test.com\r\nAccept: application/json\r\n\r\n")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, errorpage.ProblemType, headers["Content-Type"])
+			var p errorpage.Problem
+			require.NoError(t, json.Unmarshal(body, &p))
+			assert.Equal(t, tt.wantDetail, p.Detail)
+
+			var entry map[string]any
+			require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+			assert.Equal(t, tt.wantLevel, entry["level"])
+			assert.Equal(t, tt.err.Error(), entry["err"], "the internal cause is logged")
+		})
+	}
+}
+
+func TestHandleConnection_HandlerErrorUsesInternalErrorHandler(t *testing.T) {
+	failure := errors.New("boom")
+	h := func(ctx context.Context, req types.Request) types.Response {
+		res := types.Response{Headers: map[string]string{}}
+		types.FallibleHandler(func(ctx context.Context, req types.Request, res *types.Response) error {
+			return failure
+		}).Handler()(ctx, req, &res)
+		return res
+	}
+	var cause error
+	s := (&Server{handler: h}).
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))).
+		WithInternalErrorHandler(func(ctx context.Context, req types.Request, res *types.Response) {
+			cause = types.ErrorCause(ctx)
+			res.Body = []byte("sorry")
+		})
+
+	status, _, body, err := runServerTest(t, s, "GET / HTTP/1.1\r\nHost: test.com\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error", status)
+	assert.Equal(t, "sorry", string(body))
+	assert.Equal(t, failure, cause)
+}
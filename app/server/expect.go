@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// maxDrainSize bounds how much of an unread request body is discarded to keep
+// the connection open; larger remainders close it instead.
+const maxDrainSize = 256 << 10
+
+var errBodyAfterResponse = errors.New("request body read after the response was sent without 100 Continue")
+
+// interimWriter sends 1xx responses on an HTTP/1.1 connection until the final
+// response starts.
+type interimWriter struct {
+	w io.Writer
+
+	mu           sync.Mutex
+	responded    bool
+	continueSent bool
+}
+
+func (iw *interimWriter) WriteInterim(status types.Status, headers map[string]string) error {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	if iw.responded {
+		return types.ErrResponseStarted
+	}
+	return iw.writeLocked(status, headers)
+}
+
+func (iw *interimWriter) writeLocked(status types.Status, headers map[string]string) error {
+	var head strings.Builder
+	fmt.Fprintf(&head, "HTTP/1.1 %d %s\r\n", status.Code(), status.Reason())
+	for k, v := range headers {
+		fmt.Fprintf(&head, "%s: %s\r\n", k, v)
+	}
+	head.WriteString("\r\n")
+	_, err := io.WriteString(iw.w, head.String())
+	return err
+}
+
+// sendContinue sends 100 Continue once, unless the final response has
+// already started.
+func (iw *interimWriter) sendContinue() error {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	if iw.continueSent {
+		return nil
+	}
+	if iw.responded {
+		return errBodyAfterResponse
+	}
+	if err := iw.writeLocked(types.StatusContinue, nil); err != nil {
+		return err
+	}
+	iw.continueSent = true
+	return nil
+}
+
+// startResponse is called before the final response is written, after which
+// no interim response may follow. It reports whether 100 Continue was sent.
+func (iw *interimWriter) startResponse() bool {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	iw.responded = true
+	return iw.continueSent
+}
+
+// requestBody streams a request body from the connection as the handler
+// reads it. A client waiting on Expect: 100-continue is first told to send
+// the body with a 100 Continue interim response (RFC 9110 §10.1.1).
+type requestBody struct {
+	// interim is set when the client waits for 100 Continue.
+	interim *interimWriter
+	body    io.Reader
+
+	mu  sync.Mutex
+	eof bool
+	n   int64
+}
+
+func (r *requestBody) Read(p []byte) (int, error) {
+	if r.interim != nil {
+		if err := r.interim.sendContinue(); err != nil {
+			return 0, err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, err := r.body.Read(p)
+	r.n += int64(n)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// bytesRead returns how much of the body the handler has read.
+func (r *requestBody) bytesRead() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n
+}
+
+// drain discards the rest of a body the handler did not finish reading and
+// reports whether the connection is ready for the next request. A body the
+// client was never asked for cannot be skipped reliably.
+func (r *requestBody) drain(continueSent bool) bool {
+	if r.interim != nil && !continueSent {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.eof {
+		return true
+	}
+	_, err := io.CopyN(io.Discard, r.body, maxDrainSize+1)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return r.eof
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// connHijacker lets the handler of a single HTTP/1.x request take over the
+// connection. It is only usable while the handler runs.
+type connHijacker struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	mu       sync.Mutex
+	hijacked bool
+	finished bool
+}
+
+func (h *connHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hijacked {
+		return nil, nil, types.ErrHijacked
+	}
+	if h.finished {
+		return nil, nil, types.ErrNotHijackable
+	}
+	h.hijacked = true
+	return h.conn, bufio.NewReadWriter(h.reader, bufio.NewWriter(h.conn)), nil
+}
+
+// finish closes the hijack window and reports whether the handler took the
+// connection.
+func (h *connHijacker) finish() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.finished = true
+	return h.hijacked
+}
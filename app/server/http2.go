@@ -0,0 +1,1139 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	http2ALPN    = "h2"
+	http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+)
+
+// hasHTTP2Preface reports whether a cleartext connection starts with the
+// HTTP/2 client preface, i.e. the client uses h2c with prior knowledge.
+func hasHTTP2Preface(reader *bufio.Reader) bool {
+	// Every HTTP/1.1 request line is longer than three bytes, so peeking
+	// them first cannot block on a short request.
+	if b, err := reader.Peek(3); err != nil || string(b) != "PRI" {
+		return false
+	}
+	b, err := reader.Peek(len(http2Preface))
+	return err == nil && string(b) == http2Preface
+}
+
+// isH2CUpgrade reports whether req asks to switch to cleartext HTTP/2
+// (RFC 7540 §3.2).
+func isH2CUpgrade(req types.Request) bool {
+	if req.Version != "HTTP/1.1" || !headerHasToken(req.Header("Upgrade"), "h2c") {
+		return false
+	}
+	connection := req.Header("Connection")
+	return headerHasToken(connection, "Upgrade") && headerHasToken(connection, "HTTP2-Settings")
+}
+
+func headerHasToken(value, token string) bool {
+	for _, v := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeH2C switches the connection to HTTP/2 and answers req on stream 1.
+func (s Server) upgradeH2C(conn net.Conn, reader *bufio.Reader, req types.Request) {
+	var settings []h2Setting
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(req.Header("HTTP2-Settings"), "="))
+	if err == nil {
+		settings, err = parseH2Settings(payload)
+	}
+	if err == nil && req.BodyReader != nil {
+		// The body precedes the client preface on the connection, so it
+		// has to be read before switching.
+		var body []byte
+		if body, err = io.ReadAll(req.BodyReader); err == nil {
+			req.BodyReader = bytes.NewReader(body)
+		}
+	}
+	if err != nil {
+		start := time.Now()
+		ctx := s.assignRequestID(context.Background(), &req)
+		s.requestLog(req).Warn("invalid h2c upgrade request", "err", err)
+		status, h := s.rejectedRequest(err)
+		res := s.errorResponse(ctx, req, status, err, h)
+		echoRequestID(ctx, &res)
+		_, n := s.respond(conn, req, res)
+		s.logAccess(ctx, conn.RemoteAddr(), req, res.Status, n, start)
+		return
+	}
+
+	status := types.StatusSwitchingProtocols
+	switchLine := fmt.Sprintf("HTTP/1.1 %d %s\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n", status.Code(), status.Reason())
+	if _, err := conn.Write([]byte(switchLine)); err != nil {
+		s.log().Debug("failed to write h2c upgrade response", "err", err)
+		return
+	}
+
+	for _, h := range []string{"Connection", "Upgrade", "HTTP2-Settings", "Http2-Settings"} {
+		delete(req.Headers, h)
+	}
+	req.Version = "HTTP/2.0"
+	c := newH2Conn(s, conn, reader)
+	if err := c.applySettings(settings); err != nil {
+		s.log().Debug("invalid HTTP2-Settings", "err", err)
+		return
+	}
+	c.serve(&req)
+}
+
+// serveHTTP2 serves an HTTP/2 connection whose client preface has not been
+// read yet.
+func (s Server) serveHTTP2(conn net.Conn, reader *bufio.Reader, upgraded *types.Request) {
+	newH2Conn(s, conn, reader).serve(upgraded)
+}
+
+type h2Stream struct {
+	id           uint32
+	req          types.Request
+	body         *h2Body
+	sendWindow   int64
+	remoteClosed bool
+	reset        bool
+	ctx          context.Context
+	cancel       context.CancelFunc
+
+	// recvWindow is how much body the client may still send, and
+	// recvConsumed how much the handler has read since the last
+	// WINDOW_UPDATE. received counts the body bytes accepted, against
+	// bodyLimit, and is only used by the frame loop.
+	recvWindow   int64
+	recvConsumed int64
+	received     int64
+	bodyLimit    int64
+
+	// imu orders interim responses before the final one.
+	imu       sync.Mutex
+	responded bool
+
+	// sent counts the body bytes written, for the access log.
+	sent int64
+}
+
+// h2Body is the request body of a stream. The frame loop appends the DATA
+// it receives and the handler reads it as it arrives.
+type h2Body struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	// err is returned once buf is drained: io.EOF when the client ended the
+	// stream, or why the stream was aborted.
+	err error
+	n   int64
+	// onRead is told how many bytes each read consumed.
+	onRead func(n int)
+
+	// sendContinue, when set, tells a client waiting on Expect:
+	// 100-continue to send the body, before the first read.
+	sendContinue func()
+	continueOnce sync.Once
+}
+
+func newH2Body(onRead func(n int)) *h2Body {
+	b := &h2Body{onRead: onRead}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *h2Body) Read(p []byte) (int, error) {
+	if b.sendContinue != nil {
+		b.continueOnce.Do(b.sendContinue)
+	}
+	n, err := b.read(p)
+	if n > 0 {
+		b.onRead(n)
+	}
+	return n, err
+}
+
+func (b *h2Body) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.buf.Len() == 0 && b.err == nil {
+		b.cond.Wait()
+	}
+	if b.buf.Len() == 0 {
+		return 0, b.err
+	}
+	n, _ := b.buf.Read(p)
+	b.n += int64(n)
+	return n, nil
+}
+
+// write appends p to the body, reporting false if the body was closed and p
+// dropped.
+func (b *h2Body) write(p []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return false
+	}
+	b.buf.Write(p)
+	b.cond.Broadcast()
+	return true
+}
+
+// close ends the body with err. Data already received is still read before
+// io.EOF, but dropped for any other error; close returns how much was.
+func (b *h2Body) close(err error) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return 0
+	}
+	b.err = err
+	dropped := 0
+	if err != io.EOF {
+		dropped = b.buf.Len()
+		b.buf.Reset()
+	}
+	b.cond.Broadcast()
+	return int64(dropped)
+}
+
+// bytesRead returns how much of the body the handler has read.
+func (b *h2Body) bytesRead() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.n
+}
+
+// h2InterimWriter sends 1xx responses as HEADERS frames on one stream.
+type h2InterimWriter struct {
+	c  *h2Conn
+	st *h2Stream
+}
+
+func (w *h2InterimWriter) WriteInterim(status types.Status, headers map[string]string) error {
+	w.st.imu.Lock()
+	defer w.st.imu.Unlock()
+	if w.st.responded {
+		return types.ErrResponseStarted
+	}
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(status.Code())}}
+	for k, v := range headers {
+		name := strings.ToLower(k)
+		if !h2HopByHopHeaders[name] {
+			fields = append(fields, hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+	return w.c.writeHeaders(w.st.id, fields, false)
+}
+
+// h2Conn is a single HTTP/2 connection. Frames are read by the serve loop;
+// each stream's handler runs in its own goroutine and writes its response
+// frames under wmu, subject to the peer's flow-control windows. Request
+// bodies are buffered up to the receive windows, which are reopened as the
+// handlers read them.
+type h2Conn struct {
+	srv  Server
+	conn net.Conn
+	br   *bufio.Reader
+
+	wmu  sync.Mutex
+	bw   *bufio.Writer
+	henc *hpack.Encoder
+	hbuf bytes.Buffer
+
+	hdec *hpack.Decoder
+
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*h2Stream
+	sendWindow        int64
+	recvWindow        int64
+	recvConsumed      int64
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	lastStreamID      uint32
+	goingAway         bool
+	closed            bool
+	// handlers counts running handlers, including those of streams the
+	// client has reset, against h2MaxConcurrentStreams. resets counts the
+	// RST_STREAM frames received since resetsSince.
+	handlers    int
+	resets      int
+	resetsSince time.Time
+
+	// Header block being assembled from HEADERS and CONTINUATION frames.
+	continuationID  uint32
+	continuationEnd bool
+	headerBlock     []byte
+}
+
+func newH2Conn(s Server, conn net.Conn, reader *bufio.Reader) *h2Conn {
+	c := &h2Conn{
+		srv:               s,
+		conn:              conn,
+		br:                reader,
+		bw:                bufio.NewWriter(conn),
+		hdec:              hpack.NewDecoder(4096, nil),
+		streams:           make(map[uint32]*h2Stream),
+		sendWindow:        h2DefaultWindowSize,
+		recvWindow:        h2ConnRecvWindow,
+		peerInitialWindow: h2DefaultWindowSize,
+		peerMaxFrameSize:  h2DefaultMaxFrameSize,
+	}
+	c.henc = hpack.NewEncoder(&c.hbuf)
+	c.hdec.SetMaxStringLength(h2MaxHeaderListSize)
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *h2Conn) serve(upgraded *types.Request) {
+	defer c.shutdown()
+
+	// The preface gets the time a request's headers would. Afterwards the
+	// connection only times out while it has no open streams.
+	c.conn.SetReadDeadline(readDeadline(c.srv.readHeaderTimeout, DefaultReadHeaderTimeout))
+	preface := make([]byte, len(http2Preface))
+	if _, err := io.ReadFull(c.br, preface); err != nil || string(preface) != http2Preface {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			c.srv.log().Debug("timed out reading HTTP/2 client preface", "remote_addr", c.conn.RemoteAddr().String())
+			return
+		}
+		c.srv.log().Warn("invalid HTTP/2 client preface", "remote_addr", c.conn.RemoteAddr().String())
+		return
+	}
+	c.conn.SetReadDeadline(readDeadline(c.srv.idleTimeout, DefaultIdleTimeout))
+
+	var settings []byte
+	settings = binary.BigEndian.AppendUint16(settings, h2SettingMaxConcurrentStreams)
+	settings = binary.BigEndian.AppendUint32(settings, h2MaxConcurrentStreams)
+	settings = binary.BigEndian.AppendUint16(settings, h2SettingEnablePush)
+	settings = binary.BigEndian.AppendUint32(settings, 0)
+	settings = binary.BigEndian.AppendUint16(settings, h2SettingMaxHeaderListSize)
+	settings = binary.BigEndian.AppendUint32(settings, h2MaxHeaderListSize)
+	settings = binary.BigEndian.AppendUint16(settings, h2SettingInitialWindowSize)
+	settings = binary.BigEndian.AppendUint32(settings, h2StreamRecvWindow)
+	if err := c.writeFrame(h2FrameSettings, 0, 0, settings); err != nil {
+		c.srv.log().Debug("failed to write HTTP/2 settings", "err", err)
+		return
+	}
+	// The connection window can only be raised with WINDOW_UPDATE.
+	if err := c.writeWindowUpdate(0, h2ConnRecvWindow-h2DefaultWindowSize); err != nil {
+		c.srv.log().Debug("failed to write HTTP/2 window update", "err", err)
+		return
+	}
+
+	if upgraded != nil {
+		c.lastStreamID = 1
+		st := c.newStream(1, *upgraded)
+		c.endStream(st)
+		c.dispatch(st)
+	}
+
+	for {
+		f, err := readH2Frame(c.br, h2DefaultMaxFrameSize)
+		if err == nil {
+			err = c.processFrame(f)
+		}
+		if err == nil {
+			continue
+		}
+
+		var streamErr h2StreamError
+		if errors.As(err, &streamErr) {
+			c.resetStream(streamErr.streamID, streamErr.code)
+			continue
+		}
+		var connErr h2ConnError
+		if errors.As(err, &connErr) {
+			c.srv.log().Warn("HTTP/2 connection error", "remote_addr", c.conn.RemoteAddr().String(), "err", connErr)
+			c.goAway(connErr.code)
+			return
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// The deadline is only set while no stream is open.
+			c.srv.log().Debug("closing idle HTTP/2 connection", "remote_addr", c.conn.RemoteAddr().String())
+			c.goAway(h2NoError)
+			return
+		}
+		if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+			c.srv.log().Debug("failed to read HTTP/2 frame", "err", err)
+		}
+		return
+	}
+}
+
+func (c *h2Conn) processFrame(f h2Frame) error {
+	if c.continuationID != 0 && (f.typ != h2FrameContinuation || f.streamID != c.continuationID) {
+		return h2ConnError{h2ProtocolError, "expected CONTINUATION frame"}
+	}
+
+	switch f.typ {
+	case h2FrameData:
+		return c.processData(f)
+	case h2FrameHeaders:
+		return c.processHeaders(f)
+	case h2FrameContinuation:
+		if c.continuationID == 0 {
+			return h2ConnError{h2ProtocolError, "unexpected CONTINUATION frame"}
+		}
+		if len(c.headerBlock)+len(f.payload) > h2MaxHeaderListSize {
+			return h2ConnError{h2EnhanceYourCalm, "header block too large"}
+		}
+		c.headerBlock = append(c.headerBlock, f.payload...)
+		if f.has(h2FlagEndHeaders) {
+			id, end, block := c.continuationID, c.continuationEnd, c.headerBlock
+			c.continuationID, c.headerBlock = 0, nil
+			return c.processHeaderBlock(id, block, end)
+		}
+		return nil
+	case h2FramePriority:
+		if f.streamID == 0 {
+			return h2ConnError{h2ProtocolError, "PRIORITY on stream 0"}
+		}
+		if len(f.payload) != 5 {
+			return h2StreamError{f.streamID, h2FrameSizeError, "PRIORITY payload must be 5 bytes"}
+		}
+		return nil
+	case h2FrameRSTStream:
+		return c.processRSTStream(f)
+	case h2FrameSettings:
+		return c.processSettings(f)
+	case h2FramePushPromise:
+		return h2ConnError{h2ProtocolError, "clients must not send PUSH_PROMISE"}
+	case h2FramePing:
+		if f.streamID != 0 {
+			return h2ConnError{h2ProtocolError, "PING on a stream"}
+		}
+		if len(f.payload) != 8 {
+			return h2ConnError{h2FrameSizeError, "PING payload must be 8 bytes"}
+		}
+		if f.has(h2FlagAck) {
+			return nil
+		}
+		return c.writeFrame(h2FramePing, h2FlagAck, 0, f.payload)
+	case h2FrameGoAway:
+		c.mu.Lock()
+		c.goingAway = true
+		c.mu.Unlock()
+		return nil
+	case h2FrameWindowUpdate:
+		return c.processWindowUpdate(f)
+	}
+	// Unknown frame types must be ignored.
+	return nil
+}
+
+func (c *h2Conn) processSettings(f h2Frame) error {
+	if f.streamID != 0 {
+		return h2ConnError{h2ProtocolError, "SETTINGS on a stream"}
+	}
+	if f.has(h2FlagAck) {
+		if len(f.payload) != 0 {
+			return h2ConnError{h2FrameSizeError, "SETTINGS ACK with payload"}
+		}
+		return nil
+	}
+	settings, err := parseH2Settings(f.payload)
+	if err != nil {
+		return err
+	}
+	for _, st := range settings {
+		switch st.id {
+		case h2SettingInitialWindowSize:
+			if st.value > h2MaxWindowSize {
+				return h2ConnError{h2FlowControlError, "initial window size too large"}
+			}
+		case h2SettingMaxFrameSize:
+			if st.value < h2DefaultMaxFrameSize || st.value > h2MaxAllowedFrameSize {
+				return h2ConnError{h2ProtocolError, "invalid max frame size"}
+			}
+		case h2SettingEnablePush:
+			if st.value > 1 {
+				return h2ConnError{h2ProtocolError, "invalid enable push value"}
+			}
+		}
+	}
+	if err := c.applySettings(settings); err != nil {
+		return err
+	}
+	return c.writeFrame(h2FrameSettings, h2FlagAck, 0, nil)
+}
+
+// applySettings applies the peer's settings. A new initial window size
+// shifts the send window of every open stream, which must not overflow
+// (RFC 9113 §6.9.2).
+func (c *h2Conn) applySettings(settings []h2Setting) error {
+	for _, st := range settings {
+		switch st.id {
+		case h2SettingHeaderTableSize:
+			c.wmu.Lock()
+			c.henc.SetMaxDynamicTableSizeLimit(st.value)
+			c.wmu.Unlock()
+		case h2SettingInitialWindowSize:
+			c.mu.Lock()
+			delta := int64(st.value) - c.peerInitialWindow
+			for _, s := range c.streams {
+				if s.sendWindow+delta > h2MaxWindowSize {
+					c.mu.Unlock()
+					return h2ConnError{h2FlowControlError, "stream window overflow"}
+				}
+			}
+			c.peerInitialWindow = int64(st.value)
+			for _, s := range c.streams {
+				s.sendWindow += delta
+			}
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		case h2SettingMaxFrameSize:
+			c.mu.Lock()
+			c.peerMaxFrameSize = st.value
+			c.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func (c *h2Conn) processWindowUpdate(f h2Frame) error {
+	if len(f.payload) != 4 {
+		return h2ConnError{h2FrameSizeError, "WINDOW_UPDATE payload must be 4 bytes"}
+	}
+	increment := int64(binary.BigEndian.Uint32(f.payload) & 0x7fffffff)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.streamID == 0 {
+		if increment == 0 {
+			return h2ConnError{h2ProtocolError, "zero window increment"}
+		}
+		if c.sendWindow+increment > h2MaxWindowSize {
+			return h2ConnError{h2FlowControlError, "connection window overflow"}
+		}
+		c.sendWindow += increment
+		c.cond.Broadcast()
+		return nil
+	}
+
+	if increment == 0 {
+		return h2StreamError{f.streamID, h2ProtocolError, "zero window increment"}
+	}
+	st, ok := c.streams[f.streamID]
+	if !ok {
+		return nil
+	}
+	if st.sendWindow+increment > h2MaxWindowSize {
+		return h2StreamError{f.streamID, h2FlowControlError, "stream window overflow"}
+	}
+	st.sendWindow += increment
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *h2Conn) processRSTStream(f h2Frame) error {
+	if f.streamID == 0 {
+		return h2ConnError{h2ProtocolError, "RST_STREAM on stream 0"}
+	}
+	if len(f.payload) != 4 {
+		return h2ConnError{h2FrameSizeError, "RST_STREAM payload must be 4 bytes"}
+	}
+	c.mu.Lock()
+	if f.streamID > c.lastStreamID {
+		c.mu.Unlock()
+		return h2ConnError{h2ProtocolError, "RST_STREAM on idle stream"}
+	}
+	if st, ok := c.streams[f.streamID]; ok {
+		c.closeStreamLocked(st)
+	}
+	if now := time.Now(); now.Sub(c.resetsSince) > time.Second {
+		c.resets, c.resetsSince = 0, now
+	}
+	c.resets++
+	tooMany := c.resets > h2MaxResetsPerSecond
+	c.mu.Unlock()
+	if tooMany {
+		// Opening and at once resetting streams costs the client next to
+		// nothing but the server a handler each (CVE-2023-44487).
+		return h2ConnError{h2EnhanceYourCalm, "too many stream resets"}
+	}
+	return c.consumeRecv(nil, 0)
+}
+
+func (c *h2Conn) processHeaders(f h2Frame) error {
+	if f.streamID == 0 {
+		return h2ConnError{h2ProtocolError, "HEADERS on stream 0"}
+	}
+	block, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+	if f.has(h2FlagPriority) {
+		if len(block) < 5 {
+			return h2ConnError{h2FrameSizeError, "HEADERS priority fields truncated"}
+		}
+		block = block[5:]
+	}
+	if !f.has(h2FlagEndHeaders) {
+		c.continuationID = f.streamID
+		c.continuationEnd = f.has(h2FlagEndStream)
+		c.headerBlock = append([]byte(nil), block...)
+		return nil
+	}
+	return c.processHeaderBlock(f.streamID, block, f.has(h2FlagEndStream))
+}
+
+func (c *h2Conn) processHeaderBlock(streamID uint32, block []byte, endStream bool) error {
+	// The block is decoded even for streams that get refused, to keep the
+	// HPACK dynamic table in sync with the client.
+	fields, err := c.decodeHeaderBlock(block)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	st, exists := c.streams[streamID]
+	if exists {
+		c.mu.Unlock()
+		// Trailers: accepted and dropped, they must end the stream.
+		if st.remoteClosed || !endStream {
+			return h2StreamError{streamID, h2ProtocolError, "unexpected HEADERS on open stream"}
+		}
+		c.endStream(st)
+		return nil
+	}
+	if streamID%2 == 0 || streamID <= c.lastStreamID {
+		c.mu.Unlock()
+		return h2ConnError{h2ProtocolError, "invalid stream identifier"}
+	}
+	c.lastStreamID = streamID
+	// A reset stream's handler may run on, so it still counts until it
+	// returns; otherwise resetting streams would let a client start
+	// handlers without limit.
+	refused := c.goingAway || c.handlers >= h2MaxConcurrentStreams
+	c.mu.Unlock()
+	if refused {
+		return h2StreamError{streamID, h2RefusedStream, "too many concurrent streams"}
+	}
+
+	req, err := requestFromH2Fields(fields)
+	if err != nil {
+		return h2StreamError{streamID, h2ProtocolError, err.Error()}
+	}
+	st = c.newStream(streamID, req)
+	if endStream {
+		c.endStream(st)
+	} else {
+		st.bodyLimit = c.srv.maxBodySize(req)
+		if n, err := strconv.ParseInt(req.Headers["Content-Length"], 10, 64); err == nil && st.bodyLimit >= 0 && n > st.bodyLimit {
+			st.body.close(bodyTooLarge(st.bodyLimit))
+		}
+		if strings.EqualFold(req.Headers["Expect"], "100-continue") {
+			iw := &h2InterimWriter{c: c, st: st}
+			st.body.sendContinue = func() { iw.WriteInterim(types.StatusContinue, nil) }
+		}
+		st.req.BodyReader = st.body
+	}
+	c.dispatch(st)
+	return nil
+}
+
+func (c *h2Conn) processData(f h2Frame) error {
+	if f.streamID == 0 {
+		return h2ConnError{h2ProtocolError, "DATA on stream 0"}
+	}
+	data, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+	n := int64(len(f.payload))
+
+	c.mu.Lock()
+	st, ok := c.streams[f.streamID]
+	if f.streamID > c.lastStreamID {
+		c.mu.Unlock()
+		return h2ConnError{h2ProtocolError, "DATA on idle stream"}
+	}
+	if n > c.recvWindow {
+		c.mu.Unlock()
+		return h2ConnError{h2FlowControlError, "connection flow-control window exceeded"}
+	}
+	c.recvWindow -= n
+	open := ok && !st.remoteClosed
+	overrun := open && n > st.recvWindow
+	if open && !overrun {
+		st.recvWindow -= n
+	}
+	c.mu.Unlock()
+
+	if !open || overrun {
+		// The data is dropped, so the connection gets its window back.
+		if err := c.consumeRecv(nil, n); err != nil {
+			return err
+		}
+		if overrun {
+			return h2StreamError{f.streamID, h2FlowControlError, "stream flow-control window exceeded"}
+		}
+		return h2StreamError{f.streamID, h2StreamClosed, "DATA on closed stream"}
+	}
+
+	var dropped int64
+	if st.bodyLimit >= 0 && st.received+int64(len(data)) > st.bodyLimit {
+		dropped = st.body.close(bodyTooLarge(st.bodyLimit))
+	}
+	st.received += int64(len(data))
+	if st.body.write(data) {
+		// Padding is never read, so its window is given back now and the
+		// data's once the handler reads it.
+		err = c.consumeRecv(st, n-int64(len(data)))
+	} else {
+		// A body that failed keeps the stream's window shut, but must not
+		// hold up the other streams.
+		err = c.consumeRecv(nil, n+dropped)
+	}
+	if err != nil {
+		return err
+	}
+	if f.has(h2FlagEndStream) {
+		c.endStream(st)
+	}
+	return nil
+}
+
+// consumeRecv records that n received bytes were consumed on the connection
+// and, unless it is nil, on st, and sends the WINDOW_UPDATE frames that are
+// due. Window is given back once half of it has been consumed, rather than
+// after every read.
+func (c *h2Conn) consumeRecv(st *h2Stream, n int64) error {
+	var connIncrement, streamIncrement int64
+	c.mu.Lock()
+	c.recvConsumed += n
+	if c.recvConsumed >= h2ConnRecvWindow/2 {
+		connIncrement, c.recvConsumed = c.recvConsumed, 0
+		c.recvWindow += connIncrement
+	}
+	if st != nil && !st.remoteClosed && !st.reset {
+		st.recvConsumed += n
+		if st.recvConsumed >= h2StreamRecvWindow/2 {
+			streamIncrement, st.recvConsumed = st.recvConsumed, 0
+			st.recvWindow += streamIncrement
+		}
+	}
+	c.mu.Unlock()
+
+	if connIncrement > 0 {
+		if err := c.writeWindowUpdate(0, uint32(connIncrement)); err != nil {
+			return err
+		}
+	}
+	if streamIncrement > 0 {
+		return c.writeWindowUpdate(st.id, uint32(streamIncrement))
+	}
+	return nil
+}
+
+// decodeHeaderBlock decodes a complete header block, failing once the header
+// list grows beyond h2MaxHeaderListSize. Fields past the limit are decoded
+// but not kept, as the limit is fatal to the connection anyway.
+func (c *h2Conn) decodeHeaderBlock(block []byte) ([]hpack.HeaderField, error) {
+	var fields []hpack.HeaderField
+	var size uint32
+	c.hdec.SetEmitFunc(func(f hpack.HeaderField) {
+		size += f.Size()
+		if size <= h2MaxHeaderListSize {
+			fields = append(fields, f)
+		}
+	})
+	defer c.hdec.SetEmitFunc(nil)
+	_, err := c.hdec.Write(block)
+	if err == nil {
+		err = c.hdec.Close()
+	}
+	switch {
+	case errors.Is(err, hpack.ErrStringLength) || size > h2MaxHeaderListSize:
+		return nil, h2ConnError{h2EnhanceYourCalm, "header list too large"}
+	case err != nil:
+		return nil, h2ConnError{h2CompressionError, err.Error()}
+	}
+	return fields, nil
+}
+
+// requestFromH2Fields converts a decoded header block into a Request. Header
+// names are canonicalised so handlers written against HTTP/1.1 requests find
+// them under the same keys.
+func requestFromH2Fields(fields []hpack.HeaderField) (types.Request, error) {
+	req := types.Request{
+		Version: "HTTP/2.0",
+		Headers: make(map[string]string),
+	}
+	var scheme string
+	regular := false
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			if regular {
+				return req, errors.New("pseudo-header after regular header")
+			}
+			switch f.Name {
+			case ":method":
+				req.Method = types.Method(f.Value)
+			case ":path":
+				req.Target = f.Value
+			case ":scheme":
+				scheme = f.Value
+			case ":authority":
+				req.Headers["Host"] = f.Value
+			default:
+				return req, fmt.Errorf("unknown pseudo-header %q", f.Name)
+			}
+			continue
+		}
+		regular = true
+		if f.Name != strings.ToLower(f.Name) {
+			return req, fmt.Errorf("uppercase header name %q", f.Name)
+		}
+		switch f.Name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			return req, fmt.Errorf("connection-specific header %q", f.Name)
+		case "te":
+			if f.Value != "trailers" {
+				return req, errors.New("TE header other than trailers")
+			}
+		}
+		key := textproto.CanonicalMIMEHeaderKey(f.Name)
+		if existing, ok := req.Headers[key]; ok {
+			sep := ", "
+			if key == "Cookie" {
+				sep = "; "
+			}
+			req.Headers[key] = existing + sep + f.Value
+		} else {
+			req.Headers[key] = f.Value
+		}
+	}
+	if req.Method == "" || req.Target == "" || scheme == "" {
+		return req, errors.New("missing required pseudo-header")
+	}
+	return req, nil
+}
+
+func (c *h2Conn) newStream(id uint32, req types.Request) *h2Stream {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := &h2Stream{
+		id:         id,
+		req:        req,
+		sendWindow: c.peerInitialWindow,
+		recvWindow: h2StreamRecvWindow,
+		cancel:     cancel,
+	}
+	st.body = newH2Body(func(n int) { c.consumeRecv(st, int64(n)) })
+	st.ctx = types.WithRoute(types.WithInterimWriter(ctx, &h2InterimWriter{c: c, st: st}))
+	st.ctx = c.srv.assignRequestID(st.ctx, &st.req)
+	st.ctx = c.srv.startSpan(st.ctx, st.req, c.conn.RemoteAddr())
+	c.streams[id] = st
+	return st
+}
+
+// endStream marks the request side of st complete.
+func (c *h2Conn) endStream(st *h2Stream) {
+	c.mu.Lock()
+	st.remoteClosed = true
+	c.mu.Unlock()
+	st.body.close(io.EOF)
+}
+
+func (c *h2Conn) dispatch(st *h2Stream) {
+	c.mu.Lock()
+	if c.handlers++; c.handlers == 1 {
+		c.conn.SetReadDeadline(time.Time{})
+	}
+	c.mu.Unlock()
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			if c.handlers--; c.handlers == 0 {
+				// No stream is left open, so the idle timeout starts.
+				c.conn.SetReadDeadline(readDeadline(c.srv.idleTimeout, DefaultIdleTimeout))
+			}
+			c.mu.Unlock()
+		}()
+		defer func() {
+			// Only reached once the response has started; handler panics
+			// become 500s in serveRequest.
+			if p := recover(); p != nil {
+				c.srv.reportPanic(st.req, p, debug.Stack())
+				c.resetStream(st.id, h2InternalError)
+				c.mu.Lock()
+				c.closeStreamLocked(st)
+				c.mu.Unlock()
+			}
+		}()
+		start := time.Now()
+		res := c.srv.serveRequest(st.ctx, st.req)
+		echoRequestID(st.ctx, &res)
+		if err := c.writeResponse(st, res); err != nil && !errors.Is(err, errH2StreamGone) {
+			c.srv.requestLog(st.req).Debug("failed to write HTTP/2 response", "stream", st.id, "err", err)
+			c.srv.reportStreamPanic(st.req, err)
+			c.resetStream(st.id, h2InternalError)
+		}
+		c.srv.recordRequest(st.ctx, c.conn.RemoteAddr(), st.req, res.Status, st.body.bytesRead(), st.sent, start)
+		c.mu.Lock()
+		c.closeStreamLocked(st)
+		c.mu.Unlock()
+		c.consumeRecv(nil, 0)
+	}()
+}
+
+// h2HopByHopHeaders are connection-specific and not allowed in HTTP/2.
+var h2HopByHopHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+func (c *h2Conn) writeResponse(st *h2Stream, res types.Response) error {
+	st.imu.Lock()
+	st.responded = true
+	st.imu.Unlock()
+	if res.Headers == nil {
+		res.Headers = make(map[string]string)
+	}
+	body, streamEncoder := c.srv.encodeBody(st.req, &res)
+	var trailers []string
+	if res.Trailers != nil {
+		trailers = declaredTrailers(res.Headers)
+	}
+	if len(trailers) > 0 {
+		res.Headers["Trailer"] = strings.Join(trailers, ", ")
+	} else {
+		delete(res.Headers, "Trailer")
+	}
+	hasBody := len(body) > 0 || res.BodyReader != nil || len(trailers) > 0
+
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(res.Status.Code())}}
+	for k, v := range res.Headers {
+		name := strings.ToLower(k)
+		if h2HopByHopHeaders[name] {
+			continue
+		}
+		fields = append(fields, hpack.HeaderField{Name: name, Value: v})
+	}
+	if err := c.writeHeaders(st.id, fields, !hasBody); err != nil {
+		return err
+	}
+	if !hasBody {
+		return nil
+	}
+
+	if res.BodyReader == nil {
+		if len(trailers) == 0 {
+			return c.writeData(st, body, true)
+		}
+		if len(body) > 0 {
+			if err := c.writeData(st, body, false); err != nil {
+				return err
+			}
+		}
+	} else {
+		dw := &h2DataWriter{c: c, st: st}
+		if err := streamBody(dw, res.BodyReader, streamEncoder); err != nil {
+			return err
+		}
+	}
+	return c.writeTrailers(st, trailerFields(res, trailers))
+}
+
+// writeTrailers ends st with a trailing HEADERS frame, or with an empty DATA
+// frame when there are no trailer fields (RFC 9113 §8.1).
+func (c *h2Conn) writeTrailers(st *h2Stream, trailers []trailerField) error {
+	if len(trailers) == 0 {
+		return c.writeData(st, nil, true)
+	}
+	fields := make([]hpack.HeaderField, 0, len(trailers))
+	for _, f := range trailers {
+		fields = append(fields, hpack.HeaderField{Name: strings.ToLower(f.name), Value: f.value})
+	}
+	return c.writeHeaders(st.id, fields, true)
+}
+
+// h2DataWriter writes DATA frames for one stream.
+type h2DataWriter struct {
+	c  *h2Conn
+	st *h2Stream
+}
+
+func (w *h2DataWriter) Write(p []byte) (int, error) {
+	if err := w.c.writeData(w.st, p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+var errH2StreamGone = errors.New("http2 stream closed")
+
+// writeData sends p on st as DATA frames no larger than the peer's maximum
+// frame size, waiting for flow-control window as needed.
+func (c *h2Conn) writeData(st *h2Stream, p []byte, endStream bool) error {
+	for {
+		n, err := c.reserveWindow(st, len(p))
+		if err != nil {
+			return err
+		}
+		chunk := p[:n]
+		p = p[n:]
+		var flags uint8
+		if endStream && len(p) == 0 {
+			flags = h2FlagEndStream
+		}
+		if err := c.writeFrame(h2FrameData, flags, st.id, chunk); err != nil {
+			return err
+		}
+		st.sent += int64(len(chunk))
+		if len(p) == 0 {
+			return nil
+		}
+	}
+}
+
+// reserveWindow blocks until up to want bytes may be sent on st and deducts
+// them from the stream and connection windows.
+func (c *h2Conn) reserveWindow(st *h2Stream, want int) (int, error) {
+	if want == 0 {
+		return 0, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if st.reset || c.closed {
+			return 0, errH2StreamGone
+		}
+		n := min(int64(want), st.sendWindow, c.sendWindow, int64(c.peerMaxFrameSize))
+		if n > 0 {
+			st.sendWindow -= n
+			c.sendWindow -= n
+			return int(n), nil
+		}
+		c.cond.Wait()
+	}
+}
+
+func (c *h2Conn) writeHeaders(streamID uint32, fields []hpack.HeaderField, endStream bool) error {
+	c.mu.Lock()
+	maxFrame := int(c.peerMaxFrameSize)
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.hbuf.Reset()
+	for _, f := range fields {
+		if err := c.henc.WriteField(f); err != nil {
+			return err
+		}
+	}
+	block := c.hbuf.Bytes()
+
+	typ := h2FrameHeaders
+	for first := true; first || len(block) > 0; first = false {
+		n := min(len(block), maxFrame)
+		var flags uint8
+		if first && endStream {
+			flags |= h2FlagEndStream
+		}
+		if n == len(block) {
+			flags |= h2FlagEndHeaders
+		}
+		if err := c.writeFrameLocked(typ, flags, streamID, block[:n]); err != nil {
+			return err
+		}
+		block = block[n:]
+		typ = h2FrameContinuation
+	}
+	return c.bw.Flush()
+}
+
+func (c *h2Conn) writeWindowUpdate(streamID, increment uint32) error {
+	return c.writeFrame(h2FrameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+func (c *h2Conn) writeFrame(typ, flags uint8, streamID uint32, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.writeFrameLocked(typ, flags, streamID, payload); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+func (c *h2Conn) writeFrameLocked(typ, flags uint8, streamID uint32, payload []byte) error {
+	header := appendH2FrameHeader(make([]byte, 0, h2FrameHeaderLen), len(payload), typ, flags, streamID)
+	if _, err := c.bw.Write(header); err != nil {
+		return err
+	}
+	_, err := c.bw.Write(payload)
+	return err
+}
+
+func (c *h2Conn) resetStream(streamID uint32, code h2ErrCode) {
+	c.mu.Lock()
+	if st, ok := c.streams[streamID]; ok {
+		c.closeStreamLocked(st)
+	}
+	c.mu.Unlock()
+	if err := c.writeFrame(h2FrameRSTStream, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(code))); err != nil {
+		c.srv.log().Debug("failed to write RST_STREAM", "stream", streamID, "err", err)
+	}
+	c.consumeRecv(nil, 0)
+}
+
+func (c *h2Conn) closeStreamLocked(st *h2Stream) {
+	st.reset = true
+	st.cancel()
+	// Body data the handler will never read is returned to the connection
+	// window by the next consumeRecv.
+	c.recvConsumed += st.body.close(errH2StreamGone)
+	delete(c.streams, st.id)
+	c.cond.Broadcast()
+}
+
+func (c *h2Conn) goAway(code h2ErrCode) {
+	c.mu.Lock()
+	last := c.lastStreamID
+	c.mu.Unlock()
+	payload := binary.BigEndian.AppendUint32(nil, last)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	if err := c.writeFrame(h2FrameGoAway, 0, 0, payload); err != nil {
+		c.srv.log().Debug("failed to write GOAWAY", "err", err)
+	}
+}
+
+// shutdown aborts every stream once the connection is done.
+func (c *h2Conn) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, st := range c.streams {
+		c.closeStreamLocked(st)
+	}
+}
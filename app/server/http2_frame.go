@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// HTTP/2 frame types (RFC 9113 §6).
+const (
+	h2FrameData         uint8 = 0x0
+	h2FrameHeaders      uint8 = 0x1
+	h2FramePriority     uint8 = 0x2
+	h2FrameRSTStream    uint8 = 0x3
+	h2FrameSettings     uint8 = 0x4
+	h2FramePushPromise  uint8 = 0x5
+	h2FramePing         uint8 = 0x6
+	h2FrameGoAway       uint8 = 0x7
+	h2FrameWindowUpdate uint8 = 0x8
+	h2FrameContinuation uint8 = 0x9
+)
+
+// HTTP/2 frame flags.
+const (
+	h2FlagEndStream  uint8 = 0x1
+	h2FlagAck        uint8 = 0x1
+	h2FlagEndHeaders uint8 = 0x4
+	h2FlagPadded     uint8 = 0x8
+	h2FlagPriority   uint8 = 0x20
+)
+
+// HTTP/2 settings identifiers (RFC 9113 §6.5.2).
+const (
+	h2SettingHeaderTableSize      uint16 = 0x1
+	h2SettingEnablePush           uint16 = 0x2
+	h2SettingMaxConcurrentStreams uint16 = 0x3
+	h2SettingInitialWindowSize    uint16 = 0x4
+	h2SettingMaxFrameSize         uint16 = 0x5
+	h2SettingMaxHeaderListSize    uint16 = 0x6
+)
+
+const (
+	h2FrameHeaderLen       = 9
+	h2DefaultMaxFrameSize  = 16384
+	h2MaxAllowedFrameSize  = 1<<24 - 1
+	h2DefaultWindowSize    = 65535
+	h2MaxWindowSize        = 1<<31 - 1
+	h2MaxConcurrentStreams = 100
+	// h2MaxResetsPerSecond is how many streams a client may reset in a
+	// second before the connection is closed.
+	h2MaxResetsPerSecond = 200
+	// h2StreamRecvWindow and h2ConnRecvWindow are the receive windows the
+	// server grants, so that a request body is not held to 64 KiB per round
+	// trip. Every stream's buffered body counts against the connection's.
+	h2StreamRecvWindow = 256 << 10
+	h2ConnRecvWindow   = 1 << 20
+	// h2MaxHeaderListSize bounds a request's header block, both as received
+	// and once decoded, as sized by SETTINGS_MAX_HEADER_LIST_SIZE.
+	h2MaxHeaderListSize = 1 << 20
+)
+
+// h2ErrCode is an HTTP/2 error code (RFC 9113 §7).
+type h2ErrCode uint32
+
+const (
+	h2NoError          h2ErrCode = 0x0
+	h2ProtocolError    h2ErrCode = 0x1
+	h2InternalError    h2ErrCode = 0x2
+	h2FlowControlError h2ErrCode = 0x3
+	h2StreamClosed     h2ErrCode = 0x5
+	h2FrameSizeError   h2ErrCode = 0x6
+	h2RefusedStream    h2ErrCode = 0x7
+	h2Cancel           h2ErrCode = 0x8
+	h2CompressionError h2ErrCode = 0x9
+	h2EnhanceYourCalm  h2ErrCode = 0xb
+	h2HTTP11Required   h2ErrCode = 0xd
+)
+
+// h2ConnError is fatal to the whole connection and is answered with GOAWAY.
+type h2ConnError struct {
+	code   h2ErrCode
+	reason string
+}
+
+func (e h2ConnError) Error() string {
+	return fmt.Sprintf("http2 connection error %d: %s", e.code, e.reason)
+}
+
+// h2StreamError only affects one stream and is answered with RST_STREAM.
+type h2StreamError struct {
+	streamID uint32
+	code     h2ErrCode
+	reason   string
+}
+
+func (e h2StreamError) Error() string {
+	return fmt.Sprintf("http2 stream %d error %d: %s", e.streamID, e.code, e.reason)
+}
+
+type h2Frame struct {
+	typ      uint8
+	flags    uint8
+	streamID uint32
+	payload  []byte
+}
+
+func (f h2Frame) has(flag uint8) bool {
+	return f.flags&flag != 0
+}
+
+// readH2Frame reads one frame, rejecting payloads larger than maxSize.
+func readH2Frame(r io.Reader, maxSize uint32) (h2Frame, error) {
+	var header [h2FrameHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return h2Frame{}, err
+	}
+	length := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+	f := h2Frame{
+		typ:      header[3],
+		flags:    header[4],
+		streamID: binary.BigEndian.Uint32(header[5:]) & 0x7fffffff,
+	}
+	if length > maxSize {
+		return f, h2ConnError{h2FrameSizeError, fmt.Sprintf("frame of %d bytes exceeds limit", length)}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+func appendH2FrameHeader(dst []byte, length int, typ, flags uint8, streamID uint32) []byte {
+	dst = append(dst, byte(length>>16), byte(length>>8), byte(length), typ, flags)
+	return binary.BigEndian.AppendUint32(dst, streamID&0x7fffffff)
+}
+
+// stripPadding removes the Pad Length field and trailing padding of a PADDED
+// DATA or HEADERS frame payload.
+func stripPadding(f h2Frame) ([]byte, error) {
+	if !f.has(h2FlagPadded) {
+		return f.payload, nil
+	}
+	if len(f.payload) == 0 {
+		return nil, h2ConnError{h2FrameSizeError, "padded frame without pad length"}
+	}
+	padLen := int(f.payload[0])
+	if padLen >= len(f.payload) {
+		return nil, h2ConnError{h2ProtocolError, "padding exceeds frame payload"}
+	}
+	return f.payload[1 : len(f.payload)-padLen], nil
+}
+
+type h2Setting struct {
+	id    uint16
+	value uint32
+}
+
+func parseH2Settings(payload []byte) ([]h2Setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, h2ConnError{h2FrameSizeError, "SETTINGS payload not a multiple of 6"}
+	}
+	settings := make([]h2Setting, 0, len(payload)/6)
+	for i := 0; i < len(payload); i += 6 {
+		settings = append(settings, h2Setting{
+			id:    binary.BigEndian.Uint16(payload[i:]),
+			value: binary.BigEndian.Uint32(payload[i+2:]),
+		})
+	}
+	return settings, nil
+}
@@ -0,0 +1,743 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+func startServer(t *testing.T, s *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go s.serve(l)
+	return l.Addr().String()
+}
+
+// h2cClient returns an HTTP/2 client that connects without TLS using prior
+// knowledge, counting the connections it opens.
+func h2cClient(dials *int) *http.Client {
+	var mu sync.Mutex
+	return &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				mu.Lock()
+				*dials++
+				mu.Unlock()
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+}
+
+func echoHandler(ctx context.Context, req types.Request) types.Response {
+	body := "method=" + string(req.Method) + " target=" + req.Target + " ua=" + req.Headers["User-Agent"]
+	if req.BodyReader != nil {
+		data, _ := io.ReadAll(req.BodyReader)
+		body += " body=" + string(data)
+	}
+	return types.Response{
+		Status:  types.StatusOK,
+		Headers: map[string]string{"Content-Type": "text/plain", "X-Proto": req.Version, "Connection": "keep-alive"},
+		Body:    []byte(body),
+	}
+}
+
+func TestHTTP2_PriorKnowledge(t *testing.T) {
+	addr := startServer(t, NewServer("").WithHandler(echoHandler))
+	var dials int
+	client := h2cClient(&dials)
+
+	req, err := http.NewRequest("GET", "http://"+addr+"/echo/abc", nil)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "h2-test")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "HTTP/2.0", resp.Header.Get("X-Proto"))
+	assert.Empty(t, resp.Header.Get("Connection"), "connection-specific headers must be dropped")
+	assert.Equal(t, "method=GET target=/echo/abc ua=h2-test", string(body))
+}
+
+func TestHTTP2_ALPN(t *testing.T) {
+	files := writeSelfSignedCert(t, t.TempDir(), "server", 1, "localhost")
+	s := NewServer("").WithHandler(echoHandler)
+	addr, _ := startTLSServer(t, s, files.CertFile, files.KeyFile)
+
+	client := &http.Client{Transport: &http2.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"},
+	}}
+	resp, err := client.Get("https://" + addr + "/secure")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Contains(t, string(body), "target=/secure")
+
+	// Clients that do not offer h2 still get HTTP/1.1.
+	_, status := peerCertificate(t, addr, "localhost")
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+}
+
+func TestHTTP2_MultiplexesStreams(t *testing.T) {
+	fastDone := make(chan struct{})
+	h := func(ctx context.Context, req types.Request) types.Response {
+		if req.Target == "/slow" {
+			// Only completes once a request started after it has finished.
+			select {
+			case <-fastDone:
+			case <-time.After(3 * time.Second):
+				return types.Response{Status: types.StatusInternalServerError}
+			}
+		}
+		return types.Response{Status: types.StatusOK, Body: []byte(req.Target)}
+	}
+	addr := startServer(t, NewServer("").WithHandler(h))
+	var dials int
+	client := h2cClient(&dials)
+
+	// Establish the connection first so both requests share it.
+	resp, err := client.Get("http://" + addr + "/warmup")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	slowStatus := make(chan int, 1)
+	go func() {
+		resp, err := client.Get("http://" + addr + "/slow")
+		if err != nil {
+			slowStatus <- 0
+			return
+		}
+		resp.Body.Close()
+		slowStatus <- resp.StatusCode
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	resp, err = client.Get("http://" + addr + "/fast")
+	require.NoError(t, err)
+	resp.Body.Close()
+	close(fastDone)
+
+	assert.Equal(t, http.StatusOK, <-slowStatus)
+	assert.Equal(t, 1, dials)
+}
+
+func TestHTTP2_FlowControlLargeBodies(t *testing.T) {
+	large := strings.Repeat("0123456789abcdef", 20000) // 320 KB, beyond the default windows
+	h := func(ctx context.Context, req types.Request) types.Response {
+		if req.BodyReader != nil {
+			data, err := io.ReadAll(req.BodyReader)
+			require.NoError(t, err)
+			return types.Response{Status: types.StatusCreated, Body: data}
+		}
+		return types.Response{Status: types.StatusOK, Body: []byte(large)}
+	}
+	addr := startServer(t, NewServer("").WithHandler(h).WithEncoders())
+	var dials int
+	client := h2cClient(&dials)
+
+	resp, err := client.Get("http://" + addr + "/download")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, large, string(body))
+
+	resp, err = client.Post("http://"+addr+"/upload", "text/plain", strings.NewReader(large))
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, len(large), len(body))
+}
+
+func TestHTTP2_RequestBodyStreamsToHandler(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		first := make([]byte, len("first"))
+		_, err := io.ReadFull(req.BodyReader, first)
+		require.NoError(t, err)
+		return types.Response{Status: types.StatusCreated, Body: first}
+	}
+	addr := startServer(t, NewServer("").WithHandler(h))
+	var dials int
+	client := h2cClient(&dials)
+
+	// The handler answers from the start of the body while the client is
+	// still sending the rest.
+	pr, pw := io.Pipe()
+	go pw.Write([]byte("first"))
+	resp, err := client.Post("http://"+addr+"/upload", "text/plain", pr)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	pw.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "first", string(body))
+}
+
+func TestHTTP2_StreamedAndCompressedResponse(t *testing.T) {
+	expected := strings.Repeat("event line\n", 2000)
+	h := func(ctx context.Context, req types.Request) types.Response {
+		return types.Response{
+			Status:     types.StatusOK,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			BodyReader: strings.NewReader(expected),
+		}
+	}
+	addr := startServer(t, NewServer("").WithHandler(h))
+	var dials int
+	client := h2cClient(&dials)
+
+	resp, err := client.Get("http://" + addr + "/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.True(t, resp.Uncompressed, "transport should have negotiated gzip and decoded it")
+	assert.Equal(t, expected, string(body))
+}
+
+func TestHTTP2_UpgradeFromHTTP11(t *testing.T) {
+	addr := startServer(t, NewServer("").WithHandler(echoHandler))
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var settings bytes.Buffer
+	fr := http2.NewFramer(&settings, nil)
+	require.NoError(t, fr.WriteSettings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: 1 << 20}))
+	settingsPayload := base64.RawURLEncoding.EncodeToString(settings.Bytes()[9:])
+
+	_, err = conn.Write([]byte("GET /upgraded HTTP/1.1\r\nHost: test.com\r\nUser-Agent: upgrader\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: " + settingsPayload + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	statusLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", statusLine)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	framer := http2.NewFramer(conn, reader)
+	require.NoError(t, framer.WriteSettings())
+
+	var status string
+	var body bytes.Buffer
+	dec := hpack.NewDecoder(4096, func(f hpack.HeaderField) {
+		if f.Name == ":status" {
+			status = f.Value
+		}
+	})
+	for done := false; !done; {
+		f, err := framer.ReadFrame()
+		require.NoError(t, err)
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				require.NoError(t, framer.WriteSettingsAck())
+			}
+		case *http2.HeadersFrame:
+			assert.Equal(t, uint32(1), f.StreamID)
+			_, err := dec.Write(f.HeaderBlockFragment())
+			require.NoError(t, err)
+			done = f.StreamEnded()
+		case *http2.DataFrame:
+			assert.Equal(t, uint32(1), f.StreamID)
+			body.Write(f.Data())
+			done = f.StreamEnded()
+		}
+	}
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "method=GET target=/upgraded ua=upgrader", body.String())
+}
+
+func TestHTTP2_PingAndProtocolErrors(t *testing.T) {
+	addr := startServer(t, NewServer("").WithHandler(echoHandler))
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	framer := http2.NewFramer(conn, conn)
+	require.NoError(t, framer.WriteSettings())
+	require.NoError(t, framer.WritePing(false, [8]byte{1, 2, 3, 4, 5, 6, 7, 8}))
+
+	for gotPing := false; !gotPing; {
+		f, err := framer.ReadFrame()
+		require.NoError(t, err)
+		if p, ok := f.(*http2.PingFrame); ok {
+			assert.True(t, p.IsAck())
+			assert.Equal(t, [8]byte{1, 2, 3, 4, 5, 6, 7, 8}, p.Data)
+			gotPing = true
+		}
+	}
+
+	// Even stream identifiers are reserved for the server.
+	var hbuf bytes.Buffer
+	enc := hpack.NewEncoder(&hbuf)
+	enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+	require.NoError(t, framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 2, BlockFragment: hbuf.Bytes(), EndStream: true, EndHeaders: true}))
+	for {
+		f, err := framer.ReadFrame()
+		require.NoError(t, err)
+		if g, ok := f.(*http2.GoAwayFrame); ok {
+			assert.Equal(t, http2.ErrCodeProtocol, g.ErrCode)
+			return
+		}
+	}
+}
+
+// dialH2 opens a prior-knowledge HTTP/2 connection to addr and returns it
+// with a framer on it and the server's SETTINGS.
+func dialH2(t *testing.T, addr string) (net.Conn, *http2.Framer, map[http2.SettingID]uint32) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	framer := http2.NewFramer(conn, conn)
+	require.NoError(t, framer.WriteSettings())
+	var settings map[http2.SettingID]uint32
+	for settings == nil {
+		f, err := framer.ReadFrame()
+		require.NoError(t, err)
+		if sf, ok := f.(*http2.SettingsFrame); ok && !sf.IsAck() {
+			settings = make(map[http2.SettingID]uint32)
+			sf.ForeachSetting(func(s http2.Setting) error {
+				settings[s.ID] = s.Val
+				return nil
+			})
+		}
+	}
+	// The server opens the connection window right after its settings.
+	f, err := framer.ReadFrame()
+	require.NoError(t, err)
+	update, ok := f.(*http2.WindowUpdateFrame)
+	require.True(t, ok, "expected WINDOW_UPDATE, got %v", f)
+	require.Equal(t, uint32(0), update.StreamID)
+	require.Equal(t, uint32(h2ConnRecvWindow-h2DefaultWindowSize), update.Increment)
+	return conn, framer, settings
+}
+
+// readGoAway reads frames until GOAWAY and returns its error code.
+func readGoAway(t *testing.T, framer *http2.Framer) http2.ErrCode {
+	t.Helper()
+	for {
+		f, err := framer.ReadFrame()
+		require.NoError(t, err)
+		if g, ok := f.(*http2.GoAwayFrame); ok {
+			return g.ErrCode
+		}
+	}
+}
+
+func TestHTTP2_HeaderListSizeLimit(t *testing.T) {
+	addr := startServer(t, NewServer("").WithHandler(echoHandler))
+
+	t.Run("advertised", func(t *testing.T) {
+		_, _, settings := dialH2(t, addr)
+		assert.Equal(t, uint32(h2MaxHeaderListSize), settings[http2.SettingMaxHeaderListSize])
+		assert.Equal(t, uint32(h2StreamRecvWindow), settings[http2.SettingInitialWindowSize])
+	})
+
+	t.Run("endless CONTINUATION", func(t *testing.T) {
+		_, framer, _ := dialH2(t, addr)
+		fragment := make([]byte, h2DefaultMaxFrameSize)
+		require.NoError(t, framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: fragment}))
+		// The last of these takes the block past the limit.
+		for range h2MaxHeaderListSize / len(fragment) {
+			require.NoError(t, framer.WriteContinuation(1, false, fragment))
+		}
+		assert.Equal(t, http2.ErrCodeEnhanceYourCalm, readGoAway(t, framer))
+	})
+
+	t.Run("decoded list", func(t *testing.T) {
+		// A small block can expand into a huge list by referring to the
+		// same dynamic table entry over and over.
+		var hbuf bytes.Buffer
+		enc := hpack.NewEncoder(&hbuf)
+		for _, f := range []hpack.HeaderField{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}} {
+			enc.WriteField(f)
+		}
+		big := hpack.HeaderField{Name: "x-big", Value: strings.Repeat("a", 4000)}
+		for range h2MaxHeaderListSize/int(big.Size()) + 1 {
+			enc.WriteField(big)
+		}
+		require.Less(t, hbuf.Len(), h2DefaultMaxFrameSize)
+
+		_, framer, _ := dialH2(t, addr)
+		require.NoError(t, framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: hbuf.Bytes(), EndStream: true, EndHeaders: true}))
+		assert.Equal(t, http2.ErrCodeEnhanceYourCalm, readGoAway(t, framer))
+	})
+}
+
+// writeRequestHead opens stream 1 with a POST whose body follows.
+func writeRequestHead(t *testing.T, framer *http2.Framer) {
+	t.Helper()
+	writeStreamHead(t, framer, 1)
+}
+
+func writeStreamHead(t *testing.T, framer *http2.Framer, streamID uint32) {
+	t.Helper()
+	var hbuf bytes.Buffer
+	enc := hpack.NewEncoder(&hbuf)
+	for _, f := range []hpack.HeaderField{{Name: ":method", Value: "POST"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}} {
+		enc.WriteField(f)
+	}
+	require.NoError(t, framer.WriteHeaders(http2.HeadersFrameParam{StreamID: streamID, BlockFragment: hbuf.Bytes(), EndHeaders: true}))
+}
+
+// writeBody sends n bytes on streamID in DATA frames of the default maximum
+// size, without ending the stream.
+func writeBody(t *testing.T, framer *http2.Framer, streamID uint32, n int) {
+	t.Helper()
+	chunk := make([]byte, h2DefaultMaxFrameSize)
+	for n > 0 {
+		size := min(n, len(chunk))
+		require.NoError(t, framer.WriteData(streamID, false, chunk[:size]))
+		n -= size
+	}
+}
+
+func TestHTTP2_ReceiveWindowReopensAsBodyIsRead(t *testing.T) {
+	release := make(chan struct{})
+	h := func(ctx context.Context, req types.Request) types.Response {
+		<-release
+		io.Copy(io.Discard, req.BodyReader)
+		return types.Response{Status: types.StatusOK}
+	}
+	addr := startServer(t, NewServer("").WithHandler(h))
+	conn, framer, _ := dialH2(t, addr)
+	// Together the streams fill more than half of the connection window.
+	for id := uint32(1); id <= 5; id += 2 {
+		writeStreamHead(t, framer, id)
+		writeBody(t, framer, id, h2StreamRecvWindow)
+	}
+
+	// Nothing is given back while the handler leaves the body unread.
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	for {
+		f, err := framer.ReadFrame()
+		if err != nil {
+			require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+			break
+		}
+		_, isUpdate := f.(*http2.WindowUpdateFrame)
+		require.False(t, isUpdate, "window reopened before the body was read")
+	}
+
+	close(release)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	updated := make(map[uint32]bool)
+	for !updated[0] || !updated[1] {
+		f, err := framer.ReadFrame()
+		require.NoError(t, err)
+		if u, ok := f.(*http2.WindowUpdateFrame); ok {
+			updated[u.StreamID] = true
+		}
+	}
+}
+
+func TestHTTP2_RapidReset(t *testing.T) {
+	t.Run("reset handlers still count", func(t *testing.T) {
+		release := make(chan struct{})
+		t.Cleanup(func() { close(release) })
+		h := func(ctx context.Context, req types.Request) types.Response {
+			<-release // ignores the reset, like a slow handler would
+			return types.Response{Status: types.StatusOK}
+		}
+		addr := startServer(t, NewServer("").WithHandler(h))
+		_, framer, _ := dialH2(t, addr)
+
+		id := uint32(1)
+		for range h2MaxConcurrentStreams {
+			writeStreamHead(t, framer, id)
+			require.NoError(t, framer.WriteRSTStream(id, http2.ErrCodeCancel))
+			id += 2
+		}
+		writeStreamHead(t, framer, id)
+		for {
+			f, err := framer.ReadFrame()
+			require.NoError(t, err)
+			if rst, ok := f.(*http2.RSTStreamFrame); ok {
+				assert.Equal(t, id, rst.StreamID)
+				assert.Equal(t, http2.ErrCodeRefusedStream, rst.ErrCode)
+				return
+			}
+		}
+	})
+	t.Run("too many resets", func(t *testing.T) {
+		h := func(ctx context.Context, req types.Request) types.Response {
+			<-ctx.Done()
+			return types.Response{Status: types.StatusOK}
+		}
+		addr := startServer(t, NewServer("").WithHandler(h))
+		_, framer, _ := dialH2(t, addr)
+
+		for i := range h2MaxResetsPerSecond + 1 {
+			id := uint32(2*i + 1)
+			writeStreamHead(t, framer, id)
+			require.NoError(t, framer.WriteRSTStream(id, http2.ErrCodeCancel))
+		}
+		assert.Equal(t, http2.ErrCodeEnhanceYourCalm, readGoAway(t, framer))
+	})
+}
+
+func TestHTTP2_Timeouts(t *testing.T) {
+	// assertClosed expects the server to close conn without writing to it.
+	assertClosed := func(t *testing.T, conn net.Conn) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := conn.Read(make([]byte, 1))
+		assert.Zero(t, n)
+		assert.ErrorIs(t, err, io.EOF)
+	}
+
+	t.Run("no preface after ALPN", func(t *testing.T) {
+		files := writeSelfSignedCert(t, t.TempDir(), "server", 1, "localhost")
+		s := NewServer("").WithTimeouts(100*time.Millisecond, 0)
+		addr, _ := startTLSServer(t, s, files.CertFile, files.KeyFile)
+		conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "localhost", InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+		require.NoError(t, err)
+		defer conn.Close()
+		require.Equal(t, "h2", conn.ConnectionState().NegotiatedProtocol)
+		assertClosed(t, conn)
+	})
+
+	t.Run("idle ALPN connection", func(t *testing.T) {
+		files := writeSelfSignedCert(t, t.TempDir(), "server", 1, "localhost")
+		s := NewServer("").WithTimeouts(0, 100*time.Millisecond)
+		addr, _ := startTLSServer(t, s, files.CertFile, files.KeyFile)
+		conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "localhost", InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte(http2.ClientPreface))
+		require.NoError(t, err)
+		framer := http2.NewFramer(conn, conn)
+		require.NoError(t, framer.WriteSettings())
+		assert.Equal(t, http2.ErrCodeNo, readGoAway(t, framer))
+	})
+
+	t.Run("idle prior knowledge connection", func(t *testing.T) {
+		h := func(ctx context.Context, req types.Request) types.Response {
+			// Outlasts the idle timeout, which must not run while the
+			// stream is open.
+			time.Sleep(300 * time.Millisecond)
+			return types.Response{Status: types.StatusOK}
+		}
+		addr := startServer(t, NewServer("").WithHandler(h).WithTimeouts(0, 100*time.Millisecond))
+		_, framer, _ := dialH2(t, addr)
+		writeRequestHead(t, framer)
+		require.NoError(t, framer.WriteData(1, true, nil))
+
+		var answered bool
+		for {
+			f, err := framer.ReadFrame()
+			require.NoError(t, err)
+			switch f := f.(type) {
+			case *http2.HeadersFrame:
+				answered = true
+			case *http2.GoAwayFrame:
+				assert.True(t, answered, "GOAWAY sent while a stream was open")
+				assert.Equal(t, http2.ErrCodeNo, f.ErrCode)
+				assert.Equal(t, uint32(1), f.LastStreamID)
+				return
+			}
+		}
+	})
+}
+
+func TestHTTP2_InitialWindowSizeOverflow(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		<-ctx.Done()
+		return types.Response{Status: types.StatusOK}
+	}
+	addr := startServer(t, NewServer("").WithHandler(h))
+	_, framer, _ := dialH2(t, addr)
+	writeRequestHead(t, framer)
+	// Open the stream's send window to the maximum, then grow it further.
+	require.NoError(t, framer.WriteWindowUpdate(1, h2MaxWindowSize-h2DefaultWindowSize))
+	require.NoError(t, framer.WriteSettings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: h2DefaultWindowSize + 1}))
+	assert.Equal(t, http2.ErrCodeFlowControl, readGoAway(t, framer))
+}
+
+func TestHTTP2_ReceiveWindowOverrun(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		<-ctx.Done()
+		return types.Response{Status: types.StatusOK}
+	}
+	addr := startServer(t, NewServer("").WithHandler(h))
+	_, framer, _ := dialH2(t, addr)
+
+	t.Run("stream", func(t *testing.T) {
+		writeRequestHead(t, framer)
+		writeBody(t, framer, 1, h2StreamRecvWindow+1)
+		for {
+			f, err := framer.ReadFrame()
+			require.NoError(t, err)
+			if rst, ok := f.(*http2.RSTStreamFrame); ok {
+				assert.Equal(t, uint32(1), rst.StreamID)
+				assert.Equal(t, http2.ErrCodeFlowControl, rst.ErrCode)
+				return
+			}
+		}
+	})
+	t.Run("connection", func(t *testing.T) {
+		// Bodies the handlers leave unread add up against the connection
+		// window, which is smaller than that of five streams.
+		for id := uint32(3); id <= 11; id += 2 {
+			writeStreamHead(t, framer, id)
+			writeBody(t, framer, id, h2StreamRecvWindow)
+		}
+		assert.Equal(t, http2.ErrCodeFlowControl, readGoAway(t, framer))
+	})
+}
+
+func TestHTTP2_RequestBodySizeLimit(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		_, err := io.ReadAll(req.BodyReader)
+		var he *types.HTTPError
+		require.ErrorAs(t, err, &he)
+		return types.Response{Status: he.Status}
+	}
+	addr := startServer(t, NewServer("").WithHandler(h).WithMaxRequestBodySize(10))
+	var dials int
+	client := h2cClient(&dials)
+
+	for name, body := range map[string]io.Reader{
+		"announced": strings.NewReader(strings.Repeat("a", 20)),
+		"streamed":  io.MultiReader(strings.NewReader(strings.Repeat("a", 20))),
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := client.Post("http://"+addr+"/upload", "text/plain", body)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+		})
+	}
+}
+
+func TestHTTP2_StreamsCannotBeHijacked(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		_, _, err := types.Hijack(ctx)
+		return types.Response{Status: types.StatusOK, Body: []byte(fmt.Sprint(err))}
+	}
+	addr := startServer(t, NewServer("").WithHandler(h))
+	var dials int
+	resp, err := h2cClient(&dials).Get("http://" + addr + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, types.ErrNotHijackable.Error(), string(body))
+}
+
+func TestHTTP2_ResponseTrailers(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		return types.Response{
+			Status:     types.StatusOK,
+			Headers:    map[string]string{"Trailer": "X-Checksum"},
+			BodyReader: strings.NewReader("streamed"),
+			Trailers:   func() map[string]string { return map[string]string{"X-Checksum": "8"} },
+		}
+	}
+	addr := startServer(t, NewServer("").WithHandler(h))
+	var dials int
+	resp, err := h2cClient(&dials).Get("http://" + addr + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(body))
+	assert.Equal(t, "8", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestHTTP2_EarlyHints(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		types.WriteInterim(ctx, types.StatusEarlyHints, map[string]string{"Link": "</app.js>; rel=preload; as=script"})
+		return types.Response{Status: types.StatusOK, Body: []byte("done")}
+	}
+	addr := startServer(t, NewServer("").WithHandler(h))
+
+	var hints []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			hints = append(hints, fmt.Sprintf("%d %s", code, header.Get("Link")))
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	var dials int
+	resp, err := h2cClient(&dials).Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"103 </app.js>; rel=preload; as=script"}, hints)
+}
+
+func TestHTTP2_HandlerPanicReturns500(t *testing.T) {
+	panics := make(chan any, 1)
+	h := func(ctx context.Context, req types.Request) types.Response {
+		panic("h2 boom")
+	}
+	s := NewServer("").WithHandler(h).WithPanicHook(func(req types.Request, recovered any, stack []byte) {
+		panics <- recovered
+	})
+	addr := startServer(t, s)
+	var dials int
+	resp, err := h2cClient(&dials).Get("http://" + addr + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, "h2 boom", <-panics)
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// AccessLogFormat selects how NewAccessLogHandler renders access log entries.
+type AccessLogFormat int
+
+const (
+	// CommonLogFormat is the NCSA common log format:
+	//   host - - [time] "request line" status bytes
+	CommonLogFormat AccessLogFormat = iota
+	// CombinedLogFormat extends CommonLogFormat with the referer and user
+	// agent.
+	CombinedLogFormat
+	// JSONLogFormat writes one JSON object per entry using slog's JSON
+	// handler.
+	JSONLogFormat
+)
+
+// Attribute keys of access log entries.
+const (
+	accessKeyRemoteAddr = "remote_addr"
+	accessKeyMethod     = "method"
+	accessKeyTarget     = "target"
+	accessKeyProto      = "proto"
+	accessKeyStatus     = "status"
+	accessKeyBytes      = "bytes"
+	accessKeyDuration   = "duration"
+	accessKeyUserAgent  = "user_agent"
+	accessKeyReferer    = "referer"
+	accessKeyRequestID  = "request_id"
+)
+
+// WithLogger sets the logger for the server's own diagnostics, such as
+// malformed requests, failed writes and recovered panics. The default is
+// slog.Default().
+func (s *Server) WithLogger(l *slog.Logger) *Server {
+	s.logger = l
+	return s
+}
+
+// WithAccessLog enables one log entry per request, with the method, target,
+// status, body bytes, duration, remote address and user agent as attributes.
+// Use NewAccessLogHandler for the Common, Combined or JSON formats.
+func (s *Server) WithAccessLog(l *slog.Logger) *Server {
+	s.accessLogger = l
+	return s
+}
+
+func (s Server) log() *slog.Logger {
+	if s.logger == nil {
+		return slog.Default()
+	}
+	return s.logger
+}
+
+// logAccess writes the access log entry for a served request.
+func (s Server) logAccess(ctx context.Context, remoteAddr net.Addr, req types.Request, status types.Status, bytes int64, start time.Time) {
+	if s.accessLogger == nil {
+		return
+	}
+	var addr string
+	if remoteAddr != nil {
+		addr = remoteAddr.String()
+	}
+	attrs := []slog.Attr{
+		slog.String(accessKeyRemoteAddr, addr),
+		slog.String(accessKeyMethod, string(req.Method)),
+		slog.String(accessKeyTarget, req.Target),
+		slog.String(accessKeyProto, req.Version),
+		slog.Int(accessKeyStatus, status.Code()),
+		slog.Int64(accessKeyBytes, bytes),
+		slog.Duration(accessKeyDuration, time.Since(start)),
+		slog.String(accessKeyUserAgent, req.Header("User-Agent")),
+		slog.String(accessKeyReferer, req.Header("Referer")),
+	}
+	if id := types.RequestID(ctx); id != "" {
+		attrs = append(attrs, slog.String(accessKeyRequestID, id))
+	}
+	s.accessLogger.LogAttrs(ctx, slog.LevelInfo, "request", attrs...)
+}
+
+// NewAccessLogHandler returns a slog.Handler that writes access log entries
+// to w in the given format.
+func NewAccessLogHandler(w io.Writer, format AccessLogFormat) slog.Handler {
+	if format == JSONLogFormat {
+		return slog.NewJSONHandler(w, nil)
+	}
+	return &clfHandler{w: w, mu: &sync.Mutex{}, combined: format == CombinedLogFormat}
+}
+
+// clfHandler renders access log records in the Common or Combined Log
+// Format. Attributes it does not know are ignored.
+type clfHandler struct {
+	w        io.Writer
+	mu       *sync.Mutex
+	combined bool
+	attrs    []slog.Attr
+}
+
+func (h *clfHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h *clfHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &h2
+}
+
+func (h *clfHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func (h *clfHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := make(map[string]string)
+	collect := func(a slog.Attr) bool {
+		fields[a.Key] = a.Value.Resolve().String()
+		return true
+	}
+	for _, a := range h.attrs {
+		collect(a)
+	}
+	r.Attrs(collect)
+
+	host := fields[accessKeyRemoteAddr]
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	bytes := fields[accessKeyBytes]
+	if bytes == "" || bytes == "0" {
+		bytes = "-"
+	}
+
+	var line strings.Builder
+	line.WriteString(clfField(host))
+	line.WriteString(" - - [")
+	line.WriteString(r.Time.Format("02/Jan/2006:15:04:05 -0700"))
+	line.WriteString("] ")
+	line.WriteString(strconv.Quote(strings.TrimSpace(fields[accessKeyMethod] + " " + fields[accessKeyTarget] + " " + fields[accessKeyProto])))
+	line.WriteString(" " + clfField(fields[accessKeyStatus]) + " " + bytes)
+	if h.combined {
+		line.WriteString(" " + strconv.Quote(clfField(fields[accessKeyReferer])))
+		line.WriteString(" " + strconv.Quote(clfField(fields[accessKeyUserAgent])))
+	}
+	line.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, line.String())
+	return err
+}
+
+func clfField(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func accessRecord(t *testing.T, attrs ...slog.Attr) slog.Record {
+	t.Helper()
+	at := time.Date(2024, time.March, 5, 14, 7, 9, 0, time.FixedZone("", -7*60*60))
+	r := slog.NewRecord(at, slog.LevelInfo, "request", 0)
+	r.AddAttrs(attrs...)
+	return r
+}
+
+func TestAccessLogHandler_Formats(t *testing.T) {
+	attrs := []slog.Attr{
+		slog.String(accessKeyRemoteAddr, "192.0.2.7:51234"),
+		slog.String(accessKeyMethod, "GET"),
+		slog.String(accessKeyTarget, "/echo/abc"),
+		slog.String(accessKeyProto, "HTTP/1.1"),
+		slog.Int(accessKeyStatus, 200),
+		slog.Int64(accessKeyBytes, 3),
+		slog.Duration(accessKeyDuration, time.Millisecond),
+		slog.String(accessKeyUserAgent, "curl/8.0"),
+		slog.String(accessKeyReferer, ""),
+	}
+	tests := []struct {
+		name   string
+		format AccessLogFormat
+		want   string
+	}{
+		{
+			name:   "common",
+			format: CommonLogFormat,
+			want:   `192.0.2.7 - - [05/Mar/2024:14:07:09 -0700] "GET /echo/abc HTTP/1.1" 200 3` + "\n",
+		},
+		{
+			name:   "combined",
+			format: CombinedLogFormat,
+			want:   `192.0.2.7 - - [05/Mar/2024:14:07:09 -0700] "GET /echo/abc HTTP/1.1" 200 3 "-" "curl/8.0"` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewAccessLogHandler(&buf, tt.format)
+			require.NoError(t, h.Handle(context.Background(), accessRecord(t, attrs...)))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestAccessLogHandler_EmptyBodyIsDash(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewAccessLogHandler(&buf, CommonLogFormat)
+	r := accessRecord(t,
+		slog.String(accessKeyRemoteAddr, "pipe"),
+		slog.String(accessKeyMethod, "HEAD"),
+		slog.String(accessKeyTarget, "/"),
+		slog.String(accessKeyProto, "HTTP/1.0"),
+		slog.Int(accessKeyStatus, 204),
+		slog.Int64(accessKeyBytes, 0),
+	)
+	require.NoError(t, h.Handle(context.Background(), r))
+	assert.Equal(t, `pipe - - [05/Mar/2024:14:07:09 -0700] "HEAD / HTTP/1.0" 204 -`+"\n", buf.String())
+}
+
+func TestHandleConnection_AccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	h := func(ctx context.Context, req types.Request) types.Response {
+		return types.Response{Status: types.StatusCreated, Body: []byte("created")}
+	}
+	s := (&Server{handler: h}).WithAccessLog(slog.New(NewAccessLogHandler(&buf, JSONLogFormat)))
+	clientConn, done := startConnection(t, s)
+
+	_, err := clientConn.Write([]byte("POST /files/a HTTP/1.1\r\nHost: test.com\r\nUser-Agent: tester\r\nReferer: /form\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	status, _, _, err := readResponse(clientConn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 201 Created", status)
+	<-done
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "request", entry["msg"])
+	assert.Equal(t, "POST", entry[accessKeyMethod])
+	assert.Equal(t, "/files/a", entry[accessKeyTarget])
+	assert.Equal(t, "HTTP/1.1", entry[accessKeyProto])
+	assert.Equal(t, float64(201), entry[accessKeyStatus])
+	assert.Equal(t, float64(len("created")), entry[accessKeyBytes])
+	assert.Equal(t, "tester", entry[accessKeyUserAgent])
+	assert.Equal(t, "/form", entry[accessKeyReferer])
+	assert.Contains(t, entry, accessKeyDuration)
+}
+
+func TestHandleConnection_LogsParseErrors(t *testing.T) {
+	var buf bytes.Buffer
+	s := (&Server{}).WithLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	clientConn, done := startConnection(t, s)
+
+	_, err := clientConn.Write([]byte("BROKEN\r\n\r\n"))
+	require.NoError(t, err)
+	status, _, _, err := readResponse(clientConn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+	<-done
+
+	assert.Contains(t, buf.String(), "level=WARN")
+	assert.Contains(t, buf.String(), `msg="failed to parse request"`)
+}
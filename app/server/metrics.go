@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/metrics"
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// DefaultMetricsPath is where metrics are commonly scraped from.
+const DefaultMetricsPath = "/metrics"
+
+// sizeBuckets are the bucket upper bounds of the request and response size
+// histograms, from 64 bytes to 16 MiB.
+var sizeBuckets = metrics.ExponentialBuckets(64, 4, 10)
+
+// serverMetrics are the collectors the server updates while serving.
+type serverMetrics struct {
+	path    string
+	handler types.Handler
+
+	requests     *metrics.CounterVec
+	duration     *metrics.HistogramVec
+	requestSize  *metrics.HistogramVec
+	responseSize *metrics.HistogramVec
+	activeConns  *metrics.Gauge
+	reused       *metrics.Counter
+	parseErrors  *metrics.Counter
+}
+
+// WithMetrics records request, connection and error metrics in reg and
+// serves reg on GET requests for path in the Prometheus text format, ahead
+// of the handler. reg may be nil, or a registry the application adds its own
+// metrics to. Requests are labelled with the pattern of the route that
+// matched, such as "/files/:path", or "" when none did.
+func (s *Server) WithMetrics(path string, reg *metrics.Registry) *Server {
+	if reg == nil {
+		reg = metrics.NewRegistry()
+	}
+	s.metrics = &serverMetrics{
+		path:    path,
+		handler: reg.Handler(),
+		requests: reg.NewCounterVec("http_requests_total",
+			"Requests served, by method, route pattern and status code.", "method", "route", "status"),
+		duration: reg.NewHistogramVec("http_request_duration_seconds",
+			"Time from parsing a request to finishing its response.", metrics.DefBuckets, "method", "route"),
+		requestSize: reg.NewHistogramVec("http_request_size_bytes",
+			"Request body bytes read by the handler, before content decoding.", sizeBuckets, "method", "route"),
+		responseSize: reg.NewHistogramVec("http_response_size_bytes",
+			"Size of response bodies as sent.", sizeBuckets, "method", "route"),
+		activeConns: reg.NewGauge("http_active_connections",
+			"Connections currently open."),
+		reused: reg.NewCounter("http_keepalive_reused_total",
+			"Requests served on a connection that had already served one."),
+		parseErrors: reg.NewCounter("http_request_parse_errors_total",
+			"Requests rejected because they could not be parsed."),
+	}
+	return s
+}
+
+// serves reports whether req is a scrape of the metrics endpoint.
+func (m *serverMetrics) serves(req types.Request) bool {
+	if m == nil || req.Method != types.Get {
+		return false
+	}
+	path, _, _ := strings.Cut(req.Target, "?")
+	return path == m.path
+}
+
+// knownMethods bounds the method label; anything else is counted as OTHER.
+var knownMethods = map[types.Method]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true,
+	"DELETE": true, "CONNECT": true, "OPTIONS": true, "TRACE": true,
+}
+
+func methodLabel(m types.Method) string {
+	if knownMethods[m] {
+		return string(m)
+	}
+	return "OTHER"
+}
+
+// observe records a finished request.
+func (m *serverMetrics) observe(ctx context.Context, req types.Request, status types.Status, requestBytes, responseBytes int64, start time.Time) {
+	if m == nil {
+		return
+	}
+	method, route := methodLabel(req.Method), types.Route(ctx)
+	m.requests.With(method, route, strconv.Itoa(status.Code())).Inc()
+	m.duration.With(method, route).Observe(time.Since(start).Seconds())
+	m.requestSize.With(method, route).Observe(float64(requestBytes))
+	m.responseSize.With(method, route).Observe(float64(responseBytes))
+}
+
+func (m *serverMetrics) connOpened() {
+	if m != nil {
+		m.activeConns.Inc()
+	}
+}
+
+func (m *serverMetrics) connClosed() {
+	if m != nil {
+		m.activeConns.Dec()
+	}
+}
+
+func (m *serverMetrics) connReused() {
+	if m != nil {
+		m.reused.Inc()
+	}
+}
+
+func (m *serverMetrics) parseError() {
+	if m != nil {
+		m.parseErrors.Inc()
+	}
+}
+
+// requestBodySize returns how many bytes of the request body the handler
+// read; body is nil for requests without one.
+func requestBodySize(body *requestBody) int64 {
+	if body == nil {
+		return 0
+	}
+	return body.bytesRead()
+}
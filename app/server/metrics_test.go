@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/metrics"
+	"github.com/codecrafters-io/http-server-starter-go/app/router"
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveOne sends request on a new connection and waits for the server to
+// finish with it, so that the request has been recorded.
+func serveOne(t *testing.T, s *Server, request string) (string, string) {
+	t.Helper()
+	clientConn, done := startConnection(t, s)
+	_, err := clientConn.Write([]byte(request))
+	require.NoError(t, err)
+	status, _, body, err := readResponse(clientConn)
+	require.NoError(t, err)
+	clientConn.Close()
+	<-done
+	return status, string(body)
+}
+
+func TestHandleConnection_Metrics(t *testing.T) {
+	r := router.New()
+	r.Register(types.Get, "/files/:path", func(ctx context.Context, req types.Request, res *types.Response) {
+		res.Body = []byte(req.Params["path"])
+	})
+	reg := metrics.NewRegistry()
+	s := (&Server{}).WithHandler(r.HandleRequest).WithMetrics("/internal/metrics", reg)
+
+	clientConn, done := startConnection(t, s)
+	for i, target := range []string{"/files/a.txt", "/files/b.txt"} {
+		connection := "keep-alive"
+		if i == 1 {
+			connection = "close"
+		}
+		_, err := clientConn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: test.com\r\nConnection: " + connection + "\r\n\r\n"))
+		require.NoError(t, err)
+		_, _, _, err = readResponse(clientConn)
+		require.NoError(t, err)
+	}
+	<-done
+	status, _ := serveOne(t, s, "GET /missing HTTP/1.1\r\nHost: test.com\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 404 Not Found", status)
+
+	clientConn, _ = startConnection(t, s)
+	_, err := clientConn.Write([]byte("GET /internal/metrics?debug=1 HTTP/1.1\r\nHost: test.com\r\n\r\n"))
+	require.NoError(t, err)
+	status, headers, body, err := readResponse(clientConn)
+	require.NoError(t, err)
+
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, metrics.ContentType, headers["Content-Type"])
+	text := string(body)
+	assert.Contains(t, text, `http_requests_total{method="GET",route="/files/:path",status="200"} 2`)
+	assert.Contains(t, text, `http_requests_total{method="GET",route="",status="404"} 1`)
+	assert.NotContains(t, text, "a.txt")
+	assert.Contains(t, text, `http_response_size_bytes_sum{method="GET",route="/files/:path"} 10`)
+	assert.Contains(t, text, `http_request_duration_seconds_count{method="GET",route="/files/:path"} 2`)
+	assert.Contains(t, text, "http_active_connections 1\n")
+	assert.Contains(t, text, "http_keepalive_reused_total 1\n")
+	assert.Contains(t, text, "http_request_parse_errors_total 0\n")
+}
+
+func TestHandleConnection_MetricsCountParseErrors(t *testing.T) {
+	s := (&Server{}).WithMetrics(DefaultMetricsPath, nil)
+
+	status, _ := serveOne(t, s, "NOT HTTP\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+	serveOne(t, s, "GET /metrics HTTP/1.1\r\nHost: test.com\r\n\r\n")
+
+	status, body := serveOne(t, s, "GET /metrics HTTP/1.1\r\nHost: test.com\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Contains(t, body, "http_request_parse_errors_total 1\n")
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/metrics",status="200"} 1`)
+}
+
+func TestHandleConnection_MetricsLimitMethodLabel(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		return types.Response{Status: types.StatusOK}
+	}
+	s := (&Server{handler: h}).WithMetrics(DefaultMetricsPath, nil)
+
+	serveOne(t, s, "BREW /pot HTTP/1.1\r\nHost: test.com\r\n\r\n")
+	_, body := serveOne(t, s, "GET /metrics HTTP/1.1\r\nHost: test.com\r\n\r\n")
+	assert.Contains(t, body, `http_requests_total{method="OTHER",route="",status="200"} 1`)
+	assert.NotContains(t, body, "BREW")
+}
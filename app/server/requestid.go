@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// maxRequestIDLength bounds the request IDs accepted from clients.
+const maxRequestIDLength = 128
+
+// WithRequestID gives every request an ID: the one in its X-Request-ID
+// header, or one made by generate when the header is missing or not a short
+// run of visible ASCII characters. A nil generate uses crypto/rand.Text. The
+// ID replaces the request's X-Request-ID header, is available to handlers
+// through types.RequestID, is echoed in the response unless the handler set
+// the header itself, and is logged with every entry about the request.
+func (s *Server) WithRequestID(generate func() string) *Server {
+	if generate == nil {
+		generate = rand.Text
+	}
+	s.requestID = generate
+	return s
+}
+
+// assignRequestID settles the ID of req and returns a context carrying it.
+func (s Server) assignRequestID(ctx context.Context, req *types.Request) context.Context {
+	if s.requestID == nil {
+		return ctx
+	}
+	id := req.Header(types.RequestIDHeader)
+	if !validRequestID(id) {
+		id = s.requestID()
+	}
+	for k := range req.Headers {
+		if strings.EqualFold(k, types.RequestIDHeader) {
+			delete(req.Headers, k)
+		}
+	}
+	req.Headers[types.RequestIDHeader] = id
+	return types.WithRequestID(ctx, id)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// echoRequestID adds the request ID in ctx to res.
+func echoRequestID(ctx context.Context, res *types.Response) {
+	id := types.RequestID(ctx)
+	if id == "" {
+		return
+	}
+	if res.Headers == nil {
+		res.Headers = make(map[string]string)
+	}
+	for k := range res.Headers {
+		if strings.EqualFold(k, types.RequestIDHeader) {
+			return
+		}
+	}
+	res.Headers[types.RequestIDHeader] = id
+}
+
+// requestLog returns the server logger, annotated with the ID of req when
+// the server assigns request IDs.
+func (s Server) requestLog(req types.Request) *slog.Logger {
+	if s.requestID == nil {
+		return s.log()
+	}
+	if id := req.Header(types.RequestIDHeader); id != "" {
+		return s.log().With("request_id", id)
+	}
+	return s.log()
+}
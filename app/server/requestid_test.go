@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleConnection_RequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		want     string
+	}{
+		{"generated when missing", "", "generated-id"},
+		{"taken from the request", "x-request-id: client-id-42\r\n", "client-id-42"},
+		{"replaced when too long", "X-Request-ID: " + strings.Repeat("a", maxRequestIDLength+1) + "\r\n", "generated-id"},
+		{"replaced when not visible ASCII", "X-Request-ID: caf\xc3\xa9\r\n", "generated-id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fromCtx, fromHeader string
+			h := func(ctx context.Context, req types.Request) types.Response {
+				fromCtx, fromHeader = types.RequestID(ctx), req.Header(types.RequestIDHeader)
+				return types.Response{Status: types.StatusOK}
+			}
+			s := (&Server{handler: h}).WithRequestID(func() string { return "generated-id" })
+
+			status, headers, _, err := runServerTest(t, s, "GET / HTTP/1.1\r\nHost: test.com\r\n"+tt.incoming+"\r\n")
+			require.NoError(t, err)
+			assert.Equal(t, "HTTP/1.1 200 OK", status)
+			assert.Equal(t, tt.want, headers[types.RequestIDHeader])
+			assert.Equal(t, tt.want, fromCtx)
+			assert.Equal(t, tt.want, fromHeader)
+		})
+	}
+}
+
+func TestHandleConnection_RequestIDKeepsHandlerHeader(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		return types.Response{Status: types.StatusOK, Headers: map[string]string{"X-Request-Id": "upstream"}}
+	}
+	s := (&Server{handler: h}).WithRequestID(nil)
+
+	_, headers, _, err := runServerTest(t, s, "GET / HTTP/1.1\r\nHost: test.com\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "upstream", headers["X-Request-Id"])
+	assert.NotContains(t, headers, types.RequestIDHeader)
+}
+
+func TestHandleConnection_RequestIDDisabledByDefault(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		assert.Empty(t, types.RequestID(ctx))
+		return types.Response{Status: types.StatusOK}
+	}
+	_, headers, _, err := runHandleConnectionTest(t, h, "GET / HTTP/1.1\r\nHost: test.com\r\n\r\n")
+	require.NoError(t, err)
+	assert.NotContains(t, headers, types.RequestIDHeader)
+}
+
+func TestHandleConnection_RequestIDInLogs(t *testing.T) {
+	var logs, access bytes.Buffer
+	h := func(ctx context.Context, req types.Request) types.Response {
+		panic("boom")
+	}
+	s := (&Server{handler: h}).
+		WithRequestID(nil).
+		WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))).
+		WithAccessLog(slog.New(NewAccessLogHandler(&access, JSONLogFormat)))
+
+	serveOne(t, s, "GET / HTTP/1.1\r\nHost: test.com\r\nX-Request-ID: trace-me\r\n\r\n")
+	serveOne(t, s, "BROKEN\r\n\r\n")
+
+	lines := strings.Split(strings.TrimSpace(logs.String()+access.String()), "\n")
+	require.Len(t, lines, 4, "panic and parse error, plus one access entry each")
+	var ids []string
+	for _, line := range lines {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		id, _ := entry["request_id"].(string)
+		require.NotEmpty(t, id, "log line without request_id: %s", line)
+		ids = append(ids, id)
+	}
+	assert.Equal(t, "trace-me", ids[0])
+	assert.Equal(t, "trace-me", ids[2])
+	assert.Equal(t, ids[1], ids[3], "the parse error is logged with a generated ID")
+}
+
+func TestHTTP2_RequestID(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		return types.Response{Status: types.StatusOK, Body: []byte(types.RequestID(ctx))}
+	}
+	addr := startServer(t, NewServer("").WithHandler(h).WithRequestID(nil))
+
+	req, err := http.NewRequest("GET", "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Request-ID", "h2-id")
+	var dials int
+	resp, err := h2cClient(&dials).Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "h2-id", resp.Header.Get("X-Request-ID"))
+}
@@ -3,32 +3,71 @@ package server
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"net/textproto"
+	"os"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/codecrafters-io/http-server-starter-go/app/tracing"
 	"github.com/codecrafters-io/http-server-starter-go/app/types"
 )
 
 // RequestHandler is a function that processes HTTP requests
 type RequestHandler func(ctx context.Context, req types.Request) types.Response
 
+// PanicHook is called with every panic recovered while serving a request,
+// for example to report it to an error tracker.
+type PanicHook func(req types.Request, recovered any, stack []byte)
+
 type Server struct {
-	addr    string
-	handler RequestHandler
+	addr               string
+	handler            RequestHandler
+	encoders           []Encoder
+	decoders           []Decoder
+	maxDecodedBodySize int64
+	tlsConfig          *tls.Config
+	tlsCertificates    []CertificateFiles
+	panicHook          PanicHook
+	logger             *slog.Logger
+	accessLogger       *slog.Logger
+	metrics            *serverMetrics
+	tracer             *tracing.Tracer
+	requestID          func() string
+	badRequest         types.Handler
+	internalError      types.Handler
+	readHeaderTimeout  time.Duration
+	idleTimeout        time.Duration
+	maxRequestBodySize int64
+	bodySizeLimit      func(req types.Request) int64
 }
 
+const (
+	// DefaultReadHeaderTimeout is how long a client has to send a request's
+	// line and headers unless configured otherwise.
+	DefaultReadHeaderTimeout = 10 * time.Second
+	// DefaultIdleTimeout is how long a kept-alive connection waits for the
+	// next request unless configured otherwise.
+	DefaultIdleTimeout = 2 * time.Minute
+	// DefaultMaxRequestBodySize is the request body size limit unless
+	// configured otherwise.
+	DefaultMaxRequestBodySize = 32 << 20
+)
+
 type Error error
 
 func NewServer(addr string) *Server {
 	return &Server{
-		addr: addr,
+		addr:     addr,
+		encoders: DefaultEncoders(),
 	}
 }
 
@@ -37,16 +76,117 @@ func (s *Server) WithHandler(h RequestHandler) *Server {
 	return s
 }
 
+// WithEncoders replaces the response encoders, listed in order of preference.
+// Calling it without arguments disables response compression.
+func (s *Server) WithEncoders(encoders ...Encoder) *Server {
+	s.encoders = append([]Encoder{}, encoders...)
+	return s
+}
+
+// WithRequestDecoders enables transparent decoding of request bodies sent
+// with the given content codings, as the handler reads them. Reading past
+// maxSize decoded bytes fails with a 413 *types.HTTPError and a body that
+// does not decode with a 400 one; a non-positive maxSize disables the limit.
+// Requests using any other coding are rejected with 415.
+func (s *Server) WithRequestDecoders(maxSize int64, decoders ...Decoder) *Server {
+	s.decoders = append([]Decoder{}, decoders...)
+	s.maxDecodedBodySize = maxSize
+	return s
+}
+
+// WithPanicHook sets a hook that receives handler panics after the server has
+// recovered and logged them.
+func (s *Server) WithPanicHook(hook PanicHook) *Server {
+	s.panicHook = hook
+	return s
+}
+
+// WithTimeouts sets how long a client may take to send the line and headers
+// of a request, including the TLS handshake for the first one, and how long
+// a kept-alive connection may sit idle waiting for the next request. On
+// HTTP/2 the first covers the client preface and the second applies while no
+// stream is open. Zero keeps the default and a negative duration disables the
+// timeout.
+func (s *Server) WithTimeouts(readHeader, idle time.Duration) *Server {
+	s.readHeaderTimeout = readHeader
+	s.idleTimeout = idle
+	return s
+}
+
+// WithMaxRequestBodySize limits request bodies, as sent on the wire, to n
+// bytes. HTTP/1.x requests whose Content-Length exceeds it are answered with
+// 413 Payload Too Large before the handler runs; otherwise reading a body
+// past it fails with a 413 *types.HTTPError. Zero keeps
+// DefaultMaxRequestBodySize and a negative n disables the limit.
+func (s *Server) WithMaxRequestBodySize(n int64) *Server {
+	s.maxRequestBodySize = n
+	return s
+}
+
+// WithRequestBodySizeLimit lets limit choose the body size limit of each
+// request from its head, for example to allow larger uploads on a single
+// route. A zero result keeps the limit set by WithMaxRequestBodySize and a
+// negative one disables the limit for that request.
+func (s *Server) WithRequestBodySizeLimit(limit func(req types.Request) int64) *Server {
+	s.bodySizeLimit = limit
+	return s
+}
+
+// maxBodySize returns the body size limit for req.
+func (s Server) maxBodySize(req types.Request) int64 {
+	if s.bodySizeLimit != nil {
+		if n := s.bodySizeLimit(req); n != 0 {
+			return n
+		}
+	}
+	if s.maxRequestBodySize == 0 {
+		return DefaultMaxRequestBodySize
+	}
+	return s.maxRequestBodySize
+}
+
+// readDeadline returns the deadline for a read that may take timeout, or
+// the zero time, meaning none, when it is negative. Zero means def.
+func readDeadline(timeout, def time.Duration) time.Time {
+	if timeout == 0 {
+		timeout = def
+	}
+	if timeout < 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+func (s Server) encoderList() []Encoder {
+	if s.encoders == nil {
+		// A Server not built by NewServer compresses bodies of any size.
+		encoders := DefaultEncoders()
+		for i := range encoders {
+			encoders[i].MinSize = 0
+		}
+		return encoders
+	}
+	return s.encoders
+}
+
 func (s Server) Listen() (net.Listener, Error) {
 	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		fmt.Println("Failed to start")
+		s.log().Error("failed to listen", "addr", s.addr, "err", err)
 		return nil, err
 	}
+	return l, s.serve(l)
+}
+
+// serve accepts connections on l until it is closed.
+func (s Server) serve(l net.Listener) Error {
 	for {
 		conn, err := l.Accept()
 		if err != nil {
-			fmt.Println("Error accepting connection: ", err.Error())
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			s.log().Error("failed to accept connection", "err", err)
 			continue
 		}
 		go s.handleConnection(conn)
@@ -54,28 +194,278 @@ func (s Server) Listen() (net.Listener, Error) {
 }
 
 func (s Server) handleConnection(conn net.Conn) {
-	defer conn.Close()
+	s.metrics.connOpened()
+	defer s.metrics.connClosed()
+
+	hijacked := false
+	var req types.Request
+	defer func() {
+		// Handler panics are turned into 500s by serveRequest; anything
+		// recovered here happened after the response started, so the
+		// connection is dropped.
+		if p := recover(); p != nil {
+			s.reportPanic(req, p, debug.Stack())
+		}
+		if !hijacked {
+			conn.Close()
+		}
+	}()
 
-	req, err := parseRequest(conn)
-	if err != nil {
-		fmt.Println("Failed to parse request:", err)
-		errorRes := prepareResponse(types.Request{})
-		errorRes.Status = types.StatusBadRequest
-		errorRes.Headers["Connection"] = "close"
-		respond(conn, types.Request{Headers: map[string]string{"Connection": "close"}}, errorRes)
+	conn.SetReadDeadline(readDeadline(s.readHeaderTimeout, DefaultReadHeaderTimeout))
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			s.log().Debug("TLS handshake failed", "remote_addr", conn.RemoteAddr().String(), "err", err)
+			return
+		}
+		if tlsConn.ConnectionState().NegotiatedProtocol == http2ALPN {
+			s.serveHTTP2(conn, bufio.NewReader(conn), nil)
+			return
+		}
+	}
+
+	reader := bufio.NewReader(conn)
+	if hasHTTP2Preface(reader) {
+		s.serveHTTP2(conn, reader, nil)
 		return
 	}
 
-	res := s.handler(context.Background(), req)
-	respond(conn, req, res)
+	for served := 0; ; served++ {
+		if served > 0 {
+			// Wait for the next request for up to the idle timeout, then
+			// give the client the usual time to send its headers.
+			conn.SetReadDeadline(readDeadline(s.idleTimeout, DefaultIdleTimeout))
+			if _, err := reader.Peek(1); err != nil {
+				return
+			}
+			conn.SetReadDeadline(readDeadline(s.readHeaderTimeout, DefaultReadHeaderTimeout))
+		}
+		var err error
+		req, err = parseRequest(reader, s.maxBodySize)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				s.log().Debug("timed out reading request", "remote_addr", conn.RemoteAddr().String())
+				return
+			}
+			start := time.Now()
+			ctx := s.assignRequestID(context.Background(), &req)
+			s.requestLog(req).Warn("failed to parse request", "remote_addr", conn.RemoteAddr().String(), "err", err)
+			s.metrics.parseError()
+			status, h := s.rejectedRequest(err)
+			errorRes := s.errorResponse(ctx, req, status, err, h)
+			errorRes.Headers["Connection"] = "close"
+			echoRequestID(ctx, &errorRes)
+			errorReq := types.Request{Headers: map[string]string{"Connection": "close"}}
+			if id := types.RequestID(ctx); id != "" {
+				errorReq.Headers[types.RequestIDHeader] = id
+			}
+			_, n := s.respond(conn, errorReq, errorRes)
+			s.logAccess(ctx, conn.RemoteAddr(), req, errorRes.Status, n, start)
+			return
+		}
+
+		conn.SetReadDeadline(time.Time{})
+
+		if _, isTLS := conn.(*tls.Conn); !isTLS && isH2CUpgrade(req) {
+			s.upgradeH2C(conn, reader, req)
+			return
+		}
+
+		if served > 0 {
+			s.metrics.connReused()
+		}
+		start := time.Now()
+		hj := &connHijacker{conn: conn, reader: reader}
+		ctx := types.WithRoute(types.WithHijacker(context.Background(), hj))
+		ctx = s.assignRequestID(ctx, &req)
+		ctx = s.startSpan(ctx, req, conn.RemoteAddr())
+		// HTTP/1.0 clients cannot be sent interim responses.
+		var interim *interimWriter
+		if req.Version == "HTTP/1.1" {
+			interim = &interimWriter{w: conn}
+			ctx = types.WithInterimWriter(ctx, interim)
+		}
+		var body *requestBody
+		if req.BodyReader != nil {
+			body = &requestBody{body: req.BodyReader}
+			if expectsContinue(req) {
+				body.interim = interim
+			}
+			req.BodyReader = body
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		res := s.serveRequest(ctx, req)
+		echoRequestID(ctx, &res)
+		if hijacked = hj.finish(); hijacked {
+			s.recordRequest(ctx, conn.RemoteAddr(), req, types.StatusSwitchingProtocols, requestBodySize(body), 0, start)
+			cancel()
+			return
+		}
+		continueSent := false
+		if interim != nil {
+			continueSent = interim.startResponse()
+		}
+		if body != nil && body.interim != nil && !continueSent {
+			// The handler answered without asking for the body, which the
+			// client may still send; only closing keeps the stream in sync.
+			req.Headers["Connection"] = "close"
+		}
+		persist, n := s.respond(conn, req, res)
+		s.recordRequest(ctx, conn.RemoteAddr(), req, res.Status, requestBodySize(body), n, start)
+		// The request is over, including when writing failed because the
+		// client disconnected; stop anything still producing its body.
+		cancel()
+		if !persist {
+			return
+		}
+		if body != nil && !body.drain(continueSent) {
+			return
+		}
+	}
+}
+
+// serveRequest runs the handler for a parsed request, answering directly when
+// the request carries an unsupported expectation or its body cannot be
+// decoded. A response the client accepts no content coding for, among those
+// that could encode it, is replaced by a 406. Handlers for methods other than
+// GET and HEAD, which may change state, are not run at all when no coding
+// the server offers would be acceptable.
+func (s Server) serveRequest(ctx context.Context, req types.Request) types.Response {
+	if expect := req.Header("Expect"); expect != "" && req.Version != "HTTP/1.0" && !strings.EqualFold(expect, "100-continue") {
+		return s.errorResponse(ctx, req, types.StatusExpectationFailed, fmt.Errorf("unsupported expectation %q", expect), nil)
+	}
+	if status, err := s.decodeRequestBody(&req); err != nil {
+		s.requestLog(req).Warn("failed to decode request body", "method", string(req.Method), "target", req.Target, "err", err)
+		h := s.badRequest
+		if status != types.StatusBadRequest {
+			h = nil
+		}
+		res := s.errorResponse(ctx, req, status, err, h)
+		if status == types.StatusUnsupportedMediaType {
+			res.Headers["Accept-Encoding"] = decoderNames(s.decoders)
+		}
+		return res
+	}
+	if req.Method != types.Get && req.Method != types.Head && !anyCodingAcceptable(s.encoderList(), req) {
+		return s.notAcceptable(ctx, req)
+	}
+	if err := bufferBody(&req); err != nil {
+		status, h := s.rejectedRequest(err)
+		return s.errorResponse(ctx, req, status, err, h)
+	}
+	res := s.callHandler(ctx, req)
+	if !codingAcceptable(s.encoderList(), req, res) {
+		res.DiscardBody()
+		return s.notAcceptable(ctx, req)
+	}
+	return res
+}
+
+func (s Server) notAcceptable(ctx context.Context, req types.Request) types.Response {
+	res := s.errorResponse(ctx, req, types.StatusNotAcceptable, errors.New("no acceptable content coding"), nil)
+	res.Headers["Vary"] = types.AddVary(res.Headers["Vary"], "Accept-Encoding")
+	return res
+}
+
+// maxBufferedBodySize is the largest request body read into Request.Body
+// before the handler runs.
+const maxBufferedBodySize = 64 << 10
+
+// bufferBody reads a request body with a Content-Length of at most
+// maxBufferedBodySize into req.Body, leaving BodyReader to read the same
+// bytes again. Bodies of unknown or larger size, and those the client only
+// sends after 100 Continue, are left to stream.
+func bufferBody(req *types.Request) error {
+	if req.BodyReader == nil || strings.EqualFold(req.Header("Expect"), "100-continue") {
+		return nil
+	}
+	n, err := strconv.ParseInt(req.Header("Content-Length"), 10, 64)
+	if err != nil || n < 0 || n > maxBufferedBodySize {
+		return nil
+	}
+	data, err := io.ReadAll(req.BodyReader)
+	if err != nil {
+		return fmt.Errorf("error reading request body: %w", err)
+	}
+	body := string(data)
+	req.Body = &body
+	req.BodyReader = strings.NewReader(body)
+	return nil
+}
+
+// callHandler runs the handler, answering through the internal error handler
+// if it panics. Scrapes of the metrics endpoint bypass the handler and are
+// answered by the metrics handler, which the same panic recovery covers.
+func (s Server) callHandler(ctx context.Context, req types.Request) (res types.Response) {
+	defer func() {
+		if p := recover(); p != nil {
+			stack := debug.Stack()
+			s.reportPanic(req, p, stack)
+			pe, ok := p.(*types.PanicError)
+			if !ok {
+				pe = &types.PanicError{Value: p, Stack: stack}
+			}
+			res = s.errorResponse(ctx, req, types.StatusInternalServerError, pe, s.internalError)
+		}
+	}()
+	if s.metrics.serves(req) {
+		types.SetRoute(ctx, s.metrics.path)
+		res = prepareResponse(req)
+		s.metrics.handler(ctx, req, &res)
+		return res
+	}
+	return s.handler(types.WithErrorMapper(ctx, s.mapError), req)
+}
+
+// recordRequest writes the access log entry and metrics of a finished
+// request and ends its span.
+func (s Server) recordRequest(ctx context.Context, remoteAddr net.Addr, req types.Request, status types.Status, requestBytes, responseBytes int64, start time.Time) {
+	s.logAccess(ctx, remoteAddr, req, status, responseBytes, start)
+	s.metrics.observe(ctx, req, status, requestBytes, responseBytes, start)
+	endSpan(ctx, req, status, responseBytes)
+}
+
+// reportPanic logs a recovered panic and passes it to the panic hook.
+// Panics forwarded from other goroutines as *types.PanicError keep their
+// original stack.
+func (s Server) reportPanic(req types.Request, recovered any, stack []byte) {
+	if pe, ok := recovered.(*types.PanicError); ok {
+		recovered, stack = pe.Value, pe.Stack
+	}
+	s.requestLog(req).Error("recovered from panic",
+		"method", string(req.Method),
+		"target", req.Target,
+		"host", req.Header("Host"),
+		"user_agent", req.Header("User-Agent"),
+		"panic", fmt.Sprint(recovered),
+		"stack", string(stack))
+	if s.panicHook != nil {
+		s.panicHook(req, recovered, stack)
+	}
+}
+
+// reportStreamPanic reports a panic that ended a streamed body.
+func (s Server) reportStreamPanic(req types.Request, err error) {
+	var pe *types.PanicError
+	if errors.As(err, &pe) {
+		s.reportPanic(req, pe, nil)
+	}
 }
 
-func parseRequest(conn net.Conn) (types.Request, Error) {
+// parseRequest reads the head of the next request from reader, leaving its
+// body to be read through the request's BodyReader. A Content-Length above
+// the limit maxBodySize returns for the request, unless it is negative, fails
+// with a 413 *types.HTTPError, as does reading a chunked body past it, and
+// unsupported transfer codings fail with a 501 one; other errors mean the
+// request is malformed.
+func parseRequest(reader *bufio.Reader, maxBodySize func(types.Request) int64) (types.Request, Error) {
 	result := types.Request{
 		Headers: make(map[string]string),
 		Body:    nil,
 	}
-	reader := bufio.NewReader(conn)
 
 	requestLineBytes, err := reader.ReadBytes('\n')
 	if err != nil {
@@ -94,7 +484,7 @@ func parseRequest(conn net.Conn) (types.Request, Error) {
 	result.Target = string(requestLineParts[1])
 	result.Version = string(requestLineParts[2])
 
-	if result.Version != "HTTP/1.1" {
+	if result.Version != "HTTP/1.1" && result.Version != "HTTP/1.0" {
 		return result, fmt.Errorf("unsupported HTTP version: %q", result.Version)
 	}
 
@@ -112,35 +502,89 @@ func parseRequest(conn net.Conn) (types.Request, Error) {
 
 		headerParts := bytes.SplitN(headerLineBytes, []byte(":"), 2)
 		if len(headerParts) != 2 {
-			fmt.Printf("Warning: Skipping malformed header line: %q\n", string(headerLineBytes))
+			// Malformed header lines are skipped.
 			continue
 		}
 
-		key := strings.TrimSpace(string(headerParts[0]))
+		// Names are canonicalised so that differently cased copies of a
+		// field cannot be read one way here and another way downstream.
+		key := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(string(headerParts[0])))
 		value := strings.TrimSpace(string(headerParts[1]))
 
+		if prev, ok := result.Headers[key]; ok && key == "Content-Length" && prev != value {
+			return result, errors.New("conflicting Content-Length headers")
+		}
 		result.Headers[key] = value
 	}
 
-	if result.Method == "POST" {
-		if contentLengthStr, ok := result.Headers["Content-Length"]; ok {
-			contentLength, err := strconv.Atoi(contentLengthStr)
-			if err != nil {
-				return result, fmt.Errorf("invalid Content-Length: %w", err)
-			}
-			bodyBytes := make([]byte, contentLength)
-			_, err = io.ReadFull(reader, bodyBytes)
-			if err != nil {
-				return result, fmt.Errorf("error reading request body: %w", err)
-			}
-			bodyStr := string(bodyBytes)
-			result.Body = &bodyStr
-		}
+	// HTTP/1.0 predates the Host header, so only HTTP/1.1 requires it.
+	if result.Version == "HTTP/1.1" && !hasHeader(result.Headers, "Host") {
+		return result, errors.New("missing Host header")
 	}
 
+	limit := maxBodySize(result)
+	var body io.Reader
+	if te, ok := result.Headers["Transfer-Encoding"]; ok {
+		// A message with both may be framed differently by an intermediary,
+		// so it is rejected rather than resolved (RFC 9112 §6.3).
+		if _, ok := result.Headers["Content-Length"]; ok {
+			return result, errors.New("both Transfer-Encoding and Content-Length present")
+		}
+		if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			return result, types.NewHTTPError(types.StatusNotImplemented, fmt.Sprintf("unsupported Transfer-Encoding %q", te))
+		}
+		cr := newChunkedReader(reader)
+		result.Trailers = cr.trailers
+		body = cr
+	} else if contentLengthStr, ok := result.Headers["Content-Length"]; ok {
+		contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64)
+		if err != nil || contentLength < 0 {
+			return result, fmt.Errorf("invalid Content-Length: %q", contentLengthStr)
+		}
+		if limit >= 0 && contentLength > limit {
+			return result, bodyTooLarge(limit)
+		}
+		body = &lengthReader{r: reader, remaining: contentLength}
+	}
+	if body == nil {
+		return result, nil
+	}
+	if limit >= 0 {
+		body = types.LimitReader(body, limit, bodyTooLarge(limit))
+	}
+	// The body is left on the connection for the handler to read.
+	result.BodyReader = body
 	return result, nil
 }
 
+func bodyTooLarge(limit int64) error {
+	return types.NewHTTPError(types.StatusPayloadTooLarge, fmt.Sprintf("request body exceeds %d bytes", limit))
+}
+
+// expectsContinue reports whether the client waits for 100 Continue before
+// sending the body. HTTP/1.0 clients cannot be sent interim responses.
+func expectsContinue(req types.Request) bool {
+	return req.Version == "HTTP/1.1" && strings.EqualFold(req.Header("Expect"), "100-continue")
+}
+
+func hasHeader(headers map[string]string, name string) bool {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteHeader removes every field named name, whatever its case.
+func deleteHeader(headers map[string]string, name string) {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			delete(headers, k)
+		}
+	}
+}
+
 func prepareResponse(r types.Request) types.Response {
 	return types.Response{
 		Status:     types.StatusOK,
@@ -150,141 +594,199 @@ func prepareResponse(r types.Request) types.Response {
 	}
 }
 
-func respond(conn net.Conn, req types.Request, r types.Response) {
+// encodeBody applies the negotiated content coding to r and sets its
+// Content-Length when the body size is known. It returns the bytes to send
+// for r.Body, or the encoder to wrap around r.BodyReader.
+func (s Server) encodeBody(req types.Request, r *types.Response) ([]byte, *Encoder) {
+	if r.Status == types.StatusNoContent {
+		// A 204 has neither a body nor a Content-Length.
+		delete(r.Headers, "Content-Length")
+		return nil, nil
+	}
+	bodyToWrite := r.Body
+	_, alreadyEncoded := r.Headers["Content-Encoding"]
+
+	if r.BodyReader != nil {
+		if alreadyEncoded {
+			sizeStream(r)
+			return nil, nil
+		}
+		r.Headers["Vary"] = types.AddVary(r.Headers["Vary"], "Accept-Encoding")
+		if enc, ok := selectEncoder(s.encoderList(), req, r.Headers["Content-Type"], streamSize(*r)); ok {
+			delete(r.Headers, "Content-Length")
+			r.Headers["Content-Encoding"] = enc.Name
+			return nil, &enc
+		}
+		sizeStream(r)
+		return nil, nil
+	}
+
+	if _, ok := r.Headers["Content-Length"]; !ok && r.Body != nil {
+		r.Headers["Content-Length"] = strconv.Itoa(len(r.Body))
+	} else if !ok && r.Body == nil {
+		r.Headers["Content-Length"] = "0"
+	}
+
+	if r.Body != nil && !alreadyEncoded {
+		r.Headers["Vary"] = types.AddVary(r.Headers["Vary"], "Accept-Encoding")
+		if enc, ok := selectEncoder(s.encoderList(), req, r.Headers["Content-Type"], len(r.Body)); ok {
+			if encoded, err := compress(enc, r.Body); err == nil {
+				bodyToWrite = encoded
+				r.Headers["Content-Encoding"] = enc.Name
+				r.Headers["Content-Length"] = strconv.Itoa(len(bodyToWrite))
+			} else {
+				s.requestLog(req).Error("failed to compress response body", "encoding", enc.Name, "err", err)
+			}
+		}
+	}
+	return bodyToWrite, nil
+}
+
+// streamSize returns the length a handler declared for a streamed body, or
+// -1 if it did not give a valid one.
+func streamSize(r types.Response) int {
+	n, err := strconv.Atoi(r.Headers["Content-Length"])
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// sizeStream keeps the Content-Length a handler gave a streamed body, cutting
+// the stream off at that length, and drops the header when it is not a valid
+// length.
+func sizeStream(r *types.Response) {
+	n, err := strconv.ParseInt(r.Headers["Content-Length"], 10, 64)
+	if err != nil || n < 0 {
+		delete(r.Headers, "Content-Length")
+		return
+	}
+	r.BodyReader = &sizedBody{lengthReader: lengthReader{r: r.BodyReader, remaining: n}, src: r.BodyReader}
+}
+
+// sizedBody is a streamed response body of a declared length. Like the body
+// it wraps, it is closed once sent.
+type sizedBody struct {
+	lengthReader
+	src io.Reader
+}
+
+func (b *sizedBody) Close() error {
+	if c, ok := b.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// keepAlive reports whether the client wants the connection kept open after
+// req: HTTP/1.1 connections persist unless the client sends Connection: close,
+// HTTP/1.0 ones only when it asks for keep-alive.
+func keepAlive(req types.Request) bool {
+	connection := req.Header("Connection")
+	if headerHasToken(connection, "close") {
+		return false
+	}
+	if req.Version == "HTTP/1.0" {
+		return headerHasToken(connection, "keep-alive")
+	}
+	return true
+}
+
+// respond writes r to conn and reports whether the connection can be reused
+// for another request, along with the number of body bytes written.
+func (s Server) respond(conn net.Conn, req types.Request, r types.Response) (bool, int64) {
 	crlf := []byte("\r\n")
 
 	if r.Headers == nil {
 		r.Headers = make(map[string]string)
 	}
 
-	rspMap := map[types.Status]string{
-		types.StatusOK:                  "HTTP/1.1 200 OK",
-		types.StatusNotFound:            "HTTP/1.1 404 Not Found",
-		types.StatusBadRequest:          "HTTP/1.1 400 Bad Request",
-		types.StatusInternalServerError: "HTTP/1.1 500 Internal Server Error",
-		types.StatusCreated:             "HTTP/1.1 201 Created",
+	version := "HTTP/1.1"
+	if req.Version == "HTTP/1.0" {
+		version = req.Version
 	}
 
+	var trailers []string
+	if r.Trailers != nil && version == "HTTP/1.1" {
+		// Trailers can only follow a chunked body.
+		trailers = declaredTrailers(r.Headers)
+		if r.BodyReader == nil {
+			r.BodyReader = bytes.NewReader(r.Body)
+			r.Body = nil
+		}
+	}
+
+	bodyToWrite, streamEncoder := s.encodeBody(req, &r)
+	isStreamed := r.BodyReader != nil
+	_, isSized := r.Headers["Content-Length"]
+	// HTTP/1.0 has no chunked coding, so streamed bodies of unknown length
+	// are delimited by closing the connection.
+	isChunked := isStreamed && version == "HTTP/1.1" && (!isSized || len(trailers) > 0)
+	isErrorStatus := r.Status.Code() >= 400
+	persist := keepAlive(req) && !isErrorStatus && (!isStreamed || isChunked || isSized)
+
 	connectionHeader := "keep-alive"
-	isErrorStatus := r.Status == types.StatusBadRequest || r.Status == types.StatusNotFound || r.Status == types.StatusInternalServerError
-	if req.Headers["Connection"] == "close" || isErrorStatus {
+	if !persist {
 		connectionHeader = "close"
 	}
 	r.Headers["Connection"] = connectionHeader
 
-	isChunked := r.BodyReader != nil
-	var bodyToWrite []byte = r.Body
-
-	if !isChunked {
-		if _, ok := r.Headers["Content-Length"]; !ok && r.Body != nil {
-			r.Headers["Content-Length"] = strconv.Itoa(len(r.Body))
-		} else if !ok && r.Body == nil {
-			r.Headers["Content-Length"] = "0"
-		}
-
-		canUseGzip := false
-		if acceptEncoding, ok := req.Headers["Accept-Encoding"]; ok {
-			if strings.Contains(acceptEncoding, "gzip") {
-				canUseGzip = true
-			}
-		}
-
-		if canUseGzip && r.Body != nil {
-			var buf bytes.Buffer
-			gz := gzip.NewWriter(&buf)
-			if _, err := gz.Write(r.Body); err == nil {
-				if err := gz.Close(); err == nil {
-					bodyToWrite = buf.Bytes()
-					r.Headers["Content-Encoding"] = "gzip"
-					r.Headers["Content-Length"] = strconv.Itoa(len(bodyToWrite))
-				} else {
-					fmt.Println("Error closing gzip writer:", err)
-				}
-			} else {
-				fmt.Println("Error writing to gzip writer:", err)
-			}
-		}
-	} else {
-		r.Headers["Transfer-Encoding"] = "chunked"
+	if isChunked {
 		delete(r.Headers, "Content-Length")
+		r.Headers["Transfer-Encoding"] = "chunked"
+	}
+	if len(trailers) > 0 {
+		r.Headers["Trailer"] = strings.Join(trailers, ", ")
+	} else {
+		delete(r.Headers, "Trailer")
 	}
 
-	statusLine := rspMap[r.Status]
+	statusLine := fmt.Sprintf("%s %d %s", version, r.Status.Code(), r.Status.Reason())
 	if _, err := conn.Write([]byte(statusLine)); err != nil {
-		fmt.Println("Error writing status line:", err)
-		return
+		s.requestLog(req).Debug("failed to write status line", "err", err)
+		return false, 0
 	}
 	if _, err := conn.Write(crlf); err != nil {
-		fmt.Println("Error writing CRLF after status line:", err)
-		return
+		s.requestLog(req).Debug("failed to write status line", "err", err)
+		return false, 0
 	}
 
 	for k, v := range r.Headers {
 		headerLine := fmt.Sprintf("%s: %s", k, v)
 		if _, err := conn.Write([]byte(headerLine)); err != nil {
-			fmt.Println("Error writing header:", k, v, err)
-			return
+			s.requestLog(req).Debug("failed to write header", "header", k, "err", err)
+			return false, 0
 		}
 		if _, err := conn.Write(crlf); err != nil {
-			fmt.Println("Error writing CRLF after header:", k, v, err)
-			return
+			s.requestLog(req).Debug("failed to write header", "header", k, "err", err)
+			return false, 0
 		}
 	}
 
 	if _, err := conn.Write(crlf); err != nil {
-		fmt.Println("Error writing CRLF after headers:", err)
-		return
+		s.requestLog(req).Debug("failed to write end of headers", "err", err)
+		return false, 0
 	}
 
+	body := &countingWriter{w: conn}
 	if isChunked {
-		buf := make([]byte, 4*1024)
-		for {
-			n, err := r.BodyReader.Read(buf)
-			if n > 0 {
-				chunkSizeHex := []byte(strconv.FormatInt(int64(n), 16))
-				if _, wErr := conn.Write(chunkSizeHex); wErr != nil {
-					fmt.Println("Error writing chunk size:", wErr)
-					return
-				}
-				if _, wErr := conn.Write(crlf); wErr != nil {
-					fmt.Println("Error writing CRLF after chunk size:", wErr)
-					return
-				}
-
-				if _, wErr := conn.Write(buf[:n]); wErr != nil {
-					fmt.Println("Error writing chunk data:", wErr)
-					return
-				}
-				if _, wErr := conn.Write(crlf); wErr != nil {
-					fmt.Println("Error writing CRLF after chunk data:", wErr)
-					return
-				}
-			}
-
-			if err != nil {
-				if err == io.EOF {
-					if _, wErr := conn.Write([]byte("0")); wErr != nil {
-						fmt.Println("Error writing zero chunk size:", wErr)
-						return
-					}
-					if _, wErr := conn.Write(crlf); wErr != nil {
-						fmt.Println("Error writing CRLF after zero chunk size:", wErr)
-						return
-					}
-					if _, wErr := conn.Write(crlf); wErr != nil {
-						fmt.Println("Error writing final CRLF for chunked:", wErr)
-						return
-					}
-				} else {
-					fmt.Println("Error reading from body reader:", err)
-				}
-				break
-			}
+		fields := func() []trailerField { return trailerFields(r, trailers) }
+		if err := streamChunked(body, r.BodyReader, streamEncoder, fields); err != nil {
+			s.requestLog(req).Debug("failed to stream chunked body", "err", err)
+			s.reportStreamPanic(req, err)
+			return false, body.n
+		}
+	} else if isStreamed {
+		if err := streamBody(body, r.BodyReader, streamEncoder); err != nil {
+			s.requestLog(req).Debug("failed to stream body", "err", err)
+			s.reportStreamPanic(req, err)
+			return false, body.n
 		}
 	} else if bodyToWrite != nil {
-		if _, err := conn.Write(bodyToWrite); err != nil {
-			fmt.Println("Error writing non-chunked body:", err)
-			return
+		if _, err := body.Write(bodyToWrite); err != nil {
+			s.requestLog(req).Debug("failed to write body", "err", err)
+			return false, body.n
 		}
 	}
+	return persist, body.n
 }
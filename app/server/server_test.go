@@ -4,15 +4,25 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/codecrafters-io/http-server-starter-go/app/websocket"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -127,10 +137,9 @@ func TestWithHandler(t *testing.T) {
 	require.NotNil(t, s.handler)
 }
 
-func runHandleConnectionTest(t *testing.T, handler RequestHandler, request string) (string, map[string]string, []byte, error) {
+func runServerTest(t *testing.T, s *Server, request string) (string, map[string]string, []byte, error) {
 	t.Helper()
 	serverConn, clientConn := net.Pipe()
-	s := &Server{handler: handler} // Create a minimal server with just the handler
 
 	go func() {
 		defer serverConn.Close()
@@ -147,6 +156,11 @@ func runHandleConnectionTest(t *testing.T, handler RequestHandler, request strin
 	return status, headers, body, readErr
 }
 
+func runHandleConnectionTest(t *testing.T, handler RequestHandler, request string) (string, map[string]string, []byte, error) {
+	t.Helper()
+	return runServerTest(t, &Server{handler: handler}, request) // Create a minimal server with just the handler
+}
+
 func TestHandleConnection_ValidGET(t *testing.T) {
 	expectedBody := "Hello GET"
 	h := mockHandler(types.Response{
@@ -314,3 +328,1453 @@ func TestHandleConnection_ServerErrorForcesClose(t *testing.T) {
 	assert.Equal(t, "HTTP/1.1 404 Not Found", status)
 	assert.Equal(t, "close", headers["Connection"])
 }
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		present  bool
+		wantName string
+		wantOK   bool
+	}{
+		{"no header", "", false, "identity", true},
+		{"empty header", "", true, "identity", true},
+		{"plain gzip", "gzip", true, "gzip", true},
+		{"gzip disabled by q=0", "gzip;q=0", true, "identity", true},
+		{"gzip with spaces and q", "deflate, gzip ; q=0.5", true, "gzip", true},
+		{"identity preferred", "gzip;q=0.2, identity;q=0.8", true, "identity", true},
+		{"wildcard", "*", true, "gzip", true},
+		{"wildcard excluded but gzip allowed", "*;q=0, gzip", true, "gzip", true},
+		{"identity excluded", "identity;q=0", true, "", false},
+		{"wildcard excludes identity", "*;q=0", true, "", false},
+		{"unsupported only", "br, identity;q=0", true, "", false},
+		{"unsupported with identity fallback", "br", true, "identity", true},
+		{"case insensitive", "GZIP", true, "gzip", true},
+		{"invalid q ignored", "gzip;q=abc", true, "identity", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := negotiateEncoding(tt.header, tt.present, []string{"gzip"})
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantName, got)
+		})
+	}
+}
+
+func TestHandleConnection_GzipDisabledByQValue(t *testing.T) {
+	h := mockHandler(types.Response{
+		Status:  types.StatusOK,
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Body:    []byte("plain body"),
+	})
+	request := "GET / HTTP/1.1\r\nHost: test.com\r\nAccept-Encoding: gzip;q=0\r\n\r\n"
+
+	status, headers, body, err := runHandleConnectionTest(t, h, request)
+	require.NoError(t, err)
+
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.NotContains(t, headers, "Content-Encoding")
+	assert.Equal(t, "Accept-Encoding", headers["Vary"])
+	assert.Equal(t, "plain body", string(body))
+}
+
+func TestHandleConnection_VaryMergedWithHandlerVary(t *testing.T) {
+	h := mockHandler(types.Response{
+		Status:  types.StatusOK,
+		Headers: map[string]string{"Vary": "Accept-Language"},
+		Body:    []byte("hello"),
+	})
+	request := "GET / HTTP/1.1\r\nHost: test.com\r\nAccept-Encoding: gzip\r\n\r\n"
+
+	_, headers, _, err := runHandleConnectionTest(t, h, request)
+	require.NoError(t, err)
+
+	assert.Equal(t, "gzip", headers["Content-Encoding"])
+	assert.Equal(t, "Accept-Language, Accept-Encoding", headers["Vary"])
+}
+
+func TestHandleConnection_NotAcceptable(t *testing.T) {
+	h := mockHandler(types.Response{Status: types.StatusOK, Headers: map[string]string{}, Body: []byte("hello")})
+	request := "GET / HTTP/1.1\r\nHost: test.com\r\nAccept-Encoding: compress, identity;q=0\r\n\r\n"
+
+	status, headers, _, err := runHandleConnectionTest(t, h, request)
+	require.NoError(t, err)
+
+	assert.Equal(t, "HTTP/1.1 406 Not Acceptable", status)
+	assert.Equal(t, "Accept, Accept-Encoding", headers["Vary"])
+	assert.Equal(t, "close", headers["Connection"])
+}
+
+func TestHandleConnection_NotAcceptableBeforeUnsafeHandler(t *testing.T) {
+	for method, wantCalled := range map[string]bool{"GET": true, "POST": false, "DELETE": false} {
+		t.Run(method, func(t *testing.T) {
+			called := false
+			h := func(ctx context.Context, req types.Request) types.Response {
+				called = true
+				return types.Response{Status: types.StatusOK, Body: []byte("changed")}
+			}
+			request := method + " / HTTP/1.1\r\nHost: test.com\r\nContent-Length: 0\r\nAccept-Encoding: compress, identity;q=0\r\n\r\n"
+
+			status, headers, _, err := runHandleConnectionTest(t, h, request)
+			require.NoError(t, err)
+			assert.Equal(t, "HTTP/1.1 406 Not Acceptable", status)
+			assert.Contains(t, headers["Vary"], "Accept-Encoding")
+			assert.Equal(t, wantCalled, called)
+		})
+	}
+}
+
+func TestHandleConnection_NotAcceptableCountsEligibleEncodersOnly(t *testing.T) {
+	htmlOnly := GzipEncoder(gzip.BestSpeed)
+	htmlOnly.ContentTypes = []string{"text/html"}
+	s := NewServer("").WithEncoders(htmlOnly)
+	for contentType, want := range map[string]string{
+		"text/html":  "HTTP/1.1 200 OK",
+		"image/png":  "HTTP/1.1 406 Not Acceptable",
+		"text/plain": "HTTP/1.1 406 Not Acceptable",
+	} {
+		s.WithHandler(mockHandler(types.Response{
+			Status:  types.StatusOK,
+			Headers: map[string]string{"Content-Type": contentType},
+			Body:    []byte("hello"),
+		}))
+		request := "GET / HTTP/1.1\r\nHost: test.com\r\nAccept-Encoding: gzip, identity;q=0\r\n\r\n"
+		status, _, _, err := runServerTest(t, s, request)
+		require.NoError(t, err)
+		assert.Equal(t, want, status, contentType)
+	}
+}
+
+func TestHandleConnection_EncoderSelection(t *testing.T) {
+	originalBody := strings.Repeat("compress me please ", 80)
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+		"br":      func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	}
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"gzip, deflate, br, zstd", "br"},
+		{"gzip, deflate", "gzip"},
+		{"deflate", "deflate"},
+		{"zstd, br;q=0.5", "zstd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			h := mockHandler(types.Response{
+				Status:  types.StatusOK,
+				Headers: map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+				Body:    []byte(originalBody),
+			})
+			request := "GET / HTTP/1.1\r\nHost: test.com\r\nAccept-Encoding: " + tt.acceptEncoding + "\r\n\r\n"
+
+			_, headers, body, err := runServerTest(t, NewServer("").WithHandler(h), request)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, headers["Content-Encoding"])
+
+			r, err := decoders[tt.want](bytes.NewReader(body))
+			require.NoError(t, err)
+			decoded, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, originalBody, string(decoded))
+		})
+	}
+}
+
+func TestSelectEncoder_HeaderNameAnyCase(t *testing.T) {
+	encoders := []Encoder{GzipEncoder(gzip.BestSpeed)}
+	identityOnly := types.Request{Headers: map[string]string{"accept-encoding": "identity"}}
+	_, ok := selectEncoder(encoders, identityOnly, "text/plain", 100)
+	assert.False(t, ok)
+
+	res := types.Response{Status: types.StatusOK, Headers: map[string]string{}, Body: []byte("body")}
+	refused := types.Request{Headers: map[string]string{"accept-encoding": "compress, identity;q=0"}}
+	assert.False(t, codingAcceptable(encoders, refused, res))
+}
+
+func TestHandleConnection_EncoderEligibility(t *testing.T) {
+	small := GzipEncoder(gzip.BestSpeed)
+	small.MinSize = 100
+	textOnly := GzipEncoder(gzip.BestSpeed)
+	textOnly.ContentTypes = []string{"text/*", "application/json"}
+
+	tests := []struct {
+		name        string
+		encoders    []Encoder
+		contentType string
+		body        []byte
+		wantEncoded bool
+	}{
+		{"below min size", []Encoder{small}, "text/plain", []byte("tiny"), false},
+		{"above min size", []Encoder{small}, "text/plain", bytes.Repeat([]byte("a"), 200), true},
+		{"image skipped by default", nil, "image/png", bytes.Repeat([]byte("a"), 200), false},
+		{"svg compressed by default", nil, "image/svg+xml", bytes.Repeat([]byte("a"), 2000), true},
+		{"below default min size", nil, "text/plain", bytes.Repeat([]byte("a"), DefaultMinCompressSize-1), false},
+		{"at default min size", nil, "text/plain", bytes.Repeat([]byte("a"), DefaultMinCompressSize), true},
+		{"allowed type wildcard", []Encoder{textOnly}, "text/html; charset=utf-8", []byte("hello"), true},
+		{"allowed type exact", []Encoder{textOnly}, "application/json", []byte("{}"), true},
+		{"type not allowed", []Encoder{textOnly}, "application/octet-stream", []byte("hello"), false},
+		{"compression disabled", []Encoder{}, "text/plain", []byte("hello"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := mockHandler(types.Response{
+				Status:  types.StatusOK,
+				Headers: map[string]string{"Content-Type": tt.contentType},
+				Body:    tt.body,
+			})
+			s := NewServer("").WithHandler(h)
+			if tt.encoders != nil {
+				s.WithEncoders(tt.encoders...)
+			}
+			request := "GET / HTTP/1.1\r\nHost: test.com\r\nAccept-Encoding: gzip\r\n\r\n"
+
+			_, headers, body, err := runServerTest(t, s, request)
+			require.NoError(t, err)
+			assert.Equal(t, "Accept-Encoding", headers["Vary"])
+			if tt.wantEncoded {
+				assert.Equal(t, "gzip", headers["Content-Encoding"])
+			} else {
+				assert.NotContains(t, headers, "Content-Encoding")
+				assert.Equal(t, tt.body, body)
+			}
+		})
+	}
+}
+
+func TestZstdEncoder_ReusesPooledEncoders(t *testing.T) {
+	enc := ZstdEncoder(3)
+	for _, text := range []string{"first body", "second body"} {
+		compressed, err := compress(enc, []byte(text))
+		require.NoError(t, err)
+		d, err := zstd.NewReader(bytes.NewReader(compressed))
+		require.NoError(t, err)
+		decoded, err := io.ReadAll(d)
+		d.Close()
+		require.NoError(t, err)
+		assert.Equal(t, text, string(decoded))
+	}
+}
+
+func TestHandleConnection_ChunkedGzipResponse(t *testing.T) {
+	expectedBody := strings.Repeat("streamed log line\n", 1000)
+	h := mockHandler(types.Response{
+		Status:     types.StatusOK,
+		Headers:    map[string]string{"Content-Type": "text/plain"},
+		BodyReader: strings.NewReader(expectedBody),
+	})
+	request := "GET /logs HTTP/1.1\r\nHost: test.com\r\nAccept-Encoding: gzip\r\n\r\n"
+
+	status, headers, body, err := runHandleConnectionTest(t, h, request)
+	require.NoError(t, err)
+
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "chunked", headers["Transfer-Encoding"])
+	assert.Equal(t, "gzip", headers["Content-Encoding"])
+	assert.Equal(t, "Accept-Encoding", headers["Vary"])
+	assert.NotContains(t, headers, "Content-Length")
+
+	gzReader, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decodedBody, err := io.ReadAll(gzReader)
+	require.NoError(t, err)
+	assert.Equal(t, expectedBody, string(decodedBody))
+}
+
+func TestHandleConnection_ChunkedGzipStreamsWithoutBuffering(t *testing.T) {
+	pr, pw := io.Pipe()
+	h := mockHandler(types.Response{
+		Status:     types.StatusOK,
+		Headers:    map[string]string{"Content-Type": "text/plain"},
+		BodyReader: pr,
+	})
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		defer serverConn.Close()
+		(&Server{handler: h}).handleConnection(serverConn)
+	}()
+	_, err := clientConn.Write([]byte("GET /stream HTTP/1.1\r\nHost: test.com\r\nAccept-Encoding: gzip\r\n\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(clientConn)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	go pw.Write([]byte("first event\n"))
+
+	// The first event must be decodable before the stream ends.
+	gzReader, err := gzip.NewReader(httputil.NewChunkedReader(reader))
+	require.NoError(t, err)
+	lines := bufio.NewReader(gzReader)
+	first, err := lines.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "first event\n", first)
+
+	go func() {
+		pw.Write([]byte("second event\n"))
+		pw.Close()
+	}()
+	rest, err := io.ReadAll(lines)
+	require.NoError(t, err)
+	assert.Equal(t, "second event\n", string(rest))
+}
+
+// flushCounter counts the flushes of the gzip writer it wraps.
+type flushCounter struct {
+	*gzip.Writer
+	flushes int
+}
+
+func (f *flushCounter) Flush() error {
+	f.flushes++
+	return f.Writer.Flush()
+}
+
+// pieces yields n small reads that are all ready at once.
+type pieces struct{ n int }
+
+func (p *pieces) Read(b []byte) (int, error) {
+	if p.n == 0 {
+		return 0, io.EOF
+	}
+	p.n--
+	return copy(b, "0123456789"), nil
+}
+
+func TestStreamBody_FlushesOnlyWhenSourceIdle(t *testing.T) {
+	var fc *flushCounter
+	enc := Encoder{Name: "gzip", NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+		fc = &flushCounter{Writer: gzip.NewWriter(w)}
+		return fc, nil
+	}}
+
+	var buf bytes.Buffer
+	require.NoError(t, streamBody(&buf, &pieces{n: 200}, &enc))
+	assert.Less(t, fc.flushes, 100, "encoder flushed after most reads")
+	decoded, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	data, err := io.ReadAll(decoded)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("0123456789", 200), string(data))
+
+	buf.Reset()
+	require.NoError(t, streamBody(&buf, strings.NewReader(strings.Repeat("x", 64<<10)), &enc))
+	assert.Zero(t, fc.flushes)
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestHandleConnection_DecodesRequestBody(t *testing.T) {
+	payload := strings.Repeat("uploaded payload ", 50)
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	_, err := zw.Write(gzipBytes(t, []byte(payload)))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+	}{
+		{"gzip", "gzip", gzipBytes(t, []byte(payload))},
+		{"deflate", "deflate", func() []byte {
+			var buf bytes.Buffer
+			w := zlib.NewWriter(&buf)
+			w.Write([]byte(payload))
+			w.Close()
+			return buf.Bytes()
+		}()},
+		{"stacked codings", "gzip, deflate", zbuf.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := func(ctx context.Context, req types.Request) types.Response {
+				data, err := io.ReadAll(req.BodyReader)
+				require.NoError(t, err)
+				assert.Equal(t, payload, string(data))
+				assert.NotContains(t, req.Headers, "Content-Encoding")
+				assert.NotContains(t, req.Headers, "Content-Length")
+				return types.Response{Status: types.StatusCreated}
+			}
+			s := NewServer("").WithHandler(h).WithRequestDecoders(1<<20, GzipDecoder(), DeflateDecoder())
+			request := fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: test.com\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n%s",
+				tt.contentEncoding, len(tt.body), tt.body)
+
+			status, _, _, err := runServerTest(t, s, request)
+			require.NoError(t, err)
+			assert.Equal(t, "HTTP/1.1 201 Created", status)
+		})
+	}
+}
+
+func TestDecodeRequestBody_HeaderNameAnyCase(t *testing.T) {
+	payload := "uploaded payload"
+	body := gzipBytes(t, []byte(payload))
+	req := types.Request{
+		Headers:    map[string]string{"content-encoding": "gzip", "content-length": strconv.Itoa(len(body))},
+		BodyReader: bytes.NewReader(body),
+	}
+	s := NewServer("").WithRequestDecoders(1<<20, GzipDecoder())
+
+	status, err := s.decodeRequestBody(&req)
+	require.NoError(t, err)
+	assert.Equal(t, types.StatusOK, status)
+	assert.Empty(t, req.Headers)
+	data, err := io.ReadAll(req.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(data))
+}
+
+func TestHandleConnection_RequestDecodingErrors(t *testing.T) {
+	bomb := gzipBytes(t, bytes.Repeat([]byte{0}, 1<<20))
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+		wantStatus      string
+	}{
+		{"unsupported coding", "br", []byte("whatever"), "HTTP/1.1 415 Unsupported Media Type"},
+		{"decompression bomb", "gzip", bomb, "HTTP/1.1 413 Payload Too Large"},
+		{"corrupt body", "gzip", []byte("not gzip at all"), "HTTP/1.1 400 Bad Request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Bodies are decoded as they are read, so the handler sees
+			// the error and answers with its status.
+			h := func(ctx context.Context, req types.Request) types.Response {
+				_, err := io.ReadAll(req.BodyReader)
+				var he *types.HTTPError
+				require.ErrorAs(t, err, &he)
+				return types.Response{Status: he.Status}
+			}
+			s := NewServer("").WithHandler(h).WithRequestDecoders(1024, GzipDecoder())
+			request := fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: test.com\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n%s",
+				tt.contentEncoding, len(tt.body), tt.body)
+
+			status, headers, _, err := runServerTest(t, s, request)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, "close", headers["Connection"])
+			if tt.contentEncoding == "br" {
+				assert.Equal(t, "gzip", headers["Accept-Encoding"])
+			}
+		})
+	}
+}
+
+func TestHandleConnection_RequestDecodingDisabledByDefault(t *testing.T) {
+	compressed := gzipBytes(t, []byte("raw"))
+	h := func(ctx context.Context, req types.Request) types.Response {
+		data, err := io.ReadAll(req.BodyReader)
+		require.NoError(t, err)
+		assert.Equal(t, string(compressed), string(data))
+		return types.Response{Status: types.StatusOK}
+	}
+	request := fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: test.com\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(compressed), compressed)
+
+	status, _, _, err := runHandleConnectionTest(t, h, request)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+}
+
+// startConnection runs handleConnection on one end of a pipe and returns the
+// client end.
+func startConnection(t *testing.T, s *Server) (net.Conn, <-chan struct{}) {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer serverConn.Close()
+		s.handleConnection(serverConn)
+	}()
+	t.Cleanup(func() { clientConn.Close() })
+	return clientConn, done
+}
+
+func TestHandleConnection_IdleTimeoutClosesConnection(t *testing.T) {
+	s := (&Server{handler: mockHandler(types.Response{Status: types.StatusOK, Body: []byte("ok")})}).
+		WithTimeouts(time.Second, 50*time.Millisecond)
+	clientConn, done := startConnection(t, s)
+
+	_, err := clientConn.Write([]byte("GET / HTTP/1.1\r\nHost: test.com\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(clientConn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+
+	go io.Copy(io.Discard, reader)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("an idle kept-alive connection was not closed")
+	}
+}
+
+func TestHandleConnection_ReadHeaderTimeoutClosesConnection(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		t.Error("Handler should not be called for an incomplete request")
+		return types.Response{Status: types.StatusOK}
+	}
+	s := (&Server{handler: h}).WithTimeouts(50*time.Millisecond, time.Minute)
+	clientConn, done := startConnection(t, s)
+
+	_, err := clientConn.Write([]byte("GET / HTTP/1.1\r\nHost: te"))
+	require.NoError(t, err)
+	raw, _ := io.ReadAll(clientConn)
+	assert.Empty(t, raw)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a request whose headers never arrive was not dropped")
+	}
+}
+
+func TestHandleConnection_KeepAliveServesMultipleRequests(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		return types.Response{Status: types.StatusOK, Body: []byte(req.Target)}
+	}
+	clientConn, done := startConnection(t, &Server{handler: h})
+
+	for _, target := range []string{"/first", "/second"} {
+		_, err := clientConn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: test.com\r\n\r\n"))
+		require.NoError(t, err)
+		status, headers, body, err := readResponse(clientConn)
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK", status)
+		assert.Equal(t, "keep-alive", headers["Connection"])
+		assert.Equal(t, target, string(body))
+	}
+
+	clientConn.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("server should stop serving once the client closes the connection")
+	}
+}
+
+func TestHandleConnection_HTTP10ClosesByDefault(t *testing.T) {
+	h := mockHandler(types.Response{Status: types.StatusOK, Body: []byte("old school")})
+	clientConn, done := startConnection(t, &Server{handler: h})
+
+	// No Host header: HTTP/1.0 does not require one.
+	_, err := clientConn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	status, headers, body, err := readResponse(clientConn)
+	require.NoError(t, err)
+
+	assert.Equal(t, "HTTP/1.0 200 OK", status)
+	assert.Equal(t, "close", headers["Connection"])
+	assert.Equal(t, "old school", string(body))
+	<-done
+}
+
+func TestHandleConnection_HTTP10KeepAlive(t *testing.T) {
+	h := mockHandler(types.Response{Status: types.StatusOK, Body: []byte("again")})
+	clientConn, _ := startConnection(t, &Server{handler: h})
+
+	for i := 0; i < 2; i++ {
+		_, err := clientConn.Write([]byte("GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+		require.NoError(t, err)
+		status, headers, body, err := readResponse(clientConn)
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.0 200 OK", status)
+		assert.Equal(t, "keep-alive", headers["Connection"])
+		assert.Equal(t, "again", string(body))
+	}
+}
+
+func TestHandleConnection_HTTP10StreamedBodyIsCloseDelimited(t *testing.T) {
+	expectedBody := "streamed to an old client"
+	h := mockHandler(types.Response{
+		Status:     types.StatusOK,
+		BodyReader: strings.NewReader(expectedBody),
+	})
+	clientConn, _ := startConnection(t, &Server{handler: h})
+
+	_, err := clientConn.Write([]byte("GET /stream HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(clientConn)
+	require.NoError(t, err)
+
+	head, body, found := strings.Cut(string(raw), "\r\n\r\n")
+	require.True(t, found)
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.0 200 OK\r\n"))
+	assert.Contains(t, head, "Connection: close")
+	assert.NotContains(t, head, "Transfer-Encoding")
+	assert.NotContains(t, head, "Content-Length")
+	assert.Equal(t, expectedBody, body)
+}
+
+func TestHandleConnection_StreamedBodyWithContentLength(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "page.txt")
+	require.NoError(t, os.WriteFile(name, []byte("served from disk"), 0o644))
+	h := func(ctx context.Context, req types.Request) types.Response {
+		res := prepareResponse(req)
+		require.NoError(t, res.File(name))
+		return res
+	}
+
+	status, headers, body, err := runHandleConnectionTest(t, h, "GET /page.txt HTTP/1.1\r\nHost: test.com\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "16", headers["Content-Length"])
+	assert.NotContains(t, headers, "Transfer-Encoding")
+	assert.Equal(t, "keep-alive", headers["Connection"])
+	assert.Equal(t, "served from disk", string(body))
+}
+
+func TestHandleConnection_StreamedBodyShorterThanContentLength(t *testing.T) {
+	h := mockHandler(types.Response{
+		Status:     types.StatusOK,
+		Headers:    map[string]string{"Content-Length": "100"},
+		BodyReader: strings.NewReader("too short"),
+	})
+	clientConn, _ := startConnection(t, &Server{handler: h})
+
+	_, err := clientConn.Write([]byte("GET / HTTP/1.1\r\nHost: test.com\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(clientConn)
+	require.NoError(t, err)
+
+	head, body, found := strings.Cut(string(raw), "\r\n\r\n")
+	require.True(t, found)
+	assert.Contains(t, head, "Content-Length: 100")
+	assert.Equal(t, "too short", body)
+}
+
+func TestHandleConnection_HTTP11RequiresHost(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		t.Error("Handler should not be called without a Host header")
+		return types.Response{Status: types.StatusOK}
+	}
+	status, headers, _, err := runHandleConnectionTest(t, h, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+	assert.Equal(t, "close", headers["Connection"])
+}
+
+func TestHandleConnection_HijackHandsOverConnection(t *testing.T) {
+	hijacked := make(chan net.Conn, 1)
+	h := func(ctx context.Context, req types.Request) types.Response {
+		conn, rw, err := types.Hijack(ctx)
+		require.NoError(t, err)
+		_, _, err = types.Hijack(ctx)
+		assert.ErrorIs(t, err, types.ErrHijacked)
+
+		// Bytes sent behind the request are available from rw.
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(rw, buf)
+		require.NoError(t, err)
+		rw.WriteString("HTTP/1.1 200 Connection Established\r\n\r\n" + string(buf))
+		rw.Flush()
+		hijacked <- conn
+		return types.Response{Status: types.StatusInternalServerError}
+	}
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() { serverConn.Close(); clientConn.Close() })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		(&Server{handler: h}).handleConnection(serverConn)
+	}()
+
+	_, err := clientConn.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\ntunl"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(clientConn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", status)
+	rest := make([]byte, len("\r\ntunl"))
+	_, err = io.ReadFull(reader, rest)
+	require.NoError(t, err)
+	assert.Equal(t, "\r\ntunl", string(rest))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handleConnection did not return after the connection was hijacked")
+	}
+
+	// The server neither wrote the handler's response nor closed the
+	// connection.
+	conn := <-hijacked
+	go conn.Write([]byte("still open"))
+	got := make([]byte, len("still open"))
+	_, err = io.ReadFull(reader, got)
+	require.NoError(t, err)
+	assert.Equal(t, "still open", string(got))
+}
+
+func TestHandleConnection_HijackOnlyWhileHandlerRuns(t *testing.T) {
+	var saved context.Context
+	h := func(ctx context.Context, req types.Request) types.Response {
+		saved = ctx
+		return types.Response{Status: types.StatusOK, Body: []byte("ok")}
+	}
+	status, _, body, err := runHandleConnectionTest(t, h, "GET / HTTP/1.1\r\nHost: test.com\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "ok", string(body))
+
+	_, _, err = types.Hijack(saved)
+	assert.ErrorIs(t, err, types.ErrNotHijackable)
+	_, _, err = types.Hijack(context.Background())
+	assert.ErrorIs(t, err, types.ErrNotHijackable)
+}
+
+func TestHandleConnection_WebSocketUpgrade(t *testing.T) {
+	upgrade := websocket.Upgrader{}.Handler(func(ctx context.Context, req types.Request, conn *websocket.Conn) {
+		conn.WriteMessage(websocket.TextMessage, []byte("hello "+req.Target))
+	})
+	h := func(ctx context.Context, req types.Request) types.Response {
+		res := types.Response{Status: types.StatusOK, Headers: map[string]string{}}
+		upgrade(ctx, req, &res)
+		return res
+	}
+	clientConn, done := startConnection(t, &Server{handler: h})
+	_, err := clientConn.Write([]byte("GET /ws HTTP/1.1\r\nHost: test.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"))
+	require.NoError(t, err)
+	raw, _ := io.ReadAll(clientConn)
+	<-done
+
+	head, frame, ok := strings.Cut(string(raw), "\r\n\r\n")
+	require.True(t, ok, string(raw))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 101 Switching Protocols\r\n"))
+	assert.Contains(t, head, "Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+	assert.NotContains(t, head, "Content-Length")
+	assert.Equal(t, "\x81\x09hello /ws", frame, "the server writes nothing after the handshake but the handler's frames")
+}
+
+func TestHandleConnection_ClientDisconnectStopsStream(t *testing.T) {
+	canceled := make(chan struct{})
+	writeErr := make(chan error, 1)
+	h := func(ctx context.Context, req types.Request) types.Response {
+		pr, pw := io.Pipe()
+		go func() {
+			<-ctx.Done()
+			close(canceled)
+		}()
+		go func() {
+			for {
+				if _, err := pw.Write([]byte("tick\n")); err != nil {
+					writeErr <- err
+					return
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}()
+		return types.Response{Status: types.StatusOK, BodyReader: pr}
+	}
+	clientConn, _ := startConnection(t, &Server{handler: h})
+
+	_, err := clientConn.Write([]byte("GET /events HTTP/1.1\r\nHost: test.com\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(clientConn)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "tick\n" {
+			break
+		}
+	}
+	clientConn.Close()
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("request context was not canceled after the client disconnected")
+	}
+	select {
+	case err := <-writeErr:
+		assert.ErrorIs(t, err, io.ErrClosedPipe)
+	case <-time.After(time.Second):
+		t.Fatal("body reader was not closed after the client disconnected")
+	}
+}
+
+func TestHandleConnection_ResponseWriterFlush(t *testing.T) {
+	proceed := make(chan struct{})
+	wh := types.WriterHandler(func(ctx context.Context, req types.Request, w types.ResponseWriter) {
+		w.Header()["Content-Type"] = "text/plain"
+		w.WriteHeader(types.StatusCreated)
+		w.Write([]byte("partial"))
+		w.Flush()
+		<-proceed
+		w.Write([]byte(" done"))
+	})
+	handler := wh.Handler()
+	h := func(ctx context.Context, req types.Request) types.Response {
+		res := types.Response{Status: types.StatusOK, Headers: make(map[string]string)}
+		handler(ctx, req, &res)
+		return res
+	}
+	clientConn, _ := startConnection(t, &Server{handler: h})
+
+	_, err := clientConn.Write([]byte("GET / HTTP/1.1\r\nHost: test.com\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(clientConn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 201 Created\r\n", status)
+
+	// The flushed chunk arrives while the handler is still running.
+	var sawChunked bool
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "Transfer-Encoding: chunked\r\n" {
+			sawChunked = true
+		}
+		if line == "\r\n" {
+			break
+		}
+	}
+	assert.True(t, sawChunked)
+	chunk := make([]byte, len("7\r\npartial\r\n"))
+	_, err = io.ReadFull(reader, chunk)
+	require.NoError(t, err)
+	assert.Equal(t, "7\r\npartial\r\n", string(chunk))
+
+	close(proceed)
+	rest := make([]byte, len("5\r\n done\r\n0\r\n\r\n"))
+	_, err = io.ReadFull(reader, rest)
+	require.NoError(t, err)
+	assert.Equal(t, "5\r\n done\r\n0\r\n\r\n", string(rest))
+}
+
+func TestHandleConnection_ResponseTrailers(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		pr, pw := io.Pipe()
+		var sum int
+		go func() {
+			for _, part := range []string{"abc", "defg"} {
+				sum += len(part)
+				pw.Write([]byte(part))
+			}
+			pw.Close()
+		}()
+		return types.Response{
+			Status:     types.StatusOK,
+			Headers:    map[string]string{"Trailer": "x-checksum, Content-Length, Server-Timing"},
+			BodyReader: pr,
+			Trailers: func() map[string]string {
+				return map[string]string{
+					"X-Checksum":     strconv.Itoa(sum),
+					"Content-Length": "7",
+					"X-Undeclared":   "dropped",
+				}
+			},
+		}
+	}
+	clientConn, _ := startConnection(t, &Server{handler: h})
+	_, err := clientConn.Write([]byte("GET / HTTP/1.1\r\nHost: test.com\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(clientConn)
+	require.NoError(t, err)
+
+	head, body, ok := strings.Cut(string(raw), "\r\n\r\n")
+	require.True(t, ok)
+	head += "\r\n"
+	assert.Contains(t, head, "\r\nTrailer: X-Checksum, Server-Timing\r\n")
+	assert.Contains(t, head, "\r\nTransfer-Encoding: chunked\r\n")
+	assert.Equal(t, "3\r\nabc\r\n4\r\ndefg\r\n0\r\nX-Checksum: 7\r\n\r\n", body)
+}
+
+func TestHandleConnection_TrailersOnBufferedBody(t *testing.T) {
+	trailers := func() map[string]string { return map[string]string{"Grpc-Status": "0"} }
+	tests := []struct {
+		name    string
+		version string
+		want    string
+	}{
+		{"HTTP/1.1 switches to chunked", "HTTP/1.1", "2\r\nok\r\n0\r\nGrpc-Status: 0\r\n\r\n"},
+		{"HTTP/1.0 drops trailers", "HTTP/1.0", "ok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := func(ctx context.Context, req types.Request) types.Response {
+				return types.Response{
+					Status:   types.StatusOK,
+					Headers:  map[string]string{"Trailer": "Grpc-Status"},
+					Body:     []byte("ok"),
+					Trailers: trailers,
+				}
+			}
+			clientConn, _ := startConnection(t, &Server{handler: h})
+			_, err := clientConn.Write([]byte("GET / " + tt.version + "\r\nHost: test.com\r\nConnection: close\r\n\r\n"))
+			require.NoError(t, err)
+			raw, err := io.ReadAll(clientConn)
+			require.NoError(t, err)
+
+			head, body, _ := strings.Cut(string(raw), "\r\n\r\n")
+			assert.Equal(t, tt.want, body)
+			if tt.version == "HTTP/1.0" {
+				assert.NotContains(t, head, "Trailer:")
+			}
+		})
+	}
+}
+
+func TestParseRequest_ChunkedBodyWithTrailers(t *testing.T) {
+	raw := "POST /upload HTTP/1.1\r\nHost: test.com\r\nTransfer-Encoding: chunked\r\nTrailer: Content-MD5\r\n\r\n" +
+		"5;name=value\r\nhello\r\n7\r\n, world\r\n0\r\nContent-MD5: abc123\r\nX-Extra:  1 \r\n\r\n" +
+		"GET /next HTTP/1.1\r\nHost: test.com\r\n\r\n"
+	reader := bufio.NewReader(strings.NewReader(raw))
+
+	req, err := parseRequest(reader, bodyLimit(DefaultMaxRequestBodySize))
+	require.NoError(t, err)
+	assert.Nil(t, req.Body)
+	body, err := io.ReadAll(req.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(body))
+	assert.Equal(t, map[string]string{"Content-MD5": "abc123", "X-Extra": "1"}, req.Trailers)
+
+	next, err := parseRequest(reader, bodyLimit(DefaultMaxRequestBodySize))
+	require.NoError(t, err)
+	assert.Equal(t, "/next", next.Target)
+}
+
+func TestParseRequest_FramingHeadersAnyCase(t *testing.T) {
+	raw := "POST /a HTTP/1.1\r\nhost: test.com\r\ntransfer-encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n0\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: test.com\r\ncontent-length: 3\r\n\r\nabc"
+	reader := bufio.NewReader(strings.NewReader(raw))
+	for _, want := range []string{"hello", "abc"} {
+		req, err := parseRequest(reader, bodyLimit(DefaultMaxRequestBodySize))
+		require.NoError(t, err)
+		body, err := io.ReadAll(req.BodyReader)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(body))
+	}
+
+	for name, headers := range map[string]string{
+		"unsupported coding":   "transfer-encoding: gzip\r\n",
+		"oversized length":     "content-length: 99\r\n",
+		"both framings":        "Transfer-Encoding: chunked\r\ncontent-length: 5\r\n",
+		"conflicting lengths":  "Content-Length: 5\r\ncontent-length: 6\r\n",
+		"lowercase and length": "transfer-encoding: chunked\r\nContent-Length: 5\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			raw := "POST / HTTP/1.1\r\nHost: test.com\r\n" + headers + "\r\n"
+			_, err := parseRequest(bufio.NewReader(strings.NewReader(raw)), bodyLimit(10))
+			require.Error(t, err)
+			var he *types.HTTPError
+			switch name {
+			case "unsupported coding":
+				require.ErrorAs(t, err, &he)
+				assert.Equal(t, types.StatusNotImplemented, he.Status)
+			case "oversized length":
+				require.ErrorAs(t, err, &he)
+				assert.Equal(t, types.StatusPayloadTooLarge, he.Status)
+			default:
+				assert.False(t, errors.As(err, &he), "malformed framing is a plain 400")
+			}
+		})
+	}
+}
+
+func TestParseRequest_ChunkedBodyErrors(t *testing.T) {
+	tests := map[string]string{
+		"bad chunk size":         "zz\r\nhello\r\n0\r\n\r\n",
+		"missing CRLF":           "5\r\nhelloXX0\r\n\r\n",
+		"truncated body":         "a\r\nhello",
+		"unsupported coding":     "",
+		"malformed trailer line": "0\r\nno-colon\r\n\r\n",
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			te := "chunked"
+			if name == "unsupported coding" {
+				te = "gzip"
+			}
+			raw := "POST / HTTP/1.1\r\nHost: test.com\r\nTransfer-Encoding: " + te + "\r\n\r\n" + body
+			req, err := parseRequest(bufio.NewReader(strings.NewReader(raw)), bodyLimit(DefaultMaxRequestBodySize))
+			if err == nil {
+				_, err = io.ReadAll(req.BodyReader)
+			}
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseRequest_ChunkedFramingLimits(t *testing.T) {
+	manyTrailers := strings.Repeat("X-T: v\r\n", maxTrailerFields+1)
+	bigTrailer := "X-Big: " + strings.Repeat("a", maxChunkLineSize-16) + "\r\n"
+	tests := map[string]struct {
+		body string
+		want types.Status
+	}{
+		"long chunk extension": {"5;" + strings.Repeat("x", maxChunkLineSize) + "\r\nhello\r\n0\r\n\r\n", types.StatusBadRequest},
+		"long trailer line":    {"0\r\nX-Long: " + strings.Repeat("a", maxChunkLineSize) + "\r\n\r\n", types.StatusBadRequest},
+		"too many trailers":    {"0\r\n" + manyTrailers + "\r\n", types.StatusRequestHeaderFieldsTooLarge},
+		"trailer section size": {"0\r\n" + strings.Repeat(bigTrailer, maxTrailerSize/len(bigTrailer)+1) + "\r\n", types.StatusRequestHeaderFieldsTooLarge},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			raw := "POST / HTTP/1.1\r\nHost: test.com\r\nTransfer-Encoding: chunked\r\n\r\n" + tt.body
+			req, err := parseRequest(bufio.NewReader(strings.NewReader(raw)), bodyLimit(DefaultMaxRequestBodySize))
+			require.NoError(t, err)
+			_, err = io.ReadAll(req.BodyReader)
+			var httpErr *types.HTTPError
+			require.True(t, errors.As(err, &httpErr), "got %v", err)
+			assert.Equal(t, tt.want, httpErr.Status)
+		})
+	}
+}
+
+// bodyLimit returns a parseRequest limit of n bytes for every request.
+func bodyLimit(n int64) func(types.Request) int64 {
+	return func(types.Request) int64 { return n }
+}
+
+func TestParseRequest_BodySizeLimit(t *testing.T) {
+	tests := map[string]struct {
+		headers string
+		body    string
+		limit   int64
+		wantErr bool
+	}{
+		"length within limit":  {"Content-Length: 5\r\n", "hello", 5, false},
+		"length over limit":    {"Content-Length: 6\r\n", "hello!", 5, true},
+		"chunked within limit": {"Transfer-Encoding: chunked\r\n", "3\r\nhel\r\n2\r\nlo\r\n0\r\n\r\n", 5, false},
+		"chunked over limit":   {"Transfer-Encoding: chunked\r\n", "3\r\nhel\r\n3\r\nlo!\r\n0\r\n\r\n", 5, true},
+		"limit disabled":       {"Content-Length: 6\r\n", "hello!", -1, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			raw := "POST / HTTP/1.1\r\nHost: test.com\r\n" + tt.headers + "\r\n" + tt.body
+			req, err := parseRequest(bufio.NewReader(strings.NewReader(raw)), bodyLimit(tt.limit))
+			if err == nil {
+				_, err = io.ReadAll(req.BodyReader)
+			}
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			var he *types.HTTPError
+			require.ErrorAs(t, err, &he)
+			assert.Equal(t, types.StatusPayloadTooLarge, he.Status)
+		})
+	}
+}
+
+func TestHandleConnection_RequestBodyTooLarge(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		t.Error("handler must not be called for an oversized body")
+		return types.Response{Status: types.StatusOK}
+	}
+	s := (&Server{handler: h}).WithMaxRequestBodySize(4)
+	clientConn, done := startConnection(t, s)
+
+	_, err := clientConn.Write([]byte("POST / HTTP/1.1\r\nHost: test.com\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	status, headers, body, err := readResponse(clientConn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 413 Payload Too Large", status)
+	assert.Equal(t, "close", headers["Connection"])
+	assert.Contains(t, string(body), "request body exceeds 4 bytes")
+	<-done
+}
+
+func TestHandleConnection_RequestBodySizeLimitPerRequest(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		return types.Response{Status: types.StatusOK, Body: []byte(*req.Body)}
+	}
+	s := (&Server{handler: h}).
+		WithMaxRequestBodySize(4).
+		WithRequestBodySizeLimit(func(req types.Request) int64 {
+			if req.Target == "/upload" {
+				return 100
+			}
+			return 0
+		})
+
+	for target, want := range map[string]string{
+		"/upload": "HTTP/1.1 200 OK",
+		"/other":  "HTTP/1.1 413 Payload Too Large",
+	} {
+		request := "POST " + target + " HTTP/1.1\r\nHost: test.com\r\nContent-Length: 5\r\n\r\nhello"
+		status, _, _, err := runServerTest(t, s, request)
+		require.NoError(t, err)
+		assert.Equal(t, want, status, target)
+	}
+}
+
+func TestHandleConnection_UnsupportedTransferEncoding(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		t.Error("handler must not be called for an unsupported transfer coding")
+		return types.Response{Status: types.StatusOK}
+	}
+	clientConn, done := startConnection(t, &Server{handler: h})
+
+	_, err := clientConn.Write([]byte("POST / HTTP/1.1\r\nHost: test.com\r\nTransfer-Encoding: gzip, chunked\r\n\r\n"))
+	require.NoError(t, err)
+	status, headers, _, err := readResponse(clientConn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 501 Not Implemented", status)
+	assert.Equal(t, "close", headers["Connection"])
+	<-done
+}
+
+func readLine(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	return line
+}
+
+func TestHandleConnection_ExpectContinue(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		if req.Target == "/next" {
+			return types.Response{Status: types.StatusOK, Body: []byte("next")}
+		}
+		assert.Nil(t, req.Body, "body must not be read before the handler asks for it")
+		body, err := io.ReadAll(req.BodyReader)
+		require.NoError(t, err)
+		return types.Response{Status: types.StatusCreated, Body: body}
+	}
+	clientConn, _ := startConnection(t, &Server{handler: h})
+
+	_, err := clientConn.Write([]byte("POST /files/a HTTP/1.1\r\nHost: test.com\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(clientConn)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", readLine(t, reader))
+	assert.Equal(t, "\r\n", readLine(t, reader))
+
+	_, err = clientConn.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 201 Created\r\n", readLine(t, reader))
+	for readLine(t, reader) != "\r\n" {
+	}
+	body := make([]byte, 5)
+	_, err = io.ReadFull(reader, body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// The connection stays usable.
+	_, err = clientConn.Write([]byte("GET /next HTTP/1.1\r\nHost: test.com\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", readLine(t, reader))
+}
+
+func TestHandleConnection_ExpectContinueRejectedBeforeReading(t *testing.T) {
+	bodyErr := make(chan error, 1)
+	h := func(ctx context.Context, req types.Request) types.Response {
+		go func() {
+			// Reading after the response went out must not produce a late
+			// 100 Continue.
+			time.Sleep(20 * time.Millisecond)
+			_, err := req.BodyReader.Read(make([]byte, 1))
+			bodyErr <- err
+		}()
+		return types.Response{Status: types.StatusPayloadTooLarge}
+	}
+	clientConn, done := startConnection(t, &Server{handler: h})
+
+	_, err := clientConn.Write([]byte("POST /files/big HTTP/1.1\r\nHost: test.com\r\nExpect: 100-continue\r\nContent-Length: 9999999\r\n\r\n"))
+	require.NoError(t, err)
+	status, headers, _, err := readResponse(clientConn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 413 Payload Too Large", status)
+	assert.Equal(t, "close", headers["Connection"])
+	<-done
+	assert.ErrorIs(t, <-bodyErr, errBodyAfterResponse)
+}
+
+func TestHandleConnection_BodyReadAsHandlerReads(t *testing.T) {
+	called := make(chan struct{})
+	h := func(ctx context.Context, req types.Request) types.Response {
+		close(called)
+		body, err := io.ReadAll(req.BodyReader)
+		require.NoError(t, err)
+		return types.Response{Status: types.StatusOK, Body: body}
+	}
+	clientConn, _ := startConnection(t, &Server{handler: h})
+	// Bodies this large are not buffered into Request.Body first.
+	payload := strings.Repeat("a", maxBufferedBodySize+1)
+
+	_, err := clientConn.Write([]byte(fmt.Sprintf("POST / HTTP/1.1\r\nHost: test.com\r\nContent-Length: %d\r\n\r\n", len(payload))))
+	require.NoError(t, err)
+	<-called // before the body was sent
+	_, err = clientConn.Write([]byte(payload))
+	require.NoError(t, err)
+	status, _, body, err := readResponse(clientConn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, payload, string(body))
+}
+
+func TestHandleConnection_BuffersSmallBodies(t *testing.T) {
+	large := strings.Repeat("a", maxBufferedBodySize+1)
+	tests := map[string]struct {
+		headers      string
+		body         string
+		wantBuffered bool
+	}{
+		"small with length": {"Content-Length: 5\r\n", "hello", true},
+		"empty with length": {"Content-Length: 0\r\n", "", true},
+		"chunked":           {"Transfer-Encoding: chunked\r\n", "5\r\nhello\r\n0\r\n\r\n", false},
+		"over threshold":    {fmt.Sprintf("Content-Length: %d\r\n", len(large)), large, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := func(ctx context.Context, req types.Request) types.Response {
+				data, err := io.ReadAll(req.BodyReader)
+				require.NoError(t, err)
+				if tt.wantBuffered {
+					require.NotNil(t, req.Body)
+					assert.Equal(t, string(data), *req.Body)
+				} else {
+					assert.Nil(t, req.Body)
+				}
+				return types.Response{Status: types.StatusOK, Body: data}
+			}
+			request := "POST / HTTP/1.1\r\nHost: test.com\r\n" + tt.headers + "\r\n" + tt.body
+
+			status, _, _, err := runHandleConnectionTest(t, h, request)
+			require.NoError(t, err)
+			assert.Equal(t, "HTTP/1.1 200 OK", status)
+		})
+	}
+}
+
+func TestHandleConnection_DrainsUnreadBody(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		return types.Response{Status: types.StatusOK, Body: []byte(req.Target)}
+	}
+	clientConn, _ := startConnection(t, &Server{handler: h})
+
+	go clientConn.Write([]byte("POST /first HTTP/1.1\r\nHost: test.com\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /second HTTP/1.1\r\nHost: test.com\r\n\r\n"))
+	reader := bufio.NewReader(clientConn)
+	for _, want := range []string{"/first", "/second"} {
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, want, string(body))
+	}
+}
+
+func TestHandleConnection_ExpectContinueDrainsUnreadBody(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		if req.BodyReader != nil {
+			buf := make([]byte, 2)
+			io.ReadFull(req.BodyReader, buf)
+			return types.Response{Status: types.StatusOK, Body: buf}
+		}
+		return types.Response{Status: types.StatusOK, Body: []byte(req.Target)}
+	}
+	clientConn, _ := startConnection(t, &Server{handler: h})
+
+	_, err := clientConn.Write([]byte("POST / HTTP/1.1\r\nHost: test.com\r\nExpect: 100-continue\r\nTransfer-Encoding: chunked\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(clientConn)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", readLine(t, reader))
+	assert.Equal(t, "\r\n", readLine(t, reader))
+
+	go clientConn.Write([]byte("a\r\n0123456789\r\n0\r\n\r\nGET /after HTTP/1.1\r\nHost: test.com\r\n\r\n"))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", readLine(t, reader))
+	var contentLength int
+	for line := readLine(t, reader); line != "\r\n"; line = readLine(t, reader) {
+		if v, ok := strings.CutPrefix(line, "Content-Length: "); ok {
+			contentLength, _ = strconv.Atoi(strings.TrimSpace(v))
+		}
+	}
+	body := make([]byte, contentLength)
+	_, err = io.ReadFull(reader, body)
+	require.NoError(t, err)
+	assert.Equal(t, "01", string(body))
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", readLine(t, reader), "the next request is read after the drained body")
+}
+
+func TestHandleConnection_ExpectContinueDecodesBody(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		assert.Empty(t, req.Header("Content-Encoding"))
+		body, err := io.ReadAll(req.BodyReader)
+		require.NoError(t, err)
+		return types.Response{Status: types.StatusOK, Body: body}
+	}
+	s := (&Server{handler: h}).WithRequestDecoders(1024, GzipDecoder())
+	clientConn, _ := startConnection(t, s)
+
+	compressed := gzipBytes(t, []byte("inflated"))
+	_, err := clientConn.Write([]byte("POST / HTTP/1.1\r\nHost: test.com\r\nConnection: close\r\nExpect: 100-continue\r\nContent-Encoding: gzip\r\nContent-Length: " + strconv.Itoa(len(compressed)) + "\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(clientConn)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", readLine(t, reader))
+	assert.Equal(t, "\r\n", readLine(t, reader))
+	_, err = clientConn.Write(compressed)
+	require.NoError(t, err)
+
+	raw, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\ninflated"))
+}
+
+func TestHandleConnection_UnsupportedExpectation(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		t.Error("Handler should not be called for an unsupported expectation")
+		return types.Response{Status: types.StatusOK}
+	}
+	status, _, _, err := runHandleConnectionTest(t, h, "GET / HTTP/1.1\r\nHost: test.com\r\nExpect: teapot\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 417 Expectation Failed", status)
+}
+
+func TestHandleConnection_EarlyHints(t *testing.T) {
+	var saved context.Context
+	h := func(ctx context.Context, req types.Request) types.Response {
+		saved = ctx
+		link := map[string]string{"Link": "</style.css>; rel=preload; as=style"}
+		require.NoError(t, types.WriteInterim(ctx, types.StatusProcessing, nil))
+		require.NoError(t, types.WriteInterim(ctx, types.StatusEarlyHints, link))
+		require.NoError(t, types.WriteInterim(ctx, types.StatusEarlyHints, link))
+		assert.Error(t, types.WriteInterim(ctx, types.StatusOK, nil), "final statuses are not interim")
+		assert.Error(t, types.WriteInterim(ctx, types.StatusSwitchingProtocols, nil))
+		return types.Response{Status: types.StatusOK, Body: []byte("page")}
+	}
+	clientConn, _ := startConnection(t, &Server{handler: h})
+	_, err := clientConn.Write([]byte("GET / HTTP/1.1\r\nHost: test.com\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(clientConn)
+	require.NoError(t, err)
+
+	hint := "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload; as=style\r\n\r\n"
+	processing := "HTTP/1.1 102 Processing\r\n\r\n"
+	require.True(t, strings.HasPrefix(string(raw), processing+hint+hint+"HTTP/1.1 200 OK\r\n"), string(raw))
+	assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\npage"))
+
+	assert.ErrorIs(t, types.WriteInterim(saved, types.StatusEarlyHints, nil), types.ErrResponseStarted)
+}
+
+func TestHandleConnection_NoInterimResponsesForHTTP10(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		err := types.WriteInterim(ctx, types.StatusEarlyHints, map[string]string{"Link": "</a.js>; rel=preload"})
+		assert.ErrorIs(t, err, types.ErrInterimNotSupported)
+		return types.Response{Status: types.StatusOK}
+	}
+	status, _, _, err := runHandleConnectionTest(t, h, "GET / HTTP/1.0\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.0 200 OK", status)
+}
+
+type recordedPanic struct {
+	target    string
+	recovered any
+	stack     string
+}
+
+func recordPanics() (PanicHook, <-chan recordedPanic) {
+	panics := make(chan recordedPanic, 1)
+	return func(req types.Request, recovered any, stack []byte) {
+		panics <- recordedPanic{req.Target, recovered, string(stack)}
+	}, panics
+}
+
+func TestHandleConnection_PanicReturns500(t *testing.T) {
+	hook, panics := recordPanics()
+	h := func(ctx context.Context, req types.Request) types.Response {
+		panic("boom")
+	}
+	s := (&Server{handler: h}).WithPanicHook(hook)
+
+	status, headers, _, err := runServerTest(t, s, "GET /explode HTTP/1.1\r\nHost: test.com\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error", status)
+	assert.Equal(t, "close", headers["Connection"])
+
+	p := <-panics
+	assert.Equal(t, "/explode", p.target)
+	assert.Equal(t, "boom", p.recovered)
+	assert.Contains(t, p.stack, "TestHandleConnection_PanicReturns500")
+}
+
+func TestHandleConnection_WriterHandlerPanics(t *testing.T) {
+	tests := []struct {
+		name      string
+		writeLate bool
+	}{
+		{"before writing answers 500", false},
+		{"after writing aborts the stream", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook, panics := recordPanics()
+			handler := types.WriterHandler(func(ctx context.Context, req types.Request, w types.ResponseWriter) {
+				if tt.writeLate {
+					w.Write([]byte("partial"))
+					w.Flush()
+				}
+				panic("writer failed")
+			}).Handler()
+			h := func(ctx context.Context, req types.Request) types.Response {
+				res := types.Response{Status: types.StatusOK, Headers: make(map[string]string)}
+				handler(ctx, req, &res)
+				return res
+			}
+			clientConn, done := startConnection(t, (&Server{handler: h}).WithPanicHook(hook))
+			_, err := clientConn.Write([]byte("GET /w HTTP/1.1\r\nHost: test.com\r\n\r\n"))
+			require.NoError(t, err)
+			raw, _ := io.ReadAll(clientConn)
+			<-done
+
+			if tt.writeLate {
+				assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.1 200 OK\r\n"))
+				assert.Contains(t, string(raw), "7\r\npartial\r\n")
+				assert.NotContains(t, string(raw), "0\r\n\r\n", "an aborted stream must not look complete")
+			} else {
+				assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.1 500 Internal Server Error\r\n"))
+			}
+			p := <-panics
+			assert.Equal(t, "writer failed", p.recovered)
+			assert.Contains(t, p.stack, "TestHandleConnection_WriterHandlerPanics", "the original stack is kept")
+		})
+	}
+}
+
+func TestHandleConnection_NoContentHasNoContentLength(t *testing.T) {
+	h := func(ctx context.Context, req types.Request) types.Response {
+		var res types.Response
+		res.NoContent()
+		return res
+	}
+	status, headers, body, err := runHandleConnectionTest(t, h, "DELETE /x HTTP/1.1\r\nHost: test.com\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 204 No Content", status)
+	assert.NotContains(t, headers, "Content-Length")
+	assert.Empty(t, body)
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certReloadInterval is how often certificate files are checked for changes.
+var certReloadInterval = 10 * time.Second
+
+// CertificateFiles names a PEM encoded certificate chain and its private key.
+type CertificateFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// WithTLSConfig sets the base TLS configuration used by ListenTLS. The
+// config is cloned. Certificates loaded by ListenTLS are served through its
+// GetCertificate callback; if the config has one already, it is asked first
+// and the loaded certificates are used when it returns none.
+func (s *Server) WithTLSConfig(cfg *tls.Config) *Server {
+	s.tlsConfig = cfg.Clone()
+	return s
+}
+
+// WithTLSCertificate adds a certificate served by ListenTLS to clients whose
+// SNI server name it covers. It is reloaded along with the primary one.
+func (s *Server) WithTLSCertificate(certFile, keyFile string) *Server {
+	s.tlsCertificates = append(s.tlsCertificates, CertificateFiles{CertFile: certFile, KeyFile: keyFile})
+	return s
+}
+
+// ListenTLS is like Listen but serves HTTPS. certFile and keyFile hold the
+// default certificate; they may be empty when the TLS config set with
+// WithTLSConfig already provides certificates. Certificate files are reloaded
+// when they change on disk or the process receives SIGHUP, without affecting
+// established connections.
+func (s Server) ListenTLS(certFile, keyFile string) (net.Listener, Error) {
+	cfg, reloader, err := s.buildTLSConfig(certFile, keyFile)
+	if err != nil {
+		s.log().Error("failed to load TLS certificates", "err", err)
+		return nil, err
+	}
+	if reloader != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		reloader.logger = s.log()
+		go reloader.Watch(ctx, certReloadInterval)
+	}
+
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		s.log().Error("failed to listen", "addr", s.addr, "err", err)
+		return nil, err
+	}
+	tl := tls.NewListener(l, cfg)
+	return tl, s.serve(tl)
+}
+
+func (s Server) buildTLSConfig(certFile, keyFile string) (*tls.Config, *CertReloader, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.tlsConfig != nil {
+		cfg = s.tlsConfig.Clone()
+	}
+	if len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{http2ALPN, "http/1.1"}
+	}
+
+	var files []CertificateFiles
+	if certFile != "" || keyFile != "" {
+		files = append(files, CertificateFiles{CertFile: certFile, KeyFile: keyFile})
+	}
+	files = append(files, s.tlsCertificates...)
+	if len(files) == 0 {
+		if len(cfg.Certificates) == 0 && cfg.GetCertificate == nil && cfg.GetConfigForClient == nil {
+			return nil, nil, errors.New("no TLS certificate configured")
+		}
+		return cfg, nil, nil
+	}
+
+	reloader, err := NewCertReloader(files...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if get := cfg.GetCertificate; get != nil {
+		cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert, err := get(hello); cert != nil || err != nil {
+				return cert, err
+			}
+			return reloader.GetCertificate(hello)
+		}
+	} else {
+		cfg.GetCertificate = reloader.GetCertificate
+	}
+	return cfg, reloader, nil
+}
+
+type loadedCert struct {
+	files   CertificateFiles
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// CertReloader serves a set of certificates loaded from disk, choosing one by
+// SNI, and swaps them atomically when the files change.
+type CertReloader struct {
+	mu    sync.RWMutex
+	files []CertificateFiles
+	certs []loadedCert
+
+	// logger receives reload failures; nil means slog.Default().
+	logger *slog.Logger
+}
+
+// NewCertReloader loads the given certificates. The first one is the default
+// for clients that send no SNI name or one no certificate covers.
+func NewCertReloader(files ...CertificateFiles) (*CertReloader, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no TLS certificate configured")
+	}
+	r := &CertReloader{files: files}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads every certificate from disk again. If any of them fails to
+// load, the previously loaded set stays in use.
+func (r *CertReloader) Reload() error {
+	certs := make([]loadedCert, 0, len(r.files))
+	for _, f := range r.files {
+		modTime, err := latestModTime(f)
+		if err != nil {
+			return err
+		}
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return fmt.Errorf("error loading certificate %s: %w", f.CertFile, err)
+		}
+		certs = append(certs, loadedCert{files: f, cert: &cert, modTime: modTime})
+	}
+
+	r.mu.Lock()
+	r.certs = certs
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if hello.ServerName != "" {
+		for _, c := range r.certs {
+			if hello.SupportsCertificate(c.cert) == nil {
+				return c.cert, nil
+			}
+		}
+	}
+	return r.certs[0].cert, nil
+}
+
+// Watch reloads the certificates whenever one of their files changes, polling
+// every interval, or the process receives SIGHUP. It returns when ctx is done.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := r.Reload(); err != nil {
+				r.log().Error("failed to reload TLS certificates", "err", err)
+			}
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				r.log().Error("failed to reload TLS certificates", "err", err)
+			}
+		}
+	}
+}
+
+func (r *CertReloader) log() *slog.Logger {
+	if r.logger == nil {
+		return slog.Default()
+	}
+	return r.logger
+}
+
+func (r *CertReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.certs {
+		modTime, err := latestModTime(c.files)
+		if err == nil && !modTime.Equal(c.modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func latestModTime(f CertificateFiles) (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{f.CertFile, f.KeyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error reading certificate file: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSelfSignedCert generates a self-signed certificate for dnsNames and
+// writes it and its key as PEM files in dir.
+func writeSelfSignedCert(t *testing.T, dir, name string, serial int64, dnsNames ...string) CertificateFiles {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	files := CertificateFiles{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(files.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(files.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return files
+}
+
+func startTLSServer(t *testing.T, s *Server, certFile, keyFile string) (string, *CertReloader) {
+	t.Helper()
+	cfg, reloader, err := s.buildTLSConfig(certFile, keyFile)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go s.serve(tls.NewListener(l, cfg))
+	return l.Addr().String(), reloader
+}
+
+// peerCertificate performs a TLS handshake and a GET request, returning the
+// leaf certificate presented by the server and the response status line.
+func peerCertificate(t *testing.T, addr, serverName string) (*x509.Certificate, string) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + serverName + "\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	return conn.ConnectionState().PeerCertificates[0], strings.TrimRight(status, "\r\n")
+}
+
+func TestListenTLS_ServesHTTPS(t *testing.T) {
+	dir := t.TempDir()
+	primary := writeSelfSignedCert(t, dir, "primary", 1, "localhost")
+	s := NewServer("").WithHandler(mockHandler(types.Response{Status: types.StatusOK, Body: []byte("secure")}))
+
+	addr, _ := startTLSServer(t, s, primary.CertFile, primary.KeyFile)
+	cert, status := peerCertificate(t, addr, "localhost")
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, []string{"localhost"}, cert.DNSNames)
+}
+
+func TestListenTLS_SNISelectsCertificate(t *testing.T) {
+	dir := t.TempDir()
+	primary := writeSelfSignedCert(t, dir, "primary", 1, "default.test")
+	other := writeSelfSignedCert(t, dir, "other", 2, "api.example.test")
+	wildcard := writeSelfSignedCert(t, dir, "wildcard", 3, "*.static.test")
+	s := NewServer("").
+		WithHandler(mockHandler(types.Response{Status: types.StatusOK})).
+		WithTLSCertificate(other.CertFile, other.KeyFile).
+		WithTLSCertificate(wildcard.CertFile, wildcard.KeyFile)
+
+	addr, _ := startTLSServer(t, s, primary.CertFile, primary.KeyFile)
+
+	tests := []struct {
+		serverName string
+		wantSerial int64
+	}{
+		{"api.example.test", 2},
+		{"img.static.test", 3},
+		{"default.test", 1},
+		{"unknown.test", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			cert, _ := peerCertificate(t, addr, tt.serverName)
+			assert.Equal(t, tt.wantSerial, cert.SerialNumber.Int64())
+		})
+	}
+}
+
+func TestListenTLS_ReloadsChangedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	files := writeSelfSignedCert(t, dir, "server", 1, "localhost")
+	s := NewServer("").WithHandler(mockHandler(types.Response{Status: types.StatusOK}))
+	addr, reloader := startTLSServer(t, s, files.CertFile, files.KeyFile)
+
+	// An established connection keeps working across the reload.
+	established, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "localhost", InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer established.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10*time.Millisecond)
+
+	writeSelfSignedCert(t, dir, "server", 2, "localhost")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(files.CertFile, future, future))
+
+	require.Eventually(t, func() bool {
+		cert, _ := peerCertificate(t, addr, "localhost")
+		return cert.SerialNumber.Int64() == 2
+	}, 2*time.Second, 20*time.Millisecond)
+
+	_, err = established.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	status, err := bufio.NewReader(established).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+}
+
+func TestCertReloader_KeepsCertificatesOnFailedReload(t *testing.T) {
+	dir := t.TempDir()
+	files := writeSelfSignedCert(t, dir, "server", 1, "localhost")
+	reloader, err := NewCertReloader(files)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(files.CertFile, []byte("garbage"), 0o600))
+	assert.Error(t, reloader.Reload())
+
+	cert, err := reloader.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), leaf.SerialNumber.Int64())
+}
+
+func TestBuildTLSConfig_RequiresCertificate(t *testing.T) {
+	_, _, err := NewServer("").buildTLSConfig("", "")
+	assert.Error(t, err)
+
+	cfg, reloader, err := NewServer("").WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{{}}}).buildTLSConfig("", "")
+	require.NoError(t, err)
+	assert.Nil(t, reloader)
+	assert.Len(t, cfg.Certificates, 1)
+}
+
+func TestListenTLS_KeepsCallerGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	primary := writeSelfSignedCert(t, dir, "primary", 1, "default.test")
+	custom := writeSelfSignedCert(t, dir, "custom", 2, "custom.test")
+	customCert, err := tls.LoadX509KeyPair(custom.CertFile, custom.KeyFile)
+	require.NoError(t, err)
+	s := NewServer("").
+		WithHandler(mockHandler(types.Response{Status: types.StatusOK})).
+		WithTLSConfig(&tls.Config{
+			GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				if hello.ServerName == "custom.test" {
+					return &customCert, nil
+				}
+				return nil, nil
+			},
+		})
+
+	addr, _ := startTLSServer(t, s, primary.CertFile, primary.KeyFile)
+
+	cert, _ := peerCertificate(t, addr, "custom.test")
+	assert.Equal(t, int64(2), cert.SerialNumber.Int64())
+	cert, _ = peerCertificate(t, addr, "default.test")
+	assert.Equal(t, int64(1), cert.SerialNumber.Int64(), "certificates the callback does not provide come from the reloader")
+}
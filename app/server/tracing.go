@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/tracing"
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// WithTracer starts a server span for every request, continuing the trace
+// propagated in the traceparent and tracestate headers. Handlers get the
+// span with tracing.SpanFromContext and start child spans with
+// tracing.Start. Spans are named after the method and the matched route
+// pattern, such as "GET /files/:path".
+func (s *Server) WithTracer(t *tracing.Tracer) *Server {
+	s.tracer = t
+	return s
+}
+
+// startSpan starts the server span of req and returns a context carrying it.
+func (s Server) startSpan(ctx context.Context, req types.Request, remoteAddr net.Addr) context.Context {
+	if s.tracer == nil {
+		return ctx
+	}
+	if sc, err := tracing.Extract(req.Header(tracing.TraceparentHeader), req.Header(tracing.TracestateHeader)); err == nil {
+		ctx = tracing.ContextWithRemoteParent(ctx, sc)
+	}
+	ctx, span := s.tracer.Start(ctx, string(req.Method), tracing.SpanKindServer)
+
+	path, query, _ := strings.Cut(req.Target, "?")
+	span.SetAttribute("http.request.method", string(req.Method))
+	span.SetAttribute("url.path", path)
+	if query != "" {
+		span.SetAttribute("url.query", query)
+	}
+	span.SetAttribute("network.protocol.version", strings.TrimPrefix(req.Version, "HTTP/"))
+	if ua := req.Header("User-Agent"); ua != "" {
+		span.SetAttribute("user_agent.original", ua)
+	}
+	if remoteAddr != nil {
+		host, _, err := net.SplitHostPort(remoteAddr.String())
+		if err != nil {
+			host = remoteAddr.String()
+		}
+		span.SetAttribute("client.address", host)
+	}
+	return ctx
+}
+
+// endSpan names the server span in ctx after the matched route and records
+// the response.
+func endSpan(ctx context.Context, req types.Request, status types.Status, responseBytes int64) {
+	span := tracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	if route := types.Route(ctx); route != "" {
+		span.SetName(string(req.Method) + " " + route)
+		span.SetAttribute("http.route", route)
+	}
+	span.SetAttribute("http.response.status_code", status.Code())
+	span.SetAttribute("http.response.body.size", responseBytes)
+	if status.Code() >= 500 {
+		span.SetStatus(tracing.StatusError, status.Reason())
+	}
+	span.End()
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/router"
+	"github.com/codecrafters-io/http-server-starter-go/app/tracing"
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleConnection_TracingContinuesTraceparent(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	var handlerSpan tracing.SpanContext
+	r := router.New()
+	r.Register(types.Get, "/files/:path", func(ctx context.Context, req types.Request, res *types.Response) {
+		handlerSpan = tracing.SpanFromContext(ctx).SpanContext()
+		_, child := tracing.Start(ctx, "read file")
+		child.End()
+	})
+	s := (&Server{}).WithHandler(r.HandleRequest).WithTracer(tracing.NewTracer(exporter))
+
+	status, _ := serveOne(t, s, "GET /files/a.txt?v=2 HTTP/1.1\r\nHost: test.com\r\nUser-Agent: tester\r\n"+
+		"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\ntracestate: rojo=1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	child, server := spans[0], spans[1]
+
+	assert.Equal(t, "GET /files/:path", server.Name)
+	assert.Equal(t, tracing.SpanKindServer, server.Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID.String())
+	assert.Equal(t, "rojo=1", server.SpanContext.TraceState)
+	assert.Equal(t, handlerSpan, server.SpanContext)
+	assert.Equal(t, "/files/:path", server.Attributes["http.route"])
+	assert.Equal(t, "/files/a.txt", server.Attributes["url.path"])
+	assert.Equal(t, "v=2", server.Attributes["url.query"])
+	assert.Equal(t, 200, server.Attributes["http.response.status_code"])
+	assert.Equal(t, "tester", server.Attributes["user_agent.original"])
+	assert.Equal(t, tracing.StatusUnset, server.Status)
+
+	assert.Equal(t, "read file", child.Name)
+	assert.Equal(t, server.SpanContext.SpanID, child.Parent.SpanID)
+}
+
+func TestHandleConnection_TracingMarksServerErrors(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	h := func(ctx context.Context, req types.Request) types.Response {
+		return types.Response{Status: types.StatusInternalServerError}
+	}
+	s := (&Server{handler: h}).WithTracer(tracing.NewTracer(exporter))
+
+	serveOne(t, s, "POST /jobs HTTP/1.1\r\nHost: test.com\r\ntraceparent: not-a-trace\r\n\r\n")
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "POST", spans[0].Name, "without a matched route the span is named after the method")
+	assert.False(t, spans[0].Parent.IsValid(), "an invalid traceparent starts a new trace")
+	assert.Equal(t, tracing.StatusError, spans[0].Status)
+	assert.Equal(t, 500, spans[0].Attributes["http.response.status_code"])
+}
+
+func TestHTTP2_TracingSpanPerStream(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	r := router.New()
+	r.Register(types.Get, "/echo/:path", func(ctx context.Context, req types.Request, res *types.Response) {
+		res.Body = []byte(req.Params["path"])
+	})
+	addr := startServer(t, NewServer("").WithHandler(r.HandleRequest).WithTracer(tracing.NewTracer(exporter)))
+
+	req, err := http.NewRequest("GET", "http://"+addr+"/echo/hi", nil)
+	require.NoError(t, err)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Accept-Encoding", "identity")
+	var dials int
+	resp, err := h2cClient(&dials).Do(req)
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// The span ends once the response has been written.
+	require.Eventually(t, func() bool { return len(exporter.Spans()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	span := exporter.Spans()[0]
+	assert.Equal(t, "GET /echo/:path", span.Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID.String())
+	assert.Equal(t, "2.0", span.Attributes["network.protocol.version"])
+	assert.Equal(t, int64(2), span.Attributes["http.response.body.size"])
+}
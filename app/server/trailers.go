@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/textproto"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// forbiddenTrailers are fields that must not be sent as trailers because
+// recipients need them before the body (RFC 9110 §6.5.1).
+var forbiddenTrailers = map[string]bool{
+	"Authorization":     true,
+	"Cache-Control":     true,
+	"Content-Encoding":  true,
+	"Content-Length":    true,
+	"Content-Range":     true,
+	"Content-Type":      true,
+	"Expires":           true,
+	"Host":              true,
+	"Location":          true,
+	"Retry-After":       true,
+	"Set-Cookie":        true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Vary":              true,
+}
+
+type trailerField struct {
+	name, value string
+}
+
+// declaredTrailers returns the names announced in the Trailer header that
+// may be sent as trailers, in canonical form.
+func declaredTrailers(headers map[string]string) []string {
+	var names []string
+	for _, name := range strings.Split(headers["Trailer"], ",") {
+		name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+		if name != "" && !forbiddenTrailers[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// trailerFields returns the trailer values the handler set for the names it
+// declared, in declaration order.
+func trailerFields(res types.Response, declared []string) []trailerField {
+	if res.Trailers == nil || len(declared) == 0 {
+		return nil
+	}
+	values := res.Trailers()
+	var fields []trailerField
+	for _, name := range declared {
+		for k, v := range values {
+			if strings.EqualFold(k, name) {
+				fields = append(fields, trailerField{name: name, value: v})
+				break
+			}
+		}
+	}
+	return fields
+}
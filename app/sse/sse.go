@@ -0,0 +1,175 @@
+// Package sse serves Server-Sent Events (text/event-stream).
+package sse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// defaultHeartbeat is used when Config.Heartbeat is zero.
+const defaultHeartbeat = 15 * time.Second
+
+var errInvalidField = errors.New("sse: id and event must not contain newlines")
+
+// Event is a single message on an event stream.
+type Event struct {
+	// ID sets the client's last event ID, which it sends back as
+	// Last-Event-ID when reconnecting.
+	ID string
+	// Event is the event type; clients dispatch untyped events as "message".
+	Event string
+	// Data is the payload. Multi-line data is sent as several data fields.
+	Data string
+	// Retry, when positive, changes the client's reconnection delay.
+	Retry time.Duration
+}
+
+// Handler produces events for one client. The stream ends when it returns.
+type Handler func(ctx context.Context, req types.Request, stream *Stream)
+
+// Config controls how event streams are served.
+type Config struct {
+	// Heartbeat is the interval between comment lines that keep idle
+	// connections open and reveal clients that went away. Zero means 15
+	// seconds; negative disables heartbeats.
+	Heartbeat time.Duration
+	// Retry, when positive, is sent to the client as its reconnection delay
+	// before any events.
+	Retry time.Duration
+}
+
+// Handler returns a types.Handler that streams the events produced by h.
+// ctx is canceled once the client disconnects, so h should return when
+// ctx.Done() is closed or Send fails.
+func (c Config) Handler(h Handler) types.Handler {
+	return func(ctx context.Context, req types.Request, res *types.Response) {
+		if res.Headers == nil {
+			res.Headers = make(map[string]string)
+		}
+		pr, pw := io.Pipe()
+		stream := &Stream{w: pw, lastEventID: req.Header("Last-Event-ID")}
+
+		res.Status = types.StatusOK
+		res.Headers["Content-Type"] = "text/event-stream"
+		res.Headers["Cache-Control"] = "no-cache"
+		res.BodyReader = pr
+
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					pw.CloseWithError(&types.PanicError{Value: p, Stack: debug.Stack()})
+				}
+			}()
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			if c.Retry > 0 {
+				stream.Send(Event{Retry: c.Retry})
+			}
+			heartbeat := c.Heartbeat
+			if heartbeat == 0 {
+				heartbeat = defaultHeartbeat
+			}
+			if heartbeat > 0 {
+				go stream.heartbeat(ctx, heartbeat)
+			}
+			h(ctx, req, stream)
+			pw.Close()
+		}()
+	}
+}
+
+// Stream writes events to a single client. Each event is handed to the
+// connection as soon as it is sent. It is safe for concurrent use.
+type Stream struct {
+	mu          sync.Mutex
+	w           io.Writer
+	lastEventID string
+}
+
+// LastEventID returns the Last-Event-ID the client sent when reconnecting,
+// so the handler can resume after it.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Send writes e to the client.
+func (s *Stream) Send(e Event) error {
+	if strings.ContainsAny(e.ID, "\r\n") || strings.ContainsAny(e.Event, "\r\n") {
+		return errInvalidField
+	}
+	return s.write(encode(e))
+}
+
+// Comment writes a comment line, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(":")
+		if line != "" {
+			b.WriteString(" " + line)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+func (s *Stream) write(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := io.WriteString(s.w, p)
+	return err
+}
+
+func (s *Stream) heartbeat(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Comment(""); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// encode formats e following the event stream grammar (WHATWG HTML
+// §9.2.5).
+func encode(e Event) string {
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	// Events without data are not dispatched by clients, so only events that
+	// carry nothing but id or retry may omit it.
+	if e.Data != "" || e.Event != "" {
+		for _, line := range splitLines(e.Data) {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
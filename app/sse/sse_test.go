@@ -0,0 +1,126 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"data only", Event{Data: "hello"}, "data: hello\n\n"},
+		{"all fields", Event{ID: "7", Event: "update", Data: "x", Retry: 2 * time.Second}, "id: 7\nevent: update\nretry: 2000\ndata: x\n\n"},
+		{"multi-line data", Event{Data: "a\nb\r\nc\rd"}, "data: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{"typed event without data", Event{Event: "ping"}, "event: ping\ndata: \n\n"},
+		{"retry only", Event{Retry: 500 * time.Millisecond}, "retry: 500\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, encode(tt.event))
+		})
+	}
+}
+
+func serve(ctx context.Context, c Config, req types.Request, h Handler) (types.Response, *bufio.Reader) {
+	res := types.Response{Status: types.StatusOK, Headers: make(map[string]string)}
+	c.Handler(h)(ctx, req, &res)
+	return res, bufio.NewReader(res.BodyReader)
+}
+
+// readEvent reads up to and including the blank line ending an event.
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var b strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		b.WriteString(line)
+		if line == "\n" {
+			return b.String()
+		}
+	}
+}
+
+func TestHandlerStreamsEvents(t *testing.T) {
+	req := types.Request{Method: types.Get, Headers: map[string]string{"Last-Event-ID": "41"}}
+	res, r := serve(context.Background(), Config{Heartbeat: -1, Retry: 3 * time.Second}, req,
+		func(ctx context.Context, req types.Request, stream *Stream) {
+			stream.Send(Event{ID: "42", Data: "resumed after " + stream.LastEventID()})
+			assert.ErrorIs(t, stream.Send(Event{ID: "a\nb"}), errInvalidField)
+			stream.Comment("bye")
+		})
+
+	assert.Equal(t, types.StatusOK, res.Status)
+	assert.Equal(t, "text/event-stream", res.Headers["Content-Type"])
+	assert.Equal(t, "no-cache", res.Headers["Cache-Control"])
+
+	assert.Equal(t, "retry: 3000\n\n", readEvent(t, r))
+	assert.Equal(t, "id: 42\ndata: resumed after 41\n\n", readEvent(t, r))
+	assert.Equal(t, ": bye\n\n", readEvent(t, r))
+	_, err := r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF, "stream should end when the handler returns")
+}
+
+func TestHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, r := serve(ctx, Config{Heartbeat: 10 * time.Millisecond}, types.Request{},
+		func(ctx context.Context, req types.Request, stream *Stream) {
+			<-ctx.Done()
+		})
+
+	assert.Equal(t, ":\n\n", readEvent(t, r))
+	assert.Equal(t, ":\n\n", readEvent(t, r))
+}
+
+func TestHandlerStopsWhenContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan struct{})
+	_, r := serve(ctx, Config{Heartbeat: -1}, types.Request{},
+		func(ctx context.Context, req types.Request, stream *Stream) {
+			defer close(returned)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Millisecond):
+					stream.Send(Event{Data: "tick"})
+				}
+			}
+		})
+
+	assert.Equal(t, "data: tick\n\n", readEvent(t, r))
+	cancel()
+	// Keep draining so a pending Send cannot block the handler.
+	go io.Copy(io.Discard, r)
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("handler did not return after the context was canceled")
+	}
+}
+
+func TestHandlerPanicFailsStream(t *testing.T) {
+	_, r := serve(context.Background(), Config{Heartbeat: -1}, types.Request{},
+		func(ctx context.Context, req types.Request, stream *Stream) {
+			stream.Send(Event{Data: "before"})
+			panic("stream bug")
+		})
+
+	assert.Equal(t, "data: before\n\n", readEvent(t, r))
+	_, err := io.ReadAll(r)
+	var pe *types.PanicError
+	require.ErrorAs(t, err, &pe)
+	assert.Equal(t, "stream bug", pe.Value)
+}
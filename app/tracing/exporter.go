@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Exporter sends finished spans to a tracing backend. ExportSpans is called
+// from the goroutine that ended the spans, so it should not block for long.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	// Shutdown flushes pending spans and releases the exporter.
+	Shutdown(ctx context.Context) error
+}
+
+// InMemoryExporter keeps exported spans in memory, for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) ExportSpans(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *InMemoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// Spans returns the spans exported so far, in the order they ended.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.spans)
+}
+
+// Reset discards the recorded spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
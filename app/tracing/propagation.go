@@ -0,0 +1,153 @@
+// Package tracing records request spans and propagates trace context with
+// the W3C traceparent and tracestate headers.
+package tracing
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Header names of W3C Trace Context (https://www.w3.org/TR/trace-context/).
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// maxTracestateMembers is the most list members tracestate may carry.
+const maxTracestateMembers = 32
+
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether id is not all zeroes.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether id is not all zeroes.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// FlagsSampled is the trace flag telling that the caller may have recorded
+// the trace.
+const FlagsSampled byte = 0x01
+
+// SpanContext is the part of a span that propagates to other services.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+	// Remote is set when the span context was received from a caller.
+	Remote bool
+}
+
+// IsValid reports whether sc has a trace and a span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// IsSampled reports whether the sampled flag is set.
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&FlagsSampled != 0
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// Extract returns the span context carried by the traceparent and tracestate
+// header values. tracestate is dropped when it is malformed, and ignored
+// without a valid traceparent.
+func Extract(traceparent, tracestate string) (SpanContext, error) {
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return SpanContext{}, err
+	}
+	sc.TraceState = normalizeTracestate(tracestate)
+	return sc, nil
+}
+
+// ParseTraceparent parses a traceparent header value. Versions after 00 are
+// accepted as long as they start with the version 00 fields.
+func ParseTraceparent(v string) (SpanContext, error) {
+	v = strings.TrimSpace(v)
+	if len(v) < 55 || v[2] != '-' || v[35] != '-' || v[52] != '-' {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	version, ok := parseHex(v[:2], 1)
+	if !ok || version[0] == 0xff {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	if (version[0] == 0 && len(v) != 55) || (len(v) > 55 && v[55] != '-') {
+		return SpanContext{}, errInvalidTraceparent
+	}
+
+	var sc SpanContext
+	traceID, ok := parseHex(v[3:35], 16)
+	if !ok {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	spanID, ok := parseHex(v[36:52], 8)
+	if !ok {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	flags, ok := parseHex(v[53:55], 1)
+	if !ok {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0]
+	sc.Remote = true
+	if !sc.IsValid() {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// parseHex decodes s, which must be n bytes of lowercase hex.
+func parseHex(s string, n int) ([]byte, bool) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// normalizeTracestate trims the list members of a tracestate value, or
+// returns "" when it has too many or one is not a key=value pair.
+func normalizeTracestate(v string) string {
+	var members []string
+	for _, m := range strings.Split(v, ",") {
+		m = strings.TrimSpace(m)
+		if m == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(m, "=")
+		if !ok || key == "" || value == "" || strings.ContainsAny(key, " \t") {
+			return ""
+		}
+		members = append(members, m)
+	}
+	if len(members) > maxTracestateMembers {
+		return ""
+	}
+	return strings.Join(members, ",")
+}
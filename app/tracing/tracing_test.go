@@ -0,0 +1,214 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{"valid", validTraceparent, true},
+		{"surrounding whitespace", " " + validTraceparent + " ", true},
+		{"future version with extra field", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"version 00 with extra field", validTraceparent + "-extra", false},
+		{"version ff", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"uppercase hex", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"bad separator", "00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"too short", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if !tt.valid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+			assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+			assert.True(t, sc.IsSampled())
+			assert.True(t, sc.Remote)
+		})
+	}
+}
+
+func TestExtract_Tracestate(t *testing.T) {
+	sc, err := Extract(validTraceparent, " congo=t61rcWkgMzE ,, rojo=00f067aa0ba902b7")
+	require.NoError(t, err)
+	assert.Equal(t, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", sc.TraceState)
+
+	sc, err = Extract(validTraceparent, "congo")
+	require.NoError(t, err)
+	assert.Empty(t, sc.TraceState, "malformed tracestate is dropped")
+
+	_, err = Extract("garbage", "congo=t61rcWkgMzE")
+	assert.Error(t, err)
+}
+
+func TestSpanContext_TraceparentRoundTrip(t *testing.T) {
+	sc, err := ParseTraceparent(validTraceparent)
+	require.NoError(t, err)
+	assert.Equal(t, validTraceparent, sc.Traceparent())
+}
+
+func TestTracer_StartContinuesRemoteParent(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+	remote, err := Extract(validTraceparent, "rojo=1")
+	require.NoError(t, err)
+
+	ctx, server := tracer.Start(ContextWithRemoteParent(context.Background(), remote), "GET", SpanKindServer)
+	_, child := Start(ctx, "query")
+	child.SetAttribute("rows", 3)
+	child.End()
+	server.SetStatus(StatusError, "boom")
+	server.End()
+	server.End()
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	childData, serverData := spans[0], spans[1]
+
+	assert.Equal(t, remote.TraceID, serverData.SpanContext.TraceID)
+	assert.Equal(t, remote.SpanID, serverData.Parent.SpanID)
+	assert.NotEqual(t, remote.SpanID, serverData.SpanContext.SpanID)
+	assert.Equal(t, "rojo=1", serverData.SpanContext.TraceState)
+	assert.Equal(t, SpanKindServer, serverData.Kind)
+	assert.Equal(t, StatusError, serverData.Status)
+	assert.Equal(t, "boom", serverData.StatusMessage)
+
+	assert.Equal(t, "query", childData.Name)
+	assert.Equal(t, SpanKindInternal, childData.Kind)
+	assert.Equal(t, serverData.SpanContext.SpanID, childData.Parent.SpanID)
+	assert.Equal(t, remote.TraceID, childData.SpanContext.TraceID)
+	assert.Equal(t, 3, childData.Attributes["rows"])
+}
+
+func TestTracer_StartsNewTraceWithoutParent(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	_, span := NewTracer(exporter).Start(context.Background(), "GET", SpanKindServer)
+	span.End()
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 1)
+	assert.True(t, spans[0].SpanContext.IsValid())
+	assert.True(t, spans[0].SpanContext.IsSampled())
+	assert.False(t, spans[0].Parent.IsValid())
+}
+
+func TestTracer_UnsampledParentIsNotExported(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	remote, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	require.NoError(t, err)
+	_, span := NewTracer(exporter).Start(ContextWithRemoteParent(context.Background(), remote), "GET", SpanKindServer)
+	span.End()
+
+	assert.Empty(t, exporter.Spans())
+	assert.Equal(t, remote.TraceID, span.SpanContext().TraceID)
+}
+
+func TestStart_WithoutSpanIsNoop(t *testing.T) {
+	ctx, span := Start(context.Background(), "orphan")
+	assert.Nil(t, span)
+	assert.Nil(t, SpanFromContext(ctx))
+	span.SetAttribute("ignored", true)
+	span.End()
+}
+
+func TestOTLPExporter_SendsBatchOnShutdown(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(OTLPConfig{
+		Endpoint:      collector.URL + "/v1/traces",
+		ServiceName:   "test-service",
+		Headers:       map[string]string{"Authorization": "secret"},
+		FlushInterval: time.Hour,
+	})
+	remote, err := ParseTraceparent(validTraceparent)
+	require.NoError(t, err)
+	_, span := NewTracer(exporter).Start(ContextWithRemoteParent(context.Background(), remote), "GET /files/:path", SpanKindServer)
+	span.SetAttribute("http.response.status_code", 200)
+	span.SetAttribute("http.route", "/files/:path")
+	span.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, exporter.Shutdown(ctx))
+	assert.Error(t, exporter.ExportSpans(ctx, []SpanData{{}}))
+
+	var got struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]any `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []map[string]any `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	require.NoError(t, json.Unmarshal(<-bodies, &got))
+	require.Len(t, got.ResourceSpans, 1)
+	assert.Equal(t, map[string]any{"key": "service.name", "value": map[string]any{"stringValue": "test-service"}},
+		got.ResourceSpans[0].Resource.Attributes[0])
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 1)
+	s := spans[0]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s["traceId"])
+	assert.Equal(t, "00f067aa0ba902b7", s["parentSpanId"])
+	assert.Equal(t, "GET /files/:path", s["name"])
+	assert.Equal(t, float64(SpanKindServer), s["kind"])
+	assert.True(t, strings.Trim(s["startTimeUnixNano"].(string), "0123456789") == "")
+	assert.Equal(t, []any{
+		map[string]any{"key": "http.response.status_code", "value": map[string]any{"intValue": "200"}},
+		map[string]any{"key": "http.route", "value": map[string]any{"stringValue": "/files/:path"}},
+	}, s["attributes"])
+}
+
+func TestOTLPExporter_ReportsCollectorErrors(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	errs := make(chan error, 1)
+	exporter := NewOTLPExporter(OTLPConfig{
+		Endpoint:  collector.URL,
+		BatchSize: 1,
+		OnError:   func(err error) { errs <- err },
+	})
+	defer exporter.Shutdown(context.Background())
+	require.NoError(t, exporter.ExportSpans(context.Background(), []SpanData{{Name: "x"}}))
+
+	select {
+	case err := <-errs:
+		assert.Contains(t, err.Error(), "503")
+	case <-time.After(5 * time.Second):
+		t.Fatal("collector error was not reported")
+	}
+}
@@ -0,0 +1,18 @@
+package types
+
+import "context"
+
+type errorCauseKey struct{}
+
+// WithErrorCause returns a context telling an error handler why the request
+// failed.
+func WithErrorCause(ctx context.Context, err error) context.Context {
+	return context.WithValue(ctx, errorCauseKey{}, err)
+}
+
+// ErrorCause returns the error an error handler was called for, or nil when
+// it is not known.
+func ErrorCause(ctx context.Context) error {
+	err, _ := ctx.Value(errorCauseKey{}).(error)
+	return err
+}
@@ -0,0 +1,42 @@
+package types
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+)
+
+var (
+	// ErrNotHijackable is returned by Hijack when the request arrived on a
+	// connection that cannot be taken over, such as an HTTP/2 stream.
+	ErrNotHijackable = errors.New("connection cannot be hijacked")
+	// ErrHijacked is returned when the connection was already taken over.
+	ErrHijacked = errors.New("connection already hijacked")
+)
+
+// Hijacker hands the connection a request arrived on over to its handler.
+type Hijacker interface {
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+type hijackerKey struct{}
+
+// WithHijacker returns a context through which handlers can hijack the
+// connection using h.
+func WithHijacker(ctx context.Context, h Hijacker) context.Context {
+	return context.WithValue(ctx, hijackerKey{}, h)
+}
+
+// Hijack takes over the connection serving the current request. On success
+// the server writes no response and no longer reads from or closes the
+// connection; the caller owns conn. rw's reader holds any bytes the client
+// sent after the request. The request context is still canceled when the
+// handler returns.
+func Hijack(ctx context.Context) (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := ctx.Value(hijackerKey{}).(Hijacker)
+	if !ok {
+		return nil, nil, ErrNotHijackable
+	}
+	return h.Hijack()
+}
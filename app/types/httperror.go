@@ -0,0 +1,99 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// HTTPError is an error that knows the response it calls for. Message and
+// Fields are shown to the client; Cause is only logged.
+type HTTPError struct {
+	Status  Status
+	Message string
+	// Fields lists problems with individual parts of the request, such as
+	// the fields of a submitted form.
+	Fields []FieldError
+	Cause  error
+}
+
+// FieldError is a problem with one named part of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// NewHTTPError returns an error answered with status and message.
+func NewHTTPError(status Status, message string) *HTTPError {
+	return &HTTPError{Status: status, Message: message}
+}
+
+// WrapHTTPError returns an error answered with status and message that
+// records cause for the logs.
+func WrapHTTPError(cause error, status Status, message string) *HTTPError {
+	return &HTTPError{Status: status, Message: message, Cause: cause}
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%d %s", e.Status.Code(), e.Status.Reason())
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	for _, f := range e.Fields {
+		msg += "; " + f.Field + ": " + f.Message
+	}
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Cause
+}
+
+// FallibleHandler is a handler that reports failure by returning an error
+// instead of filling in an error response itself.
+type FallibleHandler func(ctx context.Context, req Request, res *Response) error
+
+// Handler adapts h to the Handler signature used by routers and middleware.
+// A returned error replaces the response with the one MapError produces for
+// it, whatever h had set.
+func (h FallibleHandler) Handler() Handler {
+	return func(ctx context.Context, req Request, res *Response) {
+		if err := h(ctx, req, res); err != nil {
+			MapError(ctx, req, res, err)
+		}
+	}
+}
+
+// ErrorMapper turns an error returned by a handler into the response for it.
+type ErrorMapper func(ctx context.Context, req Request, res *Response, err error)
+
+type errorMapperKey struct{}
+
+// WithErrorMapper returns a context in which MapError uses m.
+func WithErrorMapper(ctx context.Context, m ErrorMapper) context.Context {
+	return context.WithValue(ctx, errorMapperKey{}, m)
+}
+
+// MapError sets res to the response for err using the mapper in ctx. Without
+// one, an *HTTPError in err's chain gives the status and a plain text body
+// holding its message, and any other error gives a bare 500. The body res
+// had is discarded either way.
+func MapError(ctx context.Context, req Request, res *Response, err error) {
+	res.DiscardBody()
+	if m, ok := ctx.Value(errorMapperKey{}).(ErrorMapper); ok {
+		m(ctx, req, res, err)
+		return
+	}
+	*res = Response{Status: StatusInternalServerError, Headers: make(map[string]string)}
+	var he *HTTPError
+	if errors.As(err, &he) {
+		res.Status = he.Status
+		if he.Message != "" {
+			res.Headers["Content-Type"] = "text/plain; charset=utf-8"
+			res.Body = []byte(he.Message)
+		}
+	}
+}
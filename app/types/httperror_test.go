@@ -0,0 +1,60 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPError(t *testing.T) {
+	err := WrapHTTPError(fs.ErrNotExist, StatusNotFound, "no such file")
+	assert.Equal(t, "404 Not Found: no such file: file does not exist", err.Error())
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.Equal(t, "400 Bad Request", NewHTTPError(StatusBadRequest, "").Error())
+}
+
+func TestFallibleHandler_DefaultMapping(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus Status
+		wantBody   string
+	}{
+		{"no error", nil, StatusCreated, "made"},
+		{"HTTP error", NewHTTPError(StatusForbidden, "keep out"), StatusForbidden, "keep out"},
+		{"wrapped HTTP error", errors.Join(errors.New("ctx"), NewHTTPError(StatusBadRequest, "bad")), StatusBadRequest, "bad"},
+		{"other error", errors.New("disk on fire"), StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := FallibleHandler(func(ctx context.Context, req Request, res *Response) error {
+				res.Status = StatusCreated
+				res.Body = []byte("made")
+				return tt.err
+			}).Handler()
+
+			res := Response{Headers: map[string]string{}}
+			h(context.Background(), Request{}, &res)
+			assert.Equal(t, tt.wantStatus, res.Status)
+			assert.Equal(t, tt.wantBody, string(res.Body))
+		})
+	}
+}
+
+func TestFallibleHandler_UsesMapperFromContext(t *testing.T) {
+	var mapped error
+	ctx := WithErrorMapper(context.Background(), func(ctx context.Context, req Request, res *Response, err error) {
+		mapped = err
+		res.Status = StatusExpectationFailed
+	})
+	boom := errors.New("boom")
+	h := FallibleHandler(func(ctx context.Context, req Request, res *Response) error { return boom }).Handler()
+
+	var res Response
+	h(ctx, Request{}, &res)
+	assert.Equal(t, boom, mapped)
+	assert.Equal(t, StatusExpectationFailed, res.Status)
+}
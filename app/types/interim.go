@@ -0,0 +1,46 @@
+package types
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrInterimNotSupported is returned by WriteInterim when the client
+	// cannot receive interim responses, such as HTTP/1.0 clients.
+	ErrInterimNotSupported = errors.New("interim responses not supported on this connection")
+	// ErrResponseStarted is returned by WriteInterim once the final response
+	// has begun.
+	ErrResponseStarted = errors.New("final response already started")
+
+	errNotInterim = errors.New("not an interim status")
+)
+
+// InterimWriter sends 1xx responses ahead of the final response.
+type InterimWriter interface {
+	WriteInterim(status Status, headers map[string]string) error
+}
+
+type interimWriterKey struct{}
+
+// WithInterimWriter returns a context through which handlers can send
+// interim responses using w.
+func WithInterimWriter(ctx context.Context, w InterimWriter) context.Context {
+	return context.WithValue(ctx, interimWriterKey{}, w)
+}
+
+// WriteInterim sends an informational response, such as 102 Processing or
+// 103 Early Hints with Link headers, before the final response to the
+// current request. It may be called several times while the handler runs.
+// 101 Switching Protocols is not an interim response; protocols are switched
+// with Hijack instead.
+func WriteInterim(ctx context.Context, status Status, headers map[string]string) error {
+	if code := status.Code(); code < 100 || code > 199 || status == StatusSwitchingProtocols {
+		return errNotInterim
+	}
+	w, ok := ctx.Value(interimWriterKey{}).(InterimWriter)
+	if !ok {
+		return ErrInterimNotSupported
+	}
+	return w.WriteInterim(status, headers)
+}
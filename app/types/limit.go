@@ -0,0 +1,32 @@
+package types
+
+import "io"
+
+// LimitReader returns a reader of r that fails with err once more than n
+// bytes have been read. Unlike io.LimitReader, which ends quietly at n bytes,
+// it lets callers tell an oversized body from one that fits.
+func LimitReader(r io.Reader, n int64, err error) io.Reader {
+	return &limitReader{r: r, n: n, err: err}
+}
+
+type limitReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, l.err
+	}
+	// Read one byte past the limit to learn whether the body goes on.
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, l.err
+	}
+	return n, err
+}
@@ -0,0 +1,21 @@
+package types
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitReader(t *testing.T) {
+	errBig := errors.New("too big")
+
+	data, err := io.ReadAll(LimitReader(strings.NewReader("12345"), 5, errBig))
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", string(data))
+
+	_, err = io.ReadAll(LimitReader(strings.NewReader("123456"), 5, errBig))
+	assert.ErrorIs(t, err, errBig)
+}
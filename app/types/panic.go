@@ -0,0 +1,15 @@
+package types
+
+import "fmt"
+
+// PanicError carries a panic recovered on a goroutine working for a handler,
+// such as one streaming a response body, so the server can report it like a
+// panic in the handler itself.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
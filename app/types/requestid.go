@@ -0,0 +1,21 @@
+package types
+
+import "context"
+
+// RequestIDHeader carries the ID that correlates a request across services
+// and log lines.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the ID of the current request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the current request, or "" when the server
+// does not assign request IDs.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
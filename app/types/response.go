@@ -0,0 +1,192 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// httpTimeFormat is the date format of HTTP header fields (RFC 9110 §5.6.7).
+const httpTimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// JSONOption changes how Response.JSON encodes its value.
+type JSONOption int
+
+const (
+	// JSONPretty indents the output by two spaces per level.
+	JSONPretty JSONOption = 1 << iota
+	// JSONStream encodes while the body is sent instead of up front, so
+	// large values are never held in memory as a whole. Encoding errors then
+	// abort the response midway rather than being returned.
+	JSONStream
+)
+
+// JSON sets the response to v encoded as JSON. Unless JSONStream is given,
+// v is encoded before JSON returns, and on failure the response is left
+// unchanged.
+func (r *Response) JSON(status Status, v any, opts ...JSONOption) error {
+	var opt JSONOption
+	for _, o := range opts {
+		opt |= o
+	}
+	encode := func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		if opt&JSONPretty != 0 {
+			enc.SetIndent("", "  ")
+		}
+		return enc.Encode(v)
+	}
+
+	if opt&JSONStream != 0 {
+		pr, pw := io.Pipe()
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					pw.CloseWithError(&PanicError{Value: p, Stack: debug.Stack()})
+				}
+			}()
+			pw.CloseWithError(encode(pw))
+		}()
+		r.Stream(status, "application/json", pr)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return err
+	}
+	r.setBody(status, "application/json", buf.Bytes())
+	return nil
+}
+
+// Text sets the response to a plain text body.
+func (r *Response) Text(status Status, text string) {
+	r.setBody(status, "text/plain; charset=utf-8", []byte(text))
+}
+
+// HTML sets the response to an HTML document.
+func (r *Response) HTML(status Status, html string) {
+	r.setBody(status, "text/html; charset=utf-8", []byte(html))
+}
+
+// Redirect sends the client to url, which may be relative to the request
+// target. status should be one of the 3xx redirect statuses, such as
+// StatusFound or StatusSeeOther.
+func (r *Response) Redirect(status Status, url string) {
+	r.setBody(status, "", nil)
+	r.Headers["Location"] = url
+}
+
+// NoContent sets an empty 204 response.
+func (r *Response) NoContent() {
+	r.setBody(StatusNoContent, "", nil)
+}
+
+// Stream sets the response to stream body, which is closed once sent if it
+// implements io.Closer.
+func (r *Response) Stream(status Status, contentType string, body io.Reader) {
+	r.setBody(status, contentType, nil)
+	r.BodyReader = body
+}
+
+// File streams the named file with a Content-Type guessed from its extension,
+// its size as Content-Length and its modification time as Last-Modified. A missing file or a directory
+// gives a 404 *HTTPError; other failures are returned as they are.
+func (r *Response) File(name string) error {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return WrapHTTPError(err, StatusNotFound, "no such file")
+	}
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if info.IsDir() {
+		f.Close()
+		return WrapHTTPError(fs.ErrNotExist, StatusNotFound, "no such file")
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	r.Stream(StatusOK, contentType, f)
+	r.Headers["Content-Length"] = strconv.FormatInt(info.Size(), 10)
+	r.Headers["Last-Modified"] = info.ModTime().UTC().Format(httpTimeFormat)
+	return nil
+}
+
+// AddVary returns the Vary header value vary with each of names appended
+// unless it is listed already, compared case-insensitively. A value of "*"
+// already covers every field and is returned as it is.
+func AddVary(vary string, names ...string) string {
+	var fields []string
+	for _, f := range strings.Split(vary, ",") {
+		if f = strings.TrimSpace(f); f == "*" {
+			return vary
+		} else if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	out := strings.TrimSpace(vary)
+	for _, name := range names {
+		listed := false
+		for _, f := range fields {
+			if strings.EqualFold(f, name) {
+				listed = true
+				break
+			}
+		}
+		if listed {
+			continue
+		}
+		fields = append(fields, name)
+		if out == "" {
+			out = name
+		} else {
+			out += ", " + name
+		}
+	}
+	return out
+}
+
+// DiscardBody drops the body, closing BodyReader if it implements io.Closer.
+// Code replacing a response that will not be sent calls it, so that open
+// files are closed and goroutines producing the body are released.
+func (r *Response) DiscardBody() {
+	if c, ok := r.BodyReader.(io.Closer); ok {
+		c.Close()
+	}
+	r.Body = nil
+	r.BodyReader = nil
+}
+
+// setBody replaces the status and body, dropping headers that described the
+// previous body.
+func (r *Response) setBody(status Status, contentType string, body []byte) {
+	if r.Headers == nil {
+		r.Headers = make(map[string]string)
+	}
+	r.DiscardBody()
+	r.Status = status
+	r.Body = body
+	delete(r.Headers, "Content-Length")
+	delete(r.Headers, "Content-Encoding")
+	if contentType != "" {
+		r.Headers["Content-Type"] = contentType
+	} else {
+		delete(r.Headers, "Content-Type")
+	}
+}
@@ -0,0 +1,125 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponse_JSON(t *testing.T) {
+	v := map[string]any{"name": "gopher", "tags": []string{"a"}}
+
+	res := Response{Headers: map[string]string{"Content-Length": "99"}}
+	require.NoError(t, res.JSON(StatusCreated, v))
+	assert.Equal(t, StatusCreated, res.Status)
+	assert.Equal(t, "application/json", res.Headers["Content-Type"])
+	assert.NotContains(t, res.Headers, "Content-Length")
+	assert.Equal(t, `{"name":"gopher","tags":["a"]}`+"\n", string(res.Body))
+
+	res = Response{}
+	require.NoError(t, res.JSON(StatusOK, v, JSONPretty))
+	assert.Equal(t, "{\n  \"name\": \"gopher\",\n  \"tags\": [\n    \"a\"\n  ]\n}\n", string(res.Body))
+
+	res = Response{}
+	require.NoError(t, res.JSON(StatusOK, v, JSONStream, JSONPretty))
+	assert.Nil(t, res.Body)
+	require.NotNil(t, res.BodyReader)
+	streamed, err := io.ReadAll(res.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"name\": \"gopher\",\n  \"tags\": [\n    \"a\"\n  ]\n}\n", string(streamed))
+}
+
+func TestResponse_JSONErrors(t *testing.T) {
+	res := Response{Status: StatusOK, Body: []byte("kept")}
+	assert.Error(t, res.JSON(StatusOK, math.Inf(1)))
+	assert.Equal(t, "kept", string(res.Body), "a failed encoding leaves the response alone")
+
+	require.NoError(t, res.JSON(StatusOK, math.Inf(1), JSONStream))
+	_, err := io.ReadAll(res.BodyReader)
+	assert.Error(t, err, "streamed encoding errors abort the body")
+}
+
+func TestResponse_TextHTMLRedirectNoContent(t *testing.T) {
+	var res Response
+	res.Text(StatusOK, "hi")
+	assert.Equal(t, "text/plain; charset=utf-8", res.Headers["Content-Type"])
+	assert.Equal(t, "hi", string(res.Body))
+
+	res.HTML(StatusNotFound, "<p>gone</p>")
+	assert.Equal(t, StatusNotFound, res.Status)
+	assert.Equal(t, "text/html; charset=utf-8", res.Headers["Content-Type"])
+
+	res.Redirect(StatusSeeOther, "/login")
+	assert.Equal(t, StatusSeeOther, res.Status)
+	assert.Equal(t, "/login", res.Headers["Location"])
+	assert.NotContains(t, res.Headers, "Content-Type")
+	assert.Nil(t, res.Body)
+
+	res.NoContent()
+	assert.Equal(t, 204, res.Status.Code())
+	assert.Nil(t, res.Body)
+}
+
+func TestResponse_File(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "page.html")
+	require.NoError(t, os.WriteFile(name, []byte("<h1>hi</h1>"), 0o644))
+
+	var res Response
+	require.NoError(t, res.File(name))
+	assert.Equal(t, StatusOK, res.Status)
+	assert.Equal(t, "text/html; charset=utf-8", res.Headers["Content-Type"])
+	assert.Equal(t, "11", res.Headers["Content-Length"])
+	assert.NotEmpty(t, res.Headers["Last-Modified"])
+	body, err := io.ReadAll(res.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "<h1>hi</h1>", string(body))
+	require.NoError(t, res.BodyReader.(io.Closer).Close())
+
+	for _, missing := range []string{filepath.Join(dir, "nope"), dir} {
+		var he *HTTPError
+		require.True(t, errors.As(res.File(missing), &he), missing)
+		assert.Equal(t, StatusNotFound, he.Status)
+	}
+}
+
+func TestResponse_ReplacingBodyClosesReader(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "data.txt")
+	require.NoError(t, os.WriteFile(name, []byte("data"), 0o644))
+
+	var res Response
+	require.NoError(t, res.File(name))
+	f := res.BodyReader.(*os.File)
+	res.Text(StatusOK, "replaced")
+	assert.ErrorIs(t, f.Close(), os.ErrClosed, "the file is closed when its body is replaced")
+
+	require.NoError(t, res.JSON(StatusOK, []int{1, 2, 3}, JSONStream))
+	pr := res.BodyReader
+	MapError(context.Background(), Request{}, &res, errors.New("boom"))
+	_, err := pr.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.ErrClosedPipe, "the encoder's pipe is closed when an error replaces it")
+}
+
+func TestAddVary(t *testing.T) {
+	tests := []struct {
+		vary  string
+		names []string
+		want  string
+	}{
+		{"", []string{"Accept"}, "Accept"},
+		{"Accept-Language", []string{"Accept-Encoding"}, "Accept-Language, Accept-Encoding"},
+		{"accept-encoding", []string{"Accept-Encoding"}, "accept-encoding"},
+		{"Accept", []string{"Accept-Encoding", "accept", "Accept-Encoding"}, "Accept, Accept-Encoding"},
+		{"*", []string{"Accept"}, "*"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, AddVary(tt.vary, tt.names...), "AddVary(%q, %q)", tt.vary, tt.names)
+	}
+}
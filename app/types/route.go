@@ -0,0 +1,43 @@
+package types
+
+import (
+	"context"
+	"sync"
+)
+
+// routeSlot holds the route pattern matched for a request.
+type routeSlot struct {
+	mu      sync.Mutex
+	pattern string
+}
+
+type routeKey struct{}
+
+// WithRoute returns a context in which the router can record the pattern
+// of the route that matched the request, such as "/files/:path", for the
+// server to read back once the handler has returned.
+func WithRoute(ctx context.Context) context.Context {
+	return context.WithValue(ctx, routeKey{}, &routeSlot{})
+}
+
+// SetRoute records the matched route pattern. It does nothing unless ctx
+// was created by WithRoute.
+func SetRoute(ctx context.Context, pattern string) {
+	if slot, ok := ctx.Value(routeKey{}).(*routeSlot); ok {
+		slot.mu.Lock()
+		slot.pattern = pattern
+		slot.mu.Unlock()
+	}
+}
+
+// Route returns the route pattern recorded for the current request, or ""
+// when no route matched.
+func Route(ctx context.Context) string {
+	slot, ok := ctx.Value(routeKey{}).(*routeSlot)
+	if !ok {
+		return ""
+	}
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	return slot.pattern
+}
@@ -3,12 +3,15 @@ package types
 import (
 	"context"
 	"io"
+	"net/textproto"
+	"strings"
 )
 
 type Method string
 
 const (
 	Get    Method = "GET"
+	Head   Method = "HEAD"
 	Post   Method = "POST"
 	Put    Method = "PUT"
 	Patch  Method = "PATCH"
@@ -17,13 +20,52 @@ const (
 
 type Handler func(ctx context.Context, req Request, res *Response)
 
+// Middleware wraps a Handler with additional behaviour.
+type Middleware func(next Handler) Handler
+
 type Request struct {
 	Method  Method
 	Version string
 	Target  string
 	Headers map[string]string
-	Body    *string
-	Params  map[string]string
+	// Body holds the whole request body when it is small enough for the
+	// server to read before calling the handler: up to 64 KiB announced by
+	// Content-Length, unless the client waits for 100 Continue. It is nil
+	// for larger, chunked or compressed bodies.
+	Body *string
+	// BodyReader streams the request body and is set whenever there is one,
+	// including when Body holds it as well. Bodies not buffered into Body
+	// are read from the client only as they are read here.
+	BodyReader io.Reader
+	Params     map[string]string
+	// Trailers holds the trailer fields of a chunked request body. They are
+	// filled in once BodyReader reaches EOF.
+	Trailers map[string]string
+}
+
+// Header returns the value of the named request header, or "" if it is
+// absent.
+func (r Request) Header(name string) string {
+	v, _ := r.LookupHeader(name)
+	return v
+}
+
+// LookupHeader returns the value of the named request header and whether it
+// is present. The exact key is tried first, then its canonical form, and
+// finally a case-insensitive scan.
+func (r Request) LookupHeader(name string) (string, bool) {
+	if v, ok := r.Headers[name]; ok {
+		return v, true
+	}
+	if v, ok := r.Headers[textproto.CanonicalMIMEHeaderKey(name)]; ok {
+		return v, true
+	}
+	for k, v := range r.Headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
 }
 
 type Status int
@@ -34,11 +76,79 @@ const (
 	StatusBadRequest
 	StatusInternalServerError
 	StatusCreated
+	StatusNotAcceptable
+	StatusUnsupportedMediaType
+	StatusPayloadTooLarge
+	StatusSwitchingProtocols
+	StatusForbidden
+	StatusUpgradeRequired
+	StatusExpectationFailed
+	StatusContinue
+	StatusEarlyHints
+	StatusMethodNotAllowed
+	StatusNoContent
+	StatusMovedPermanently
+	StatusFound
+	StatusSeeOther
+	StatusTemporaryRedirect
+	StatusPermanentRedirect
+	StatusUnprocessableEntity
+	StatusProcessing
+	StatusNotImplemented
+	StatusRequestHeaderFieldsTooLarge
 )
 
+var statusText = map[Status]struct {
+	code   int
+	reason string
+}{
+	StatusOK:                          {200, "OK"},
+	StatusNotFound:                    {404, "Not Found"},
+	StatusBadRequest:                  {400, "Bad Request"},
+	StatusInternalServerError:         {500, "Internal Server Error"},
+	StatusCreated:                     {201, "Created"},
+	StatusNotAcceptable:               {406, "Not Acceptable"},
+	StatusUnsupportedMediaType:        {415, "Unsupported Media Type"},
+	StatusPayloadTooLarge:             {413, "Payload Too Large"},
+	StatusSwitchingProtocols:          {101, "Switching Protocols"},
+	StatusForbidden:                   {403, "Forbidden"},
+	StatusUpgradeRequired:             {426, "Upgrade Required"},
+	StatusExpectationFailed:           {417, "Expectation Failed"},
+	StatusContinue:                    {100, "Continue"},
+	StatusEarlyHints:                  {103, "Early Hints"},
+	StatusMethodNotAllowed:            {405, "Method Not Allowed"},
+	StatusNoContent:                   {204, "No Content"},
+	StatusMovedPermanently:            {301, "Moved Permanently"},
+	StatusFound:                       {302, "Found"},
+	StatusSeeOther:                    {303, "See Other"},
+	StatusTemporaryRedirect:           {307, "Temporary Redirect"},
+	StatusPermanentRedirect:           {308, "Permanent Redirect"},
+	StatusUnprocessableEntity:         {422, "Unprocessable Entity"},
+	StatusProcessing:                  {102, "Processing"},
+	StatusNotImplemented:              {501, "Not Implemented"},
+	StatusRequestHeaderFieldsTooLarge: {431, "Request Header Fields Too Large"},
+}
+
+// Code returns the numeric HTTP status code.
+func (s Status) Code() int {
+	return statusText[s].code
+}
+
+// Reason returns the standard reason phrase for the status.
+func (s Status) Reason() string {
+	return statusText[s].reason
+}
+
 type Response struct {
-	Status     Status
-	Body       []byte
+	Status Status
+	Body   []byte
+	// BodyReader streams the body instead of Body. It is closed once sent if
+	// it implements io.Closer, and the request context is canceled when the
+	// client goes away mid-stream.
 	BodyReader io.Reader
 	Headers    map[string]string
+	// Trailers is called once the body has been sent and returns the fields
+	// to send after it. Only fields announced in the Trailer header are sent.
+	// Setting it makes HTTP/1.1 responses chunked.
+	Trailers func() map[string]string
 }
@@ -0,0 +1,158 @@
+package types
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"maps"
+	"runtime/debug"
+)
+
+// writerBufferSize is how much a ResponseWriter buffers between flushes.
+const writerBufferSize = 4 * 1024
+
+// ResponseWriter builds a response incrementally. It is not safe for
+// concurrent use.
+type ResponseWriter interface {
+	// Header returns the response headers. Changes made after the first
+	// Write or Flush have no effect.
+	Header() map[string]string
+	// WriteHeader sets the response status. Only the first call counts, and
+	// only before anything has been written.
+	WriteHeader(status Status)
+	// Write appends to the body. The first call sends the status and headers
+	// and starts a chunked stream.
+	Write(p []byte) (int, error)
+	// Flush sends buffered body data to the client, and the headers if they
+	// have not gone out yet.
+	Flush() error
+	// Trailer returns the trailer fields sent after the body. Announce their
+	// names in the Trailer header before the first Write; values may be set
+	// until the handler returns.
+	Trailer() map[string]string
+}
+
+// WriterHandler is a handler that writes its response through a
+// ResponseWriter instead of filling in a Response.
+type WriterHandler func(ctx context.Context, req Request, w ResponseWriter)
+
+// Handler adapts h to the Handler signature used by routers and middleware.
+// h runs on its own goroutine; the adapted handler returns once h has
+// written or flushed for the first time, or has returned, and the body
+// keeps streaming from h afterwards.
+func (h WriterHandler) Handler() Handler {
+	return func(ctx context.Context, req Request, res *Response) {
+		if res.Headers == nil {
+			res.Headers = make(map[string]string)
+		}
+		w := &responseWriter{
+			res:       res,
+			header:    res.Headers,
+			status:    res.Status,
+			committed: make(chan struct{}),
+		}
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					w.fail(&PanicError{Value: p, Stack: debug.Stack()})
+				}
+			}()
+			h(ctx, req, w)
+			w.finish()
+		}()
+		<-w.committed
+		if w.panicked != nil {
+			// Nothing was sent yet, so the panic surfaces in the handler.
+			panic(w.panicked)
+		}
+	}
+}
+
+type responseWriter struct {
+	res         *Response
+	header      map[string]string
+	trailer     map[string]string
+	status      Status
+	wroteHeader bool
+
+	committed   chan struct{}
+	isCommitted bool
+	panicked    *PanicError
+	pw          *io.PipeWriter
+	buf         *bufio.Writer
+}
+
+func (w *responseWriter) Header() map[string]string {
+	return w.header
+}
+
+func (w *responseWriter) Trailer() map[string]string {
+	if w.trailer == nil {
+		w.trailer = make(map[string]string)
+	}
+	return w.trailer
+}
+
+func (w *responseWriter) WriteHeader(status Status) {
+	if w.wroteHeader || w.isCommitted {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.commit(true)
+	return w.buf.Write(p)
+}
+
+func (w *responseWriter) Flush() error {
+	w.commit(true)
+	return w.buf.Flush()
+}
+
+// commit fixes the status and headers and hands the response to the server.
+func (w *responseWriter) commit(withBody bool) {
+	if w.isCommitted {
+		return
+	}
+	w.isCommitted = true
+	w.res.Status = w.status
+	w.res.Headers = maps.Clone(w.header)
+	w.res.Body = nil
+	if _, ok := w.header["Trailer"]; ok {
+		// Only read once the body is finished, after the handler returned.
+		w.res.Trailers = func() map[string]string { return w.trailer }
+	}
+	if withBody {
+		pr, pw := io.Pipe()
+		w.pw = pw
+		w.buf = bufio.NewWriterSize(pw, writerBufferSize)
+		w.res.BodyReader = pr
+	}
+	close(w.committed)
+}
+
+// finish ends the body once the handler returns.
+func (w *responseWriter) finish() {
+	if !w.isCommitted {
+		w.commit(false)
+		return
+	}
+	w.buf.Flush()
+	w.pw.Close()
+}
+
+// fail ends the response after h panicked: before anything was committed the
+// panic is handed to the adapted handler, afterwards the body stream fails.
+func (w *responseWriter) fail(p *PanicError) {
+	if !w.isCommitted {
+		w.isCommitted = true
+		w.panicked = p
+		close(w.committed)
+		return
+	}
+	if w.pw != nil {
+		w.pw.CloseWithError(p)
+	}
+}
@@ -0,0 +1,96 @@
+package types
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runWriterHandler(h WriterHandler) Response {
+	res := Response{Status: StatusOK, Headers: map[string]string{"X-Preset": "1"}}
+	h.Handler()(context.Background(), Request{}, &res)
+	return res
+}
+
+func TestWriterHandler_StreamsBody(t *testing.T) {
+	res := runWriterHandler(func(ctx context.Context, req Request, w ResponseWriter) {
+		w.Header()["Content-Type"] = "text/plain"
+		w.WriteHeader(StatusCreated)
+		w.WriteHeader(StatusNotFound)
+		w.Write([]byte("hello, "))
+		w.Header()["X-Late"] = "ignored"
+		w.Write([]byte("world"))
+	})
+
+	assert.Equal(t, StatusCreated, res.Status)
+	assert.Equal(t, map[string]string{"X-Preset": "1", "Content-Type": "text/plain"}, res.Headers)
+	require.NotNil(t, res.BodyReader)
+	body, err := io.ReadAll(res.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(body))
+}
+
+func TestWriterHandler_FlushSendsHeadersEarly(t *testing.T) {
+	proceed := make(chan struct{})
+	res := runWriterHandler(func(ctx context.Context, req Request, w ResponseWriter) {
+		w.Write([]byte("first"))
+		require.NoError(t, w.Flush())
+		<-proceed
+		w.Write([]byte(" second"))
+	})
+	require.NotNil(t, res.BodyReader)
+
+	buf := make([]byte, len("first"))
+	_, err := io.ReadFull(res.BodyReader, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(buf))
+
+	close(proceed)
+	rest, err := io.ReadAll(res.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, " second", string(rest))
+}
+
+func TestWriterHandler_NoWrites(t *testing.T) {
+	res := runWriterHandler(func(ctx context.Context, req Request, w ResponseWriter) {
+		w.WriteHeader(StatusNotFound)
+	})
+	assert.Equal(t, StatusNotFound, res.Status)
+	assert.Nil(t, res.BodyReader)
+	assert.Nil(t, res.Body)
+}
+
+func TestWriterHandler_WriteFailsAfterReaderClosed(t *testing.T) {
+	errs := make(chan error, 1)
+	res := runWriterHandler(func(ctx context.Context, req Request, w ResponseWriter) {
+		w.Write([]byte("x"))
+		w.Flush()
+		w.Write([]byte("y"))
+		errs <- w.Flush()
+	})
+	buf := make([]byte, 1)
+	_, err := io.ReadFull(res.BodyReader, buf)
+	require.NoError(t, err)
+	res.BodyReader.(io.Closer).Close()
+	assert.ErrorIs(t, <-errs, io.ErrClosedPipe)
+}
+
+func TestWriterHandler_Trailers(t *testing.T) {
+	res := runWriterHandler(func(ctx context.Context, req Request, w ResponseWriter) {
+		w.Header()["Trailer"] = "X-Checksum"
+		w.Write([]byte("data"))
+		w.Trailer()["X-Checksum"] = "4"
+	})
+	require.NotNil(t, res.Trailers)
+	_, err := io.ReadAll(res.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"X-Checksum": "4"}, res.Trailers())
+
+	res = runWriterHandler(func(ctx context.Context, req Request, w ResponseWriter) {
+		w.Write([]byte("data"))
+	})
+	assert.Nil(t, res.Trailers, "no trailers without a Trailer header")
+}
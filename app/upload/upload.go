@@ -0,0 +1,248 @@
+// Package upload reads multipart/form-data request bodies part by part, so
+// uploads far larger than memory can be handled.
+//
+// Parts are read straight from the request body stream. A part can also be
+// spooled: kept in memory up to a threshold and written to a temporary file
+// beyond it. Temporary files are removed when the request context ends.
+//
+// The server reads request bodies from the client only as the handler does,
+// so a part is received while it is being read.
+package upload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// DefaultMemoryThreshold is the MemoryThreshold of a zero Config.
+const DefaultMemoryThreshold = 1 << 20
+
+var (
+	// ErrNotMultipart is returned by NewReader for requests whose body is
+	// not multipart/form-data.
+	ErrNotMultipart = types.NewHTTPError(types.StatusUnsupportedMediaType, "expected multipart/form-data")
+	// ErrPartTooLarge is returned once a part exceeds MaxPartSize.
+	ErrPartTooLarge = types.NewHTTPError(types.StatusPayloadTooLarge, "part too large")
+	// ErrTooLarge is returned once the body exceeds MaxTotalSize.
+	ErrTooLarge = types.NewHTTPError(types.StatusPayloadTooLarge, "body too large")
+	// ErrTooManyParts is returned by NextPart past MaxParts parts.
+	ErrTooManyParts = types.NewHTTPError(types.StatusPayloadTooLarge, "too many parts")
+)
+
+// Config limits a Reader. Zero limits mean no limit.
+type Config struct {
+	// MemoryThreshold is the size up to which a spooled part stays in
+	// memory. Zero means DefaultMemoryThreshold.
+	MemoryThreshold int64
+	// MaxPartSize limits the content of each part, in bytes.
+	MaxPartSize int64
+	// MaxTotalSize limits the whole body, in bytes.
+	MaxTotalSize int64
+	// MaxParts limits the number of parts.
+	MaxParts int
+	// TempDir is where spooled parts are written; empty means os.TempDir.
+	TempDir string
+}
+
+// Reader reads the parts of a multipart/form-data request body in order.
+// It is not safe for concurrent use.
+type Reader struct {
+	cfg     Config
+	mr      *multipart.Reader
+	current *Part
+	parts   int
+
+	mu    sync.Mutex
+	temps []string
+}
+
+// NewReader returns a Reader over req's body. Files spooled by its parts
+// are removed once ctx is done.
+func NewReader(ctx context.Context, req types.Request, cfg Config) (*Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(req.Header("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, types.NewHTTPError(types.StatusBadRequest, "multipart body without boundary")
+	}
+
+	var body io.Reader = strings.NewReader("")
+	switch {
+	case req.BodyReader != nil:
+		body = req.BodyReader
+	case req.Body != nil:
+		body = strings.NewReader(*req.Body)
+	}
+	if cfg.MaxTotalSize > 0 {
+		body = types.LimitReader(body, cfg.MaxTotalSize, ErrTooLarge)
+	}
+	if cfg.MemoryThreshold <= 0 {
+		cfg.MemoryThreshold = DefaultMemoryThreshold
+	}
+
+	r := &Reader{cfg: cfg, mr: multipart.NewReader(body, boundary)}
+	context.AfterFunc(ctx, r.RemoveAll)
+	return r, nil
+}
+
+// NextPart returns the next part, skipping whatever is left of the previous
+// one, or io.EOF when there are no more.
+func (r *Reader) NextPart() (*Part, error) {
+	if r.current != nil {
+		if _, err := io.Copy(io.Discard, r.current); err != nil {
+			return nil, err
+		}
+	}
+	if r.cfg.MaxParts > 0 && r.parts >= r.cfg.MaxParts {
+		return nil, ErrTooManyParts
+	}
+	p, err := r.mr.NextPart()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, bodyError(err)
+	}
+	r.parts++
+	r.current = &Part{
+		FormName: p.FormName(),
+		FileName: p.FileName(),
+		Header:   p.Header,
+		r:        r,
+		p:        p,
+	}
+	return r.current, nil
+}
+
+// RemoveAll removes the files spooled so far. It is called when the request
+// context ends, and is safe to call more than once.
+func (r *Reader) RemoveAll() {
+	r.mu.Lock()
+	temps := r.temps
+	r.temps = nil
+	r.mu.Unlock()
+	for _, name := range temps {
+		os.Remove(name)
+	}
+}
+
+func (r *Reader) track(name string) {
+	r.mu.Lock()
+	r.temps = append(r.temps, name)
+	r.mu.Unlock()
+}
+
+// Part is one part of the body. Reading it streams the part's content from
+// the request.
+type Part struct {
+	FormName string
+	// FileName is the client's name for an uploaded file, or "" for plain
+	// form fields.
+	FileName string
+	Header   textproto.MIMEHeader
+
+	r    *Reader
+	p    *multipart.Part
+	read int64
+}
+
+func (p *Part) Read(b []byte) (int, error) {
+	limit := p.r.cfg.MaxPartSize
+	if limit > 0 && int64(len(b)) > limit-p.read+1 {
+		b = b[:limit-p.read+1]
+	}
+	n, err := p.p.Read(b)
+	p.read += int64(n)
+	if limit > 0 && p.read > limit {
+		return n, ErrPartTooLarge
+	}
+	if err != nil && err != io.EOF {
+		err = bodyError(err)
+	}
+	return n, err
+}
+
+// Spool reads the rest of the part, keeping it in memory up to the
+// Reader's MemoryThreshold and in a temporary file beyond it.
+func (p *Part) Spool() (*SpooledPart, error) {
+	sp := &SpooledPart{FormName: p.FormName, FileName: p.FileName, Header: p.Header}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, p, p.r.cfg.MemoryThreshold+1)
+	if err == io.EOF {
+		sp.data, sp.Size = buf.Bytes(), n
+		return sp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(p.r.cfg.TempDir, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	p.r.track(f.Name())
+	defer f.Close()
+	written, err := io.Copy(f, io.MultiReader(&buf, p))
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	sp.path, sp.Size = f.Name(), written
+	return sp, nil
+}
+
+// SpooledPart is a part read in full by Part.Spool.
+type SpooledPart struct {
+	FormName string
+	FileName string
+	Header   textproto.MIMEHeader
+	Size     int64
+
+	data []byte
+	path string
+}
+
+// Path returns the temporary file holding the part, or "" when it is held
+// in memory. The file is removed when the request ends, so keep it by
+// moving it elsewhere.
+func (sp *SpooledPart) Path() string {
+	return sp.path
+}
+
+// Open returns the content of the part.
+func (sp *SpooledPart) Open() (multipart.File, error) {
+	if sp.path == "" {
+		return nopCloser{bytes.NewReader(sp.data)}, nil
+	}
+	return os.Open(sp.path)
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// bodyError turns a failure to parse the body into an HTTP error, keeping
+// the limit errors as they are.
+func bodyError(err error) error {
+	var he *types.HTTPError
+	if errors.As(err, &he) {
+		return err
+	}
+	return types.WrapHTTPError(err, types.StatusBadRequest, "malformed multipart body")
+}
@@ -0,0 +1,158 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// form builds a multipart request from alternating field names and values;
+// names starting with "@" become file parts.
+func form(t *testing.T, fields ...string) types.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i := 0; i < len(fields); i += 2 {
+		name, value := fields[i], fields[i+1]
+		var w io.Writer
+		var err error
+		if file, ok := strings.CutPrefix(name, "@"); ok {
+			w, err = mw.CreateFormFile(file, file+".bin")
+		} else {
+			w, err = mw.CreateFormField(name)
+		}
+		require.NoError(t, err)
+		io.WriteString(w, value)
+	}
+	require.NoError(t, mw.Close())
+	return types.Request{
+		Method:     types.Post,
+		Headers:    map[string]string{"Content-Type": mw.FormDataContentType()},
+		BodyReader: bytes.NewReader(body.Bytes()),
+	}
+}
+
+func TestReader_StreamsParts(t *testing.T) {
+	r, err := NewReader(context.Background(), form(t, "title", "hello", "@doc", "contents", "skipped", "x"), Config{})
+	require.NoError(t, err)
+
+	p, err := r.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", p.FormName)
+	assert.Empty(t, p.FileName)
+	data, err := io.ReadAll(p)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	p, err = r.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "doc", p.FormName)
+	assert.Equal(t, "doc.bin", p.FileName)
+	head := make([]byte, 3)
+	_, err = io.ReadFull(p, head)
+	require.NoError(t, err)
+	assert.Equal(t, "con", string(head))
+
+	p, err = r.NextPart()
+	require.NoError(t, err, "the rest of the previous part is skipped")
+	assert.Equal(t, "skipped", p.FormName)
+
+	_, err = r.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPart_SpoolsLargePartsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	large := strings.Repeat("z", 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := NewReader(ctx, form(t, "small", "tiny", "@large", large), Config{MemoryThreshold: 10, TempDir: dir})
+	require.NoError(t, err)
+
+	p, err := r.NextPart()
+	require.NoError(t, err)
+	small, err := p.Spool()
+	require.NoError(t, err)
+	assert.Empty(t, small.Path(), "parts under the threshold stay in memory")
+	assert.Equal(t, int64(4), small.Size)
+
+	p, err = r.NextPart()
+	require.NoError(t, err)
+	sp, err := p.Spool()
+	require.NoError(t, err)
+	require.NotEmpty(t, sp.Path())
+	assert.Equal(t, dir, sp.Path()[:len(dir)])
+	assert.Equal(t, int64(100), sp.Size)
+	f, err := sp.Open()
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, large, string(data))
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(sp.Path())
+		return errors.Is(err, os.ErrNotExist)
+	}, 5*time.Second, 10*time.Millisecond, "spooled files are removed when the request ends")
+}
+
+func TestReader_Limits(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr error
+	}{
+		{"part size", Config{MaxPartSize: 5}, ErrPartTooLarge},
+		{"total size", Config{MaxTotalSize: 100}, ErrTooLarge},
+		{"part count", Config{MaxParts: 1}, ErrTooManyParts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(context.Background(), form(t, "a", "12345", "@b", strings.Repeat("x", 200)), tt.cfg)
+			require.NoError(t, err)
+			var firstErr error
+			for firstErr == nil {
+				var p *Part
+				if p, firstErr = r.NextPart(); firstErr == nil {
+					_, firstErr = p.Spool()
+				}
+			}
+			assert.ErrorIs(t, firstErr, tt.wantErr)
+			var he *types.HTTPError
+			require.ErrorAs(t, firstErr, &he)
+			assert.Equal(t, types.StatusPayloadTooLarge, he.Status)
+		})
+	}
+}
+
+func TestNewReader_Errors(t *testing.T) {
+	req := types.Request{Headers: map[string]string{"Content-Type": "application/json"}}
+	_, err := NewReader(context.Background(), req, Config{})
+	assert.Equal(t, ErrNotMultipart, err)
+
+	req.Headers["Content-Type"] = "multipart/form-data"
+	_, err = NewReader(context.Background(), req, Config{})
+	var he *types.HTTPError
+	require.ErrorAs(t, err, &he)
+	assert.Equal(t, types.StatusBadRequest, he.Status)
+
+	req.Headers["Content-Type"] = "multipart/form-data; boundary=xyz"
+	req.BodyReader = strings.NewReader("--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\ntrunc")
+	r, err := NewReader(context.Background(), req, Config{})
+	require.NoError(t, err)
+	p, err := r.NextPart()
+	require.NoError(t, err)
+	_, err = io.ReadAll(p)
+	require.ErrorAs(t, err, &he)
+	assert.Equal(t, types.StatusBadRequest, he.Status)
+}
@@ -0,0 +1,77 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+)
+
+// deflateTail is the end of a sync flush, which permessage-deflate strips from
+// every message (RFC 7692 §7.2.1).
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// negotiateDeflate inspects a Sec-WebSocket-Extensions offer and returns the
+// permessage-deflate response, or "" when compression cannot be used. Both
+// sides are asked to reset their compression context after each message,
+// which keeps per-connection memory small.
+func negotiateDeflate(offer string) string {
+	for _, ext := range strings.Split(offer, ",") {
+		params := strings.Split(ext, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		ok := true
+		for _, p := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+			switch strings.TrimSpace(name) {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				// compress/flate always uses a 32 KiB window.
+				if strings.Trim(strings.TrimSpace(value), `"`) != "15" {
+					ok = false
+				}
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			return "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+		}
+	}
+	return ""
+}
+
+func compressMessage(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+// decompressMessage inflates a message, failing with CloseMessageTooBig once
+// the output exceeds limit bytes.
+func decompressMessage(data []byte, limit int64) ([]byte, error) {
+	// Restore the stripped sync flush and add a final empty stored block so
+	// the reader sees a complete stream.
+	src := io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail), bytes.NewReader([]byte{0x01, 0x00, 0x00, 0xff, 0xff}))
+	r := flate.NewReader(src)
+	defer r.Close()
+
+	out, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, &CloseError{Code: CloseInvalidPayload, Reason: "invalid compressed payload"}
+	}
+	if int64(len(out)) > limit {
+		return nil, &CloseError{Code: CloseMessageTooBig, Reason: "message too big"}
+	}
+	return out, nil
+}
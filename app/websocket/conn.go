@@ -0,0 +1,318 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"unicode/utf8"
+)
+
+// MessageType distinguishes text from binary data messages.
+type MessageType int
+
+const (
+	TextMessage   MessageType = MessageType(opText)
+	BinaryMessage MessageType = MessageType(opBinary)
+)
+
+// Close codes (RFC 6455 §7.4.1).
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+// minCompressSize is the smallest message worth compressing.
+const minCompressSize = 64
+
+// CloseError is returned by ReadMessage once the connection is closing,
+// either because the peer sent a close frame or because it violated the
+// protocol.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: close %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: close %d: %s", e.Code, e.Reason)
+}
+
+// Conn is a server-side WebSocket connection. One goroutine may read and
+// one may write at a time; control frames sent by ReadMessage are safe to
+// interleave with writes.
+type Conn struct {
+	conn             net.Conn
+	br               *bufio.Reader
+	subprotocol      string
+	compress         bool
+	compressionLevel int
+	readLimit        int64
+
+	wmu       sync.Mutex
+	closeSent bool
+
+	pongHandler func(data []byte)
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, subprotocol string, compress bool, level int, readLimit int64) *Conn {
+	return &Conn{
+		conn:             conn,
+		br:               br,
+		subprotocol:      subprotocol,
+		compress:         compress,
+		compressionLevel: level,
+		readLimit:        readLimit,
+	}
+}
+
+// Subprotocol returns the negotiated subprotocol, if any.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Compressed reports whether permessage-deflate was negotiated.
+func (c *Conn) Compressed() bool {
+	return c.compress
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// SetPongHandler sets a function called with the payload of every pong.
+func (c *Conn) SetPongHandler(h func(data []byte)) {
+	c.pongHandler = h
+}
+
+// ReadMessage returns the next data message, reassembling fragments and
+// answering pings along the way. When the peer closes the connection it
+// replies with a close frame and returns a *CloseError.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		inMessage  bool
+		msgType    MessageType
+		compressed bool
+		buf        []byte
+	)
+	for {
+		f, err := readFrame(c.br, c.readLimit)
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		if isControl(f.opcode) {
+			if f.rsv1 {
+				return 0, nil, c.fail(protocolError("compressed control frame"))
+			}
+			switch f.opcode {
+			case opPing:
+				if err := c.writeFrame(opPong, true, false, f.payload); err != nil && !errors.Is(err, errWriteClosed) {
+					return 0, nil, err
+				}
+			case opPong:
+				if c.pongHandler != nil {
+					c.pongHandler(f.payload)
+				}
+			case opClose:
+				ce, err := parseClosePayload(f.payload)
+				if err != nil {
+					return 0, nil, c.fail(err)
+				}
+				if ce.Code != CloseNoStatusReceived && !utf8.ValidString(ce.Reason) {
+					return 0, nil, c.fail(&CloseError{Code: CloseInvalidPayload, Reason: "invalid close reason"})
+				}
+				c.WriteClose(ce.Code, "")
+				return 0, nil, ce
+			default:
+				return 0, nil, c.fail(protocolError("unknown control opcode"))
+			}
+			continue
+		}
+
+		switch f.opcode {
+		case opContinuation:
+			if !inMessage {
+				return 0, nil, c.fail(protocolError("continuation without message"))
+			}
+			if f.rsv1 {
+				return 0, nil, c.fail(protocolError("RSV1 on continuation frame"))
+			}
+		case opText, opBinary:
+			if inMessage {
+				return 0, nil, c.fail(protocolError("new message before previous finished"))
+			}
+			if f.rsv1 && !c.compress {
+				return 0, nil, c.fail(protocolError("RSV1 set without permessage-deflate"))
+			}
+			inMessage, msgType, compressed = true, MessageType(f.opcode), f.rsv1
+		default:
+			return 0, nil, c.fail(protocolError("unknown data opcode"))
+		}
+
+		if int64(len(buf)+len(f.payload)) > c.readLimit {
+			return 0, nil, c.fail(&CloseError{Code: CloseMessageTooBig, Reason: "message too big"})
+		}
+		buf = append(buf, f.payload...)
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			if buf, err = decompressMessage(buf, c.readLimit); err != nil {
+				return 0, nil, c.fail(err)
+			}
+		}
+		if msgType == TextMessage && !utf8.Valid(buf) {
+			return 0, nil, c.fail(&CloseError{Code: CloseInvalidPayload, Reason: "invalid UTF-8"})
+		}
+		return msgType, buf, nil
+	}
+}
+
+// fail closes the connection with the code carried by a *CloseError, and
+// returns err unchanged.
+func (c *Conn) fail(err error) error {
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		c.WriteClose(ce.Code, ce.Reason)
+	}
+	return err
+}
+
+// WriteMessage sends data as a single frame, compressing it when
+// permessage-deflate was negotiated.
+func (c *Conn) WriteMessage(t MessageType, data []byte) error {
+	if c.compress && len(data) >= minCompressSize {
+		compressed, err := compressMessage(data, c.compressionLevel)
+		if err != nil {
+			return err
+		}
+		return c.writeFrame(byte(t), true, true, compressed)
+	}
+	return c.writeFrame(byte(t), true, false, data)
+}
+
+// NextWriter returns a writer for a fragmented message: every Write is sent
+// as one frame and Close finishes the message.
+func (c *Conn) NextWriter(t MessageType) (io.WriteCloser, error) {
+	w := &messageWriter{c: c, opcode: byte(t)}
+	if c.compress {
+		fw, err := flate.NewWriter(&w.buf, c.compressionLevel)
+		if err != nil {
+			return nil, err
+		}
+		w.flate = fw
+	}
+	return w, nil
+}
+
+// Ping sends a ping frame.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: ping payload too large")
+	}
+	return c.writeFrame(opPing, true, false, data)
+}
+
+// WriteClose starts the closing handshake. Keep calling ReadMessage until it
+// returns a *CloseError to receive the peer's reply.
+func (c *Conn) WriteClose(code int, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return errWriteClosed
+	}
+	c.closeSent = true
+	_, err := c.conn.Write(appendFrame(nil, opClose, true, false, closePayload(code, reason)))
+	return err
+}
+
+func (c *Conn) writeFrame(opcode byte, fin, rsv1 bool, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return errWriteClosed
+	}
+	_, err := c.conn.Write(appendFrame(nil, opcode, fin, rsv1, payload))
+	return err
+}
+
+type messageWriter struct {
+	c       *Conn
+	opcode  byte
+	started bool
+	closed  bool
+
+	flate *flate.Writer
+	buf   bytes.Buffer
+	// held keeps back the last bytes of compressed output, which must be
+	// dropped if they turn out to end the message.
+	held []byte
+}
+
+func (w *messageWriter) nextOpcode() byte {
+	if w.started {
+		return opContinuation
+	}
+	return w.opcode
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("websocket: write to closed message writer")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	payload := p
+	if w.flate != nil {
+		if _, err := w.flate.Write(p); err != nil {
+			return 0, err
+		}
+		if err := w.flate.Flush(); err != nil {
+			return 0, err
+		}
+		out := append(w.held, w.buf.Bytes()...)
+		w.buf.Reset()
+		cut := len(out) - len(deflateTail)
+		payload, w.held = out[:cut], append([]byte(nil), out[cut:]...)
+	}
+	if err := w.c.writeFrame(w.nextOpcode(), false, w.flate != nil && !w.started, payload); err != nil {
+		return 0, err
+	}
+	w.started = true
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	// The held bytes are the sync flush marker that permessage-deflate
+	// strips from the end of every message.
+	compressedStart := w.flate != nil && !w.started
+	var payload []byte
+	if compressedStart {
+		var err error
+		if payload, err = compressMessage(nil, w.c.compressionLevel); err != nil {
+			return err
+		}
+	}
+	return w.c.writeFrame(w.nextOpcode(), true, compressedStart, payload)
+}
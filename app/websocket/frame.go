@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Opcodes (RFC 6455 §5.2).
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xa
+)
+
+const (
+	finBit  byte = 0x80
+	rsv1Bit byte = 0x40
+	rsv2Bit byte = 0x20
+	rsv3Bit byte = 0x10
+	maskBit byte = 0x80
+
+	maxControlPayload = 125
+)
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	payload []byte
+}
+
+func isControl(opcode byte) bool {
+	return opcode&0x8 != 0
+}
+
+// readFrame reads a single client frame. Client frames must be masked;
+// payloads longer than limit are rejected without being read.
+func readFrame(r io.Reader, limit int64) (frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		fin:    head[0]&finBit != 0,
+		rsv1:   head[0]&rsv1Bit != 0,
+		opcode: head[0] & 0x0f,
+	}
+	if head[0]&(rsv2Bit|rsv3Bit) != 0 {
+		return f, protocolError("reserved bits set")
+	}
+	if head[1]&maskBit == 0 {
+		return f, protocolError("client frame not masked")
+	}
+
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return f, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return f, err
+		}
+		if ext[0]&0x80 != 0 {
+			return f, protocolError("payload length overflows")
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	if isControl(f.opcode) {
+		if !f.fin {
+			return f, protocolError("fragmented control frame")
+		}
+		if length > maxControlPayload {
+			return f, protocolError("control frame too large")
+		}
+	}
+	if length > limit {
+		return f, &CloseError{Code: CloseMessageTooBig, Reason: "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return f, err
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return f, err
+	}
+	maskBytes(mask, f.payload)
+	return f, nil
+}
+
+// appendFrame encodes an unmasked server frame.
+func appendFrame(dst []byte, opcode byte, fin, rsv1 bool, payload []byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= finBit
+	}
+	if rsv1 {
+		b0 |= rsv1Bit
+	}
+	dst = append(dst, b0)
+	switch n := len(payload); {
+	case n <= 125:
+		dst = append(dst, byte(n))
+	case n <= 0xffff:
+		dst = append(dst, 126)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(n))
+	default:
+		dst = append(dst, 127)
+		dst = binary.BigEndian.AppendUint64(dst, uint64(n))
+	}
+	return append(dst, payload...)
+}
+
+func maskBytes(mask [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= mask[i%4]
+	}
+}
+
+func protocolError(reason string) error {
+	return &CloseError{Code: CloseProtocolError, Reason: reason}
+}
+
+var errWriteClosed = errors.New("websocket: close frame already sent")
+
+func closePayload(code int, reason string) []byte {
+	if code == CloseNoStatusReceived {
+		return nil
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	return append(payload, reason...)
+}
+
+func parseClosePayload(payload []byte) (*CloseError, error) {
+	if len(payload) == 0 {
+		return &CloseError{Code: CloseNoStatusReceived}, nil
+	}
+	if len(payload) == 1 {
+		return nil, protocolError("invalid close payload")
+	}
+	code := int(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) {
+		return nil, protocolError(fmt.Sprintf("invalid close code %d", code))
+	}
+	return &CloseError{Code: code, Reason: string(payload[2:])}, nil
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package websocket
+
+import (
+	"bufio"
+	"compress/flate"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+)
+
+// acceptGUID is the fixed GUID used to derive Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// defaultReadLimit bounds incoming messages unless Upgrader.ReadLimit is set.
+const defaultReadLimit = 16 << 20
+
+// Handler serves an upgraded WebSocket connection. The connection is closed
+// when it returns.
+type Handler func(ctx context.Context, req types.Request, conn *Conn)
+
+// Upgrader validates WebSocket handshakes (RFC 6455 §4.2) and switches the
+// connection over to a Handler. The connection is taken over with
+// types.Hijack, so only HTTP/1.1 requests can be upgraded.
+type Upgrader struct {
+	// Subprotocols lists the supported subprotocols in order of preference.
+	Subprotocols []string
+	// CheckOrigin decides whether to accept the request's Origin. When nil,
+	// only same-origin requests and clients that send no Origin are accepted.
+	CheckOrigin func(req types.Request) bool
+	// EnableCompression negotiates permessage-deflate when the client offers
+	// it.
+	EnableCompression bool
+	// CompressionLevel is the flate level for outgoing messages. Zero means
+	// flate.DefaultCompression.
+	CompressionLevel int
+	// ReadLimit is the maximum size of an incoming message in bytes. Zero
+	// or negative means 16 MiB; messages are always bounded, since frame
+	// lengths come from the peer.
+	ReadLimit int64
+}
+
+// Handler returns a types.Handler that performs the handshake and runs h on
+// the upgraded connection. h runs within the returned handler, so the
+// request context lasts as long as the WebSocket connection.
+func (u Upgrader) Handler(h Handler) types.Handler {
+	return func(ctx context.Context, req types.Request, res *types.Response) {
+		if res.Headers == nil {
+			res.Headers = make(map[string]string)
+		}
+		key, status, err := u.validate(req)
+		if err != nil {
+			res.Status = status
+			res.Headers["Content-Type"] = "text/plain"
+			if status == types.StatusUpgradeRequired {
+				res.Headers["Sec-WebSocket-Version"] = "13"
+				res.Headers["Upgrade"] = "websocket"
+			}
+			res.Body = []byte(err.Error())
+			return
+		}
+
+		res.Status = types.StatusSwitchingProtocols
+		res.Headers["Upgrade"] = "websocket"
+		res.Headers["Connection"] = "Upgrade"
+		res.Headers["Sec-WebSocket-Accept"] = acceptKey(key)
+
+		subprotocol := u.selectSubprotocol(req.Header("Sec-WebSocket-Protocol"))
+		if subprotocol != "" {
+			res.Headers["Sec-WebSocket-Protocol"] = subprotocol
+		}
+		compress := false
+		if u.EnableCompression {
+			if ext := negotiateDeflate(req.Header("Sec-WebSocket-Extensions")); ext != "" {
+				res.Headers["Sec-WebSocket-Extensions"] = ext
+				compress = true
+			}
+		}
+
+		level := u.CompressionLevel
+		if level == 0 {
+			level = flate.DefaultCompression
+		}
+		readLimit := u.ReadLimit
+		if readLimit <= 0 {
+			readLimit = defaultReadLimit
+		}
+		if id := types.RequestID(ctx); id != "" {
+			res.Headers[types.RequestIDHeader] = id
+		}
+
+		conn, rw, err := types.Hijack(ctx)
+		if err != nil {
+			res.Status = types.StatusInternalServerError
+			res.Headers = map[string]string{"Content-Type": "text/plain"}
+			res.Body = []byte("websocket: " + err.Error())
+			return
+		}
+		defer conn.Close()
+		if err := writeHandshake(rw.Writer, *res); err != nil {
+			return
+		}
+		h(ctx, req, newConn(conn, rw.Reader, subprotocol, compress, level, readLimit))
+	}
+}
+
+// writeHandshake sends the 101 response accepting the upgrade.
+func writeHandshake(w *bufio.Writer, res types.Response) error {
+	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", res.Status.Code(), res.Status.Reason())
+	for k, v := range res.Headers {
+		fmt.Fprintf(w, "%s: %s\r\n", k, v)
+	}
+	w.WriteString("\r\n")
+	return w.Flush()
+}
+
+func (u Upgrader) validate(req types.Request) (string, types.Status, error) {
+	if req.Method != types.Get || req.Version != "HTTP/1.1" {
+		return "", types.StatusBadRequest, errors.New("websocket: handshake requires an HTTP/1.1 GET request")
+	}
+	if !hasToken(req.Header("Connection"), "upgrade") || !hasToken(req.Header("Upgrade"), "websocket") {
+		return "", types.StatusUpgradeRequired, errors.New("websocket: missing upgrade headers")
+	}
+	if req.Header("Sec-WebSocket-Version") != "13" {
+		return "", types.StatusUpgradeRequired, errors.New("websocket: unsupported version")
+	}
+	key := req.Header("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return "", types.StatusBadRequest, errors.New("websocket: invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return "", types.StatusForbidden, errors.New("websocket: origin not allowed")
+	}
+	return key, types.StatusOK, nil
+}
+
+func (u Upgrader) selectSubprotocol(offered string) string {
+	for _, supported := range u.Subprotocols {
+		for _, p := range strings.Split(offered, ",") {
+			if strings.TrimSpace(p) == supported {
+				return supported
+			}
+		}
+	}
+	return ""
+}
+
+func sameOrigin(req types.Request) bool {
+	origin := req.Header("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Header("Host"))
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func hasToken(value, token string) bool {
+	for _, v := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
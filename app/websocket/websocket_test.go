@@ -0,0 +1,373 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/app/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func handshakeRequest(headers map[string]string) types.Request {
+	req := types.Request{
+		Method:  types.Get,
+		Version: "HTTP/1.1",
+		Target:  "/ws",
+		Headers: map[string]string{
+			"Host":                  "example.com",
+			"Connection":            "keep-alive, Upgrade",
+			"Upgrade":               "websocket",
+			"Sec-WebSocket-Version": "13",
+			"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+		},
+	}
+	for k, v := range headers {
+		if v == "" {
+			delete(req.Headers, k)
+		} else {
+			req.Headers[k] = v
+		}
+	}
+	return req
+}
+
+func runHandshake(u Upgrader, req types.Request, h Handler) types.Response {
+	res := types.Response{Status: types.StatusOK, Headers: make(map[string]string)}
+	u.Handler(h)(context.Background(), req, &res)
+	return res
+}
+
+func echo(ctx context.Context, req types.Request, conn *Conn) {
+	for {
+		t, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(t, data); err != nil {
+			return
+		}
+	}
+}
+
+// pipeHijacker hands out the server end of a pipe as the hijacked
+// connection.
+type pipeHijacker struct {
+	conn net.Conn
+}
+
+func (h pipeHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+// dial upgrades req with u over a pipe and returns its client end, a reader
+// positioned after the handshake response and that response's headers.
+func dial(t *testing.T, u Upgrader, req types.Request, h Handler) (net.Conn, *bufio.Reader, map[string]string) {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	ctx := types.WithHijacker(context.Background(), pipeHijacker{conn: serverConn})
+	go func() {
+		res := types.Response{Status: types.StatusOK, Headers: make(map[string]string)}
+		u.Handler(h)(ctx, req, &res)
+	}()
+	clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { clientConn.Close() })
+
+	r := bufio.NewReader(clientConn)
+	status, err := r.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	headers := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		k, v, _ := strings.Cut(line, ": ")
+		headers[k] = v
+	}
+	return clientConn, r, headers
+}
+
+func writeClientFrame(t *testing.T, w io.Writer, opcode byte, fin, rsv1, masked bool, payload []byte) {
+	t.Helper()
+	b0 := opcode
+	if fin {
+		b0 |= finBit
+	}
+	if rsv1 {
+		b0 |= rsv1Bit
+	}
+	frame := []byte{b0}
+	var lenByte byte
+	if masked {
+		lenByte = maskBit
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, lenByte|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, lenByte|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, lenByte|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	data := append([]byte(nil), payload...)
+	if masked {
+		mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+		frame = append(frame, mask[:]...)
+		maskBytes(mask, data)
+	}
+	_, err := w.Write(append(frame, data...))
+	require.NoError(t, err)
+}
+
+func readServerFrame(t *testing.T, r *bufio.Reader) frame {
+	t.Helper()
+	var head [2]byte
+	_, err := io.ReadFull(r, head[:])
+	require.NoError(t, err)
+	require.Zero(t, head[1]&maskBit, "server frames must not be masked")
+	length := int(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(r, ext[:])
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(r, ext[:])
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	require.NoError(t, err)
+	return frame{fin: head[0]&finBit != 0, rsv1: head[0]&rsv1Bit != 0, opcode: head[0] & 0x0f, payload: payload}
+}
+
+func expectClose(t *testing.T, r *bufio.Reader, code int) {
+	t.Helper()
+	f := readServerFrame(t, r)
+	require.Equal(t, opClose, f.opcode)
+	require.GreaterOrEqual(t, len(f.payload), 2)
+	assert.Equal(t, code, int(binary.BigEndian.Uint16(f.payload)))
+}
+
+func TestHandshake(t *testing.T) {
+	_, _, headers := dial(t, Upgrader{Subprotocols: []string{"v2.chat", "chat"}}, handshakeRequest(map[string]string{
+		"Sec-WebSocket-Protocol": "chat, v2.chat",
+		"Origin":                 "https://example.com",
+	}), echo)
+
+	assert.Equal(t, "websocket", headers["Upgrade"])
+	assert.Equal(t, "Upgrade", headers["Connection"])
+	// Example from RFC 6455 §1.3.
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", headers["Sec-WebSocket-Accept"])
+	assert.Equal(t, "v2.chat", headers["Sec-WebSocket-Protocol"], "server preference should win")
+	assert.NotContains(t, headers, "Sec-WebSocket-Extensions")
+}
+
+func TestHandshakeRequiresHijacker(t *testing.T) {
+	called := false
+	res := runHandshake(Upgrader{}, handshakeRequest(nil), func(context.Context, types.Request, *Conn) { called = true })
+	assert.Equal(t, types.StatusInternalServerError, res.Status)
+	assert.False(t, called)
+}
+
+func TestHandshakeRejections(t *testing.T) {
+	tests := []struct {
+		name       string
+		upgrader   Upgrader
+		req        types.Request
+		wantStatus types.Status
+	}{
+		{"missing upgrade header", Upgrader{}, handshakeRequest(map[string]string{"Upgrade": ""}), types.StatusUpgradeRequired},
+		{"unsupported version", Upgrader{}, handshakeRequest(map[string]string{"Sec-WebSocket-Version": "8"}), types.StatusUpgradeRequired},
+		{"invalid key", Upgrader{}, handshakeRequest(map[string]string{"Sec-WebSocket-Key": "c2hvcnQ="}), types.StatusBadRequest},
+		{"wrong method", Upgrader{}, func() types.Request {
+			r := handshakeRequest(nil)
+			r.Method = types.Post
+			return r
+		}(), types.StatusBadRequest},
+		{"cross origin", Upgrader{}, handshakeRequest(map[string]string{"Origin": "https://evil.test"}), types.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runHandshake(tt.upgrader, tt.req, echo)
+			assert.Equal(t, tt.wantStatus, res.Status)
+			if tt.wantStatus == types.StatusUpgradeRequired {
+				assert.Equal(t, "13", res.Headers["Sec-WebSocket-Version"])
+			}
+		})
+	}
+
+	allowAll := Upgrader{CheckOrigin: func(types.Request) bool { return true }}
+	dial(t, allowAll, handshakeRequest(map[string]string{"Origin": "https://evil.test"}), echo)
+}
+
+func TestNegotiateDeflate(t *testing.T) {
+	want := "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+	assert.Equal(t, want, negotiateDeflate("permessage-deflate; client_max_window_bits"))
+	assert.Equal(t, want, negotiateDeflate("permessage-deflate; server_max_window_bits=10, permessage-deflate"))
+	assert.Equal(t, "", negotiateDeflate("permessage-deflate; server_max_window_bits=10"))
+	assert.Equal(t, "", negotiateDeflate("x-webkit-deflate-frame"))
+}
+
+func TestEchoWithFragmentationAndPing(t *testing.T) {
+	conn, r, _ := dial(t, Upgrader{}, handshakeRequest(nil), echo)
+
+	writeClientFrame(t, conn, opText, true, false, true, []byte("hello"))
+	f := readServerFrame(t, r)
+	assert.Equal(t, opText, f.opcode)
+	assert.True(t, f.fin)
+	assert.Equal(t, "hello", string(f.payload))
+
+	writeClientFrame(t, conn, opBinary, false, false, true, []byte{1, 2})
+	writeClientFrame(t, conn, opPing, true, false, true, []byte("are you there"))
+	pong := readServerFrame(t, r)
+	assert.Equal(t, opPong, pong.opcode)
+	assert.Equal(t, "are you there", string(pong.payload))
+	writeClientFrame(t, conn, opContinuation, true, false, true, []byte{3})
+
+	f = readServerFrame(t, r)
+	assert.Equal(t, opBinary, f.opcode)
+	assert.Equal(t, []byte{1, 2, 3}, f.payload)
+
+	writeClientFrame(t, conn, opClose, true, false, true, closePayload(CloseNormalClosure, "bye"))
+	expectClose(t, r, CloseNormalClosure)
+}
+
+func TestHandlerSeesCloseError(t *testing.T) {
+	gotErr := make(chan error, 1)
+	conn, r, _ := dial(t, Upgrader{}, handshakeRequest(nil), func(ctx context.Context, req types.Request, c *Conn) {
+		_, _, err := c.ReadMessage()
+		gotErr <- err
+	})
+
+	writeClientFrame(t, conn, opClose, true, false, true, closePayload(CloseGoingAway, "navigating away"))
+	expectClose(t, r, CloseGoingAway)
+
+	var ce *CloseError
+	require.ErrorAs(t, <-gotErr, &ce)
+	assert.Equal(t, CloseGoingAway, ce.Code)
+	assert.Equal(t, "navigating away", ce.Reason)
+}
+
+func TestProtocolViolations(t *testing.T) {
+	tests := []struct {
+		name     string
+		upgrader Upgrader
+		send     func(t *testing.T, w io.Writer)
+		wantCode int
+	}{
+		{"unmasked frame", Upgrader{}, func(t *testing.T, w io.Writer) {
+			writeClientFrame(t, w, opText, true, false, false, []byte("hi"))
+		}, CloseProtocolError},
+		{"invalid utf-8", Upgrader{}, func(t *testing.T, w io.Writer) {
+			writeClientFrame(t, w, opText, true, false, true, []byte{0xff, 0xfe})
+		}, CloseInvalidPayload},
+		{"continuation without start", Upgrader{}, func(t *testing.T, w io.Writer) {
+			writeClientFrame(t, w, opContinuation, true, false, true, []byte("x"))
+		}, CloseProtocolError},
+		{"fragmented control frame", Upgrader{}, func(t *testing.T, w io.Writer) {
+			writeClientFrame(t, w, opPing, false, false, true, nil)
+		}, CloseProtocolError},
+		{"compressed without negotiation", Upgrader{}, func(t *testing.T, w io.Writer) {
+			writeClientFrame(t, w, opText, true, true, true, []byte("x"))
+		}, CloseProtocolError},
+		{"message too big", Upgrader{ReadLimit: 8}, func(t *testing.T, w io.Writer) {
+			writeClientFrame(t, w, opBinary, false, false, true, []byte("12345"))
+			writeClientFrame(t, w, opContinuation, true, false, true, []byte("67890"))
+		}, CloseMessageTooBig},
+		{"negative limit uses the default", Upgrader{ReadLimit: -1}, func(t *testing.T, w io.Writer) {
+			head := []byte{finBit | opBinary, maskBit | 127}
+			head = binary.BigEndian.AppendUint64(head, 1<<40)
+			_, err := w.Write(append(head, 0x12, 0x34, 0x56, 0x78))
+			require.NoError(t, err)
+		}, CloseMessageTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, r, _ := dial(t, tt.upgrader, handshakeRequest(nil), echo)
+			// The server may stop reading mid-frame, so write from a
+			// goroutine to keep the pipe from blocking the close reply.
+			var buf bytes.Buffer
+			tt.send(t, &buf)
+			go conn.Write(buf.Bytes())
+			expectClose(t, r, tt.wantCode)
+		})
+	}
+}
+
+func inflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader([]byte{0, 0, 0xff, 0xff, 1, 0, 0, 0xff, 0xff})))
+	out, err := io.ReadAll(fr)
+	require.NoError(t, err)
+	return out
+}
+
+func TestPermessageDeflate(t *testing.T) {
+	message := strings.Repeat("compressible websocket payload ", 20)
+	conn, r, headers := dial(t, Upgrader{EnableCompression: true},
+		handshakeRequest(map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate; client_max_window_bits"}), echo)
+	require.Contains(t, headers["Sec-WebSocket-Extensions"], "permessage-deflate")
+
+	compressed, err := compressMessage([]byte(message), flate.BestSpeed)
+	require.NoError(t, err)
+	writeClientFrame(t, conn, opText, true, true, true, compressed)
+
+	f := readServerFrame(t, r)
+	assert.True(t, f.rsv1, "echo should be compressed")
+	assert.Less(t, len(f.payload), len(message))
+	assert.Equal(t, message, string(inflate(t, f.payload)))
+
+	writeClientFrame(t, conn, opText, true, false, true, []byte("short"))
+	f = readServerFrame(t, r)
+	assert.False(t, f.rsv1, "small messages are sent uncompressed")
+	assert.Equal(t, "short", string(f.payload))
+}
+
+func TestNextWriterFragmentsCompressedMessage(t *testing.T) {
+	parts := []string{strings.Repeat("first part ", 10), strings.Repeat("second part ", 10)}
+	_, r, _ := dial(t, Upgrader{EnableCompression: true},
+		handshakeRequest(map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate"}),
+		func(ctx context.Context, req types.Request, c *Conn) {
+			w, err := c.NextWriter(TextMessage)
+			if err != nil {
+				return
+			}
+			for _, p := range parts {
+				w.Write([]byte(p))
+			}
+			w.Close()
+			c.ReadMessage()
+		})
+
+	var payload []byte
+	first := readServerFrame(t, r)
+	assert.Equal(t, opText, first.opcode)
+	assert.True(t, first.rsv1)
+	assert.False(t, first.fin)
+	payload = append(payload, first.payload...)
+	for f := first; !f.fin; {
+		f = readServerFrame(t, r)
+		assert.Equal(t, opContinuation, f.opcode)
+		assert.False(t, f.rsv1)
+		payload = append(payload, f.payload...)
+	}
+	assert.Equal(t, strings.Join(parts, ""), string(inflate(t, payload)))
+}